@@ -1,8 +1,10 @@
 package ast
 
 import (
-	"../token"
 	"bytes"
+	"strings"
+
+	"go_raster_eval/token"
 )
 
 // The base Node interface
@@ -60,6 +62,117 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+type LetStatement struct {
+	Token token.Token // the token.LET token
+	Name  *Identifier
+	Value Expression
+}
+
+func (ls *LetStatement) statementNode()       {}
+func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ls.TokenLiteral() + " ")
+	out.WriteString(ls.Name.String())
+	out.WriteString(" = ")
+
+	if ls.Value != nil {
+		out.WriteString(ls.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// OutStatement designates an output of a program, such as out ndvi = ...;
+// Programs with outputs produce all of them rather than their last value.
+type OutStatement struct {
+	Token token.Token // the token.OUT token
+	Name  *Identifier
+	Value Expression
+}
+
+func (os *OutStatement) statementNode()       {}
+func (os *OutStatement) TokenLiteral() string { return os.Token.Literal }
+func (os *OutStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(os.TokenLiteral() + " ")
+	out.WriteString(os.Name.String())
+	out.WriteString(" = ")
+
+	if os.Value != nil {
+		out.WriteString(os.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+type ReturnStatement struct {
+	Token       token.Token // the 'return' token
+	ReturnValue Expression
+}
+
+func (rs *ReturnStatement) statementNode()       {}
+func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(rs.TokenLiteral() + " ")
+
+	if rs.ReturnValue != nil {
+		out.WriteString(rs.ReturnValue.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// DefStatement is the short form for naming a single expression function:
+// def ndvi(n, r) = (n - r) / (n + r);
+type DefStatement struct {
+	Token    token.Token // the 'def' token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (ds *DefStatement) statementNode()       {}
+func (ds *DefStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DefStatement) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ds.Function.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(ds.TokenLiteral() + " ")
+	out.WriteString(ds.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") = ")
+	out.WriteString(ds.Function.Body.String())
+	out.WriteString(";")
+
+	return out.String()
+}
+
+type ImportStatement struct {
+	Token token.Token // the 'import' token
+	Path  string
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string {
+	return is.TokenLiteral() + " \"" + is.Path + "\";"
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
@@ -144,3 +257,80 @@ func (oe *InfixExpression) String() string {
 
 	return out.String()
 }
+
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) String() string       { return "\"" + sl.Token.Literal + "\"" }
+
+type FunctionLiteral struct {
+	Token      token.Token // The 'fn' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (fl *FunctionLiteral) expressionNode()      {}
+func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range fl.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(fl.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString("{")
+	out.WriteString(fl.Body.String())
+	out.WriteString("}")
+
+	return out.String()
+}
+
+type CallExpression struct {
+	Token     token.Token // The '(' token
+	Function  Expression  // Identifier of the function being called
+	Arguments []Expression
+}
+
+func (ce *CallExpression) expressionNode()      {}
+func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range ce.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(ce.Function.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// IndexExpression selects a band of a multi-band file, by number as in
+// scene[4] or by name as in scene.nir.
+type IndexExpression struct {
+	Token token.Token // the [ or . token
+	Left  Expression  // Identifier naming the file
+	Index Expression  // Identifier of the name after a dot
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string {
+	if ie.Token.Type == token.DOT {
+		return ie.Left.String() + "." + ie.Index.String()
+	}
+	return ie.Left.String() + "[" + ie.Index.String() + "]"
+}
@@ -0,0 +1,163 @@
+// Package compiler translates programs into the instruction set of the code
+// package, to be executed over blocks of pixels by the vm package.
+package compiler
+
+import (
+	"fmt"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/code"
+	"go_raster_eval/evaluator"
+	"go_raster_eval/object"
+	"go_raster_eval/qa"
+)
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []float32
+	Bands        []string
+	Fields       []qa.Field
+	NumLocals    int
+}
+
+// operand is the result of compiling an expression. Constant expressions
+// are folded and emit no instructions; everything else leaves a raster on
+// the stack, computed by the instructions from start on.
+type operand struct {
+	constant bool
+	value    float32
+	start    int
+}
+
+type Compiler struct {
+	instructions code.Instructions
+	constants    []float32
+	bands        []string
+	bandIndex    map[string]int
+	fields       []qa.Field
+	numLocals    int
+
+	env *object.Environment
+}
+
+// New returns a compiler resolving names through env: values and functions
+// already defined in it, and band aliases of its sensor profile.
+func New(env *object.Environment) *Compiler {
+	return &Compiler{env: env, bandIndex: map[string]int{}}
+}
+
+// Compile compiles a program. Function calls are inlined and let bindings
+// are kept in locals, so every band is loaded once per block and no
+// subexpression is computed twice.
+func (c *Compiler) Compile(program *ast.Program) error {
+	_, err := evaluator.NewFrontend[*operand](backend{c}, c.env).Compile(program)
+	return err
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.instructions,
+		Constants:    c.constants,
+		Bands:        c.bands,
+		Fields:       c.fields,
+		NumLocals:    c.numLocals,
+	}
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := len(c.instructions)
+	c.instructions = append(c.instructions, ins...)
+	return pos
+}
+
+// insert places an instruction at pos. The instruction set has no jumps,
+// so shifting the instructions that follow is always safe.
+func (c *Compiler) insert(pos int, op code.Opcode, operands ...int) {
+	ins := code.Make(op, operands...)
+	rest := append(code.Instructions{}, c.instructions[pos:]...)
+	c.instructions = append(append(c.instructions[:pos], ins...), rest...)
+}
+
+func (c *Compiler) addConstant(value float32) int {
+	c.constants = append(c.constants, value)
+	return len(c.constants) - 1
+}
+
+// backend lowers programs compiled by the evaluator's front end into
+// instructions.
+type backend struct {
+	*Compiler
+}
+
+func (b backend) Number(value float32) *operand {
+	return &operand{constant: true, value: value}
+}
+
+func (b backend) Constant(op *operand) (float32, bool) {
+	return op.value, op.constant
+}
+
+// Band reads a band, giving each band a single index however often it is
+// used.
+func (b backend) Band(band string) *operand {
+	index, ok := b.bandIndex[band]
+	if !ok {
+		index = len(b.bands)
+		b.bandIndex[band] = index
+		b.bands = append(b.bands, band)
+	}
+	return &operand{start: b.emit(code.OpBand, index)}
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"<":  code.OpLessThan,
+	">":  code.OpGreaterThan,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+	"==": code.OpMask,
+	"#":  code.OpFilter,
+}
+
+// Infix pushes a constant operand where the VM expects it: a constant on
+// the left goes before the instructions of the right operand.
+func (b backend) Infix(operator string, left, right *operand) (*operand, error) {
+	op, ok := infixOpcodes[operator]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator: %s", operator)
+	}
+
+	start := left.start
+	switch {
+	case left.constant:
+		start = right.start
+		b.insert(start, code.OpConstant, b.addConstant(left.value))
+	case right.constant:
+		b.emit(code.OpConstant, b.addConstant(right.value))
+	}
+	b.emit(op)
+
+	return &operand{start: start}, nil
+}
+
+func (b backend) QA(band *operand, field qa.Field) (*operand, error) {
+	b.fields = append(b.fields, field)
+	b.emit(code.OpCallBuiltin, code.BuiltinQA, len(b.fields)-1)
+	return &operand{start: band.start}, nil
+}
+
+// Bind stores a raster in a new local.
+func (b backend) Bind(op *operand) func() *operand {
+	local := b.numLocals
+	b.numLocals++
+	b.emit(code.OpSetLocal, local)
+	return func() *operand { return &operand{start: b.emit(code.OpGetLocal, local)} }
+}
+
+func (b backend) Discard(op *operand) {
+	b.emit(code.OpPop)
+}
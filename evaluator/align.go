@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+)
+
+// AlignBands lists the bands program reads in the alignment of the
+// evaluation options, so the finest or coarsest of them can be chosen as
+// the common grid before any is read. The program is evaluated over a
+// single pixel of every band to find them. Other alignments are left as
+// they are.
+func AlignBands(program *ast.Program, env *object.Environment) error {
+	options := env.Options()
+	align := options.Align
+	if align == nil || align.Grid != "finest" && align.Grid != "coarsest" {
+		return nil
+	}
+
+	saved := *options
+	defer func() { *options = saved }()
+	recorder := &recordingSource{Source: bandSource(options)}
+	options.Source = recorder
+	options.Align = nil
+	options.Region, options.BBox = nil, nil
+	options.Grid, options.Cache = nil, nil
+	options.Window = &raster.Window{Width: 1, Height: 1}
+
+	if err, ok := Eval(program, env).(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+	align.Bands = recorder.bands
+	return nil
+}
+
+// recordingSource records the bands read from a source.
+type recordingSource struct {
+	raster.Source
+	bands []string
+}
+
+func (s *recordingSource) Read(band string, overview int, window *raster.Window) (*raster.FlexRaster, error) {
+	found := false
+	for _, b := range s.bands {
+		found = found || b == band
+	}
+	if !found {
+		s.bands = append(s.bands, band)
+	}
+	return s.Source.Read(band, overview, window)
+}
+
+// readAligned reads the part of a band selected by the evaluation options
+// onto the common grid of their alignment, settling the grid first if no
+// band has been read yet.
+func readAligned(band string, options *object.Options) (*raster.FlexRaster, error) {
+	source := bandSource(options)
+	if options.Grid == nil {
+		grid, err := alignedGrid(band, options)
+		if err != nil {
+			return nil, err
+		}
+		options.Grid = grid
+	}
+
+	grid := options.Grid
+	window := raster.Window{Width: grid.Width, Height: grid.Height}
+	if options.Window != nil {
+		window = options.Window.Intersect(window)
+	}
+	method := options.Align.Resampling
+	if method == "" {
+		method = raster.Nearest
+	}
+
+	key := raster.NewCacheKey(source, band, options.Overview, &window)
+	key.Grid = fmt.Sprintf("%v %s %s", grid.GeoTransform, raster.CRSKey(grid.Projection), method)
+	if r, ok := cachedBand(key, options); ok {
+		return r, nil
+	}
+
+	r, err := raster.ReadOnto(source, band, options.Overview, grid.Subset(window), method)
+	if err != nil {
+		return nil, err
+	}
+	cacheBand(key, r, options)
+	return r, nil
+}
+
+// alignedGrid returns the common grid of the alignment of the options,
+// within the region of interest. band is the first band read.
+func alignedGrid(band string, options *object.Options) (*raster.Info, error) {
+	source := bandSource(options)
+	align := options.Align
+
+	candidates := []string{band}
+	switch align.Grid {
+	case "finest", "coarsest":
+		if len(align.Bands) > 0 {
+			candidates = align.Bands
+		}
+	default:
+		candidates = []string{align.Grid}
+		if options.Sensor != nil {
+			candidates[0] = options.Sensor.Resolve(align.Grid)
+		}
+	}
+
+	if options.PreviewSize > 0 && options.Overview == 0 {
+		overview, err := raster.BestOverview(source, candidates[0], options.PreviewSize)
+		if err != nil {
+			return nil, err
+		}
+		options.Overview = overview
+	}
+
+	var info *raster.Info
+	for _, candidate := range candidates {
+		c, err := source.Describe(candidate, options.Overview)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case info == nil,
+			align.Grid == "finest" && pixelArea(c) < pixelArea(info),
+			align.Grid == "coarsest" && pixelArea(c) > pixelArea(info):
+			info = c
+		}
+	}
+
+	if align.PixelSize > 0 {
+		resized, err := info.Resized(align.PixelSize)
+		if err != nil {
+			return nil, err
+		}
+		info = resized
+	}
+
+	var region *raster.Window
+	if options.BBox != nil {
+		w, err := info.BBoxWindow(*options.BBox)
+		if err != nil {
+			return nil, fmt.Errorf("common grid: %v", err)
+		}
+		region = &w
+	} else if options.Region != nil {
+		w := options.Region.Intersect(raster.Window{Width: info.Width, Height: info.Height})
+		if w.Empty() {
+			return nil, fmt.Errorf("Region %v outside of the common grid", *options.Region)
+		}
+		region = &w
+	}
+	if region != nil {
+		info = info.Subset(*region)
+	}
+	return info, nil
+}
+
+// pixelArea returns the area a pixel of a grid covers.
+func pixelArea(info *raster.Info) float64 {
+	gt := info.GeoTransform
+	return math.Abs(gt[1]*gt[5] - gt[2]*gt[4])
+}
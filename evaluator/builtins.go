@@ -0,0 +1,92 @@
+package evaluator
+
+import (
+	"go_raster_eval/indices"
+	"go_raster_eval/object"
+	"go_raster_eval/qa"
+	"go_raster_eval/raster"
+)
+
+var builtins = map[string]*object.Builtin{
+	// qa(BQA, "cloud") or qa(BQA, "cloud_confidence", "landsat8_c2")
+	"qa": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments to `qa`. got=%d, want=2 or 3", len(args))
+			}
+			if args[0].Type() != object.RASTER_OBJ {
+				return newError("first argument to `qa` must be RASTER, got %s", args[0].Type())
+			}
+			for _, arg := range args[1:] {
+				if arg.Type() != object.STRING_OBJ {
+					return newError("`qa` field and profile arguments must be STRING, got %s", arg.Type())
+				}
+			}
+
+			profileName := qa.DefaultProfile
+			if s := env.Options().Sensor; s != nil && s.QA != "" {
+				profileName = s.QA
+			}
+			if len(args) == 3 {
+				profileName = args[2].(*object.String).Value
+			}
+			profile, err := qa.GetProfile(profileName)
+			if err != nil {
+				return newError("%s", err)
+			}
+			field, err := profile.Field(args[1].(*object.String).Value)
+			if err != nil {
+				return newError("%s", err)
+			}
+
+			return evalQA(args[0].(*object.Raster).Value, field)
+		},
+	},
+}
+
+// indexBuiltin wraps a catalogue index so it can be called as ndvi(). The
+// formula is evaluated in an environment of its own sharing the caller's
+// options, so band names resolve through the sensor profile selected for
+// the run and not through names the program binds.
+func indexBuiltin(index *indices.Index) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments to `%s`. got=%d, want=0", index.Name, len(args))
+			}
+
+			program, err := index.Program(env.Options().Sensor)
+			if err != nil {
+				return newError("%s", err)
+			}
+			return Eval(program, object.NewEnvironmentWithOptions(env.Options()))
+		},
+	}
+}
+
+func evalQA(band raster.FlexRaster, field qa.Field) object.Object {
+	if band.RasterType != raster.UINT16 && band.RasterType != raster.UINT8 {
+		return newError("QA decoding not implemented for type %s", band.RasterType)
+	}
+
+	rasterType := raster.UINT8
+	if field.IsFlag() {
+		rasterType = raster.BOOL
+	}
+
+	canvas := make([]uint8, band.Len())
+	switch data := band.Data.(type) {
+	case []uint16:
+		for i, val := range data {
+			canvas[i] = uint8(field.Decode(val))
+		}
+	case []uint8:
+		for i, val := range data {
+			canvas[i] = uint8(field.Decode(uint16(val)))
+		}
+	}
+
+	// Every decoded value is a valid level, so the band's nodata value,
+	// which may well be one of them, isn't carried over.
+	return &object.Raster{Value: raster.FlexRaster{RasterType: rasterType, Width: band.Width, Height: band.Height, Data: canvas, NoData: raster.NoNoData}}
+}
@@ -1,10 +1,13 @@
 package evaluator
 
 import (
-	"../ast"
-	"../object"
-	"../raster"
 	"fmt"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/indices"
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+	"go_raster_eval/token"
 )
 
 var (
@@ -18,7 +21,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		defer BeginRun(env.Options())()
+		if result := evalProgram(node, env); result != nil {
+			return result
+		}
+		return newError("program has no result: it must end with an expression, a return or out statements")
 
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
@@ -26,10 +33,38 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 
+	case *ast.ReturnStatement:
+		val := Eval(node.ReturnValue, env)
+		if isError(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+
+	case *ast.LetStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+
+	case *ast.OutStatement:
+		return newError("out statements are only allowed at the top level of a program, not in %s", node.String())
+
+	case *ast.ImportStatement:
+		if err := evalImportStatement(node, env); err != nil {
+			return err
+		}
+
+	case *ast.DefStatement:
+		env.Set(node.Name.Value, &object.Function{Parameters: node.Function.Parameters, Env: env, Body: node.Function.Body})
+
 	// Expressions
 	case *ast.NumberLiteral:
 		return &object.Number{Value: node.Value}
 
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
@@ -51,20 +86,49 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, env.Options().Workers)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
+
+	case *ast.IndexExpression:
+		return evalIndexExpression(node, env)
+
+	case *ast.FunctionLiteral:
+		return &object.Function{Parameters: node.Parameters, Env: env, Body: node.Body}
+
+	case *ast.CallExpression:
+		function := evalFunction(node.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+
+		return applyFunction(function, args, env)
 	}
 
 	return nil
 }
 
+// evalProgram returns the value of the last statement of program, or the
+// outputs of its out statements if it has any.
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
+	var outputs *object.Outputs
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		if out, ok := statement.(*ast.OutStatement); ok {
+			if outputs == nil {
+				outputs = &object.Outputs{}
+			}
+			result = evalOutStatement(out, outputs, env)
+		} else {
+			result = Eval(statement, env)
+		}
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -74,9 +138,44 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		}
 	}
 
+	if outputs != nil {
+		return outputs
+	}
 	return result
 }
 
+// evalOutStatement adds an output to outputs, binding its name like a let
+// statement so later statements can use it.
+func evalOutStatement(node *ast.OutStatement, outputs *object.Outputs, env *object.Environment) object.Object {
+	name := node.Name.Value
+	for _, n := range outputs.Names {
+		if n == name {
+			return newError("output %s is given twice", name)
+		}
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	env.Set(name, val)
+	outputs.Names = append(outputs.Names, name)
+	outputs.Values = append(outputs.Values, val)
+	return nil
+}
+
+// OutputNames returns the names of the outputs of program, in the order of
+// its out statements, or nil if it has none.
+func OutputNames(program *ast.Program) []string {
+	var names []string
+	for _, statement := range program.Statements {
+		if out, ok := statement.(*ast.OutStatement); ok {
+			names = append(names, out.Name.Value)
+		}
+	}
+	return names
+}
+
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -112,16 +211,16 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func evalInfixExpression(operator string, left, right object.Object, workers int) object.Object {
 	switch {
 	case left.Type() == object.NUMBER_OBJ && right.Type() == object.NUMBER_OBJ:
 		return evalNUMBERInfixExpression(operator, left, right)
 	case left.Type() == object.RASTER_OBJ && right.Type() == object.NUMBER_OBJ:
-		return evalRASTERNUMBERInfixExpression(operator, left, right)
+		return evalRASTERNUMBERInfixExpression(operator, left, right, workers)
 	case left.Type() == object.NUMBER_OBJ && right.Type() == object.RASTER_OBJ:
-		return evalRASTERNUMBERInfixExpression(operator, right, left)
+		return evalNUMBERRASTERInfixExpression(operator, left, right, workers)
 	case left.Type() == object.RASTER_OBJ && right.Type() == object.RASTER_OBJ:
-		return evalRASTERInfixExpression(operator, left, right)
+		return evalRASTERInfixExpression(operator, left, right, workers)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -174,6 +273,10 @@ func evalNUMBERInfixExpression(operator string, left, right object.Object) objec
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -184,138 +287,490 @@ func evalNUMBERInfixExpression(operator string, left, right object.Object) objec
 	}
 }
 
-func evalRASTERNUMBERInfixExpression(operator string, left, right object.Object) object.Object {
+func evalRASTERNUMBERInfixExpression(operator string, left, right object.Object, workers int) object.Object {
 	leftVal := left.(*object.Raster).Value
-	rightVal := right.(*object.Number).Value
+	rightVal := float64(right.(*object.Number).Value)
+
+	var fn func(dst, src []float64)
+	rasterType := raster.Promote(leftVal.RasterType)
 
-	canvas := make([]float32, leftVal.Width*leftVal.Height)
 	switch operator {
 	case "+":
-		for i, val := range leftVal.Data {
-			canvas[i] = val + rightVal
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = v + rightVal
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "-":
-		for i, val := range leftVal.Data {
-			canvas[i] = val - rightVal
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = v - rightVal
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "*":
-		for i, val := range leftVal.Data {
-			canvas[i] = val * rightVal
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = v * rightVal
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "/":
-		for i, val := range leftVal.Data {
-			canvas[i] = val / rightVal
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = v / rightVal
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "==":
-		fmt.Println("Comparison", leftVal.Width, leftVal.Height)
 		switch leftVal.RasterType {
-		case raster.UINT16:	
-			for i, val := range leftVal.Data {
-				mask := uint16(rightVal)
-				if (uint16(val) & mask) > 0 {
-					canvas[i] = 1.0
-				} else {
-					canvas[i] = 0.0
+		case raster.UINT8:
+			// Byte rasters hold levels and classes, such as decoded QA
+			// confidences or the Sentinel-2 SCL, so they compare for
+			// equality rather than as bit masks.
+			fn = func(dst, src []float64) {
+				for i, v := range src {
+					dst[i] = boolValue(v == rightVal)
 				}
 			}
-		case raster.INT16:	
-			for i, val := range leftVal.Data {
-				mask := int16(rightVal)
-				if (int16(val) & mask) > 0 {
-					canvas[i] = 1.0
-				} else {
-					canvas[i] = 0.0
+		case raster.UINT16:
+			mask := uint16(rightVal)
+			fn = func(dst, src []float64) {
+				for i, v := range src {
+					dst[i] = boolValue(uint16(v)&mask > 0)
+				}
+			}
+		case raster.INT16:
+			mask := int16(rightVal)
+			fn = func(dst, src []float64) {
+				for i, v := range src {
+					dst[i] = boolValue(int16(v)&mask > 0)
+				}
+			}
+		case raster.UINT32:
+			mask := uint32(rightVal)
+			fn = func(dst, src []float64) {
+				for i, v := range src {
+					dst[i] = boolValue(uint32(v)&mask > 0)
+				}
+			}
+		case raster.INT32:
+			mask := int32(rightVal)
+			fn = func(dst, src []float64) {
+				for i, v := range src {
+					dst[i] = boolValue(int32(v)&mask > 0)
 				}
 			}
 		default:
 			return newError(fmt.Sprintf("Masking not implemented for type %s", leftVal.RasterType))
-			
-		}	
-		return &object.Raster{Value: raster.FlexRaster{raster.BOOL, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
+		}
+		rasterType = raster.BOOL
+	case "<", ">", "<=", ">=":
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = boolValue(compare(operator, v, rightVal))
+			}
+		}
+		rasterType = raster.BOOL
 	default:
 		return newError(fmt.Sprintf("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type()))
 	}
+
+	return &object.Raster{Value: mapPixels(leftVal, rasterType, workers, fn)}
 }
 
-func evalRASTERInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.Raster).Value
+// evalNUMBERRASTERInfixExpression handles a number on the left hand side.
+// Commutative operators reuse the RASTER NUMBER kernels and comparisons are
+// mirrored, so only subtraction and division need kernels of their own.
+func evalNUMBERRASTERInfixExpression(operator string, left, right object.Object, workers int) object.Object {
+	leftVal := float64(left.(*object.Number).Value)
 	rightVal := right.(*object.Raster).Value
-	fmt.Println(leftVal.Width, leftVal.Height, rightVal.Width, rightVal.Height)
-	if leftVal.Width != rightVal.Width || leftVal.Height != rightVal.Height {
-		return newError(fmt.Sprintf("non compatible rasters: Different width/height dimensions found. %d*%d %d*%d", leftVal.Width, leftVal.Height, rightVal.Width, rightVal.Height))
+
+	var fn func(dst, src []float64)
+
+	switch operator {
+	case "-":
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = leftVal - v
+			}
+		}
+	case "/":
+		fn = func(dst, src []float64) {
+			for i, v := range src {
+				dst[i] = leftVal / v
+			}
+		}
+	case "<":
+		return evalRASTERNUMBERInfixExpression(">", right, left, workers)
+	case ">":
+		return evalRASTERNUMBERInfixExpression("<", right, left, workers)
+	case "<=":
+		return evalRASTERNUMBERInfixExpression(">=", right, left, workers)
+	case ">=":
+		return evalRASTERNUMBERInfixExpression("<=", right, left, workers)
+	default:
+		return evalRASTERNUMBERInfixExpression(operator, right, left, workers)
 	}
 
-	if len(leftVal.Data) != len(rightVal.Data) {
-		return newError(fmt.Sprintf("non compatible rasters: Different data dimensions found: %d and %d", len(leftVal.Data), len(rightVal.Data)))
+	return &object.Raster{Value: mapPixels(rightVal, raster.Promote(rightVal.RasterType), workers, fn)}
+}
+
+func compare(operator string, left, right float64) bool {
+	switch operator {
+	case "<":
+		return left < right
+	case ">":
+		return left > right
+	case "<=":
+		return left <= right
+	default:
+		return left >= right
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1.0
 	}
+	return 0.0
+}
 
-	canvas := make([]float32, leftVal.Width*leftVal.Height)
+func evalRASTERInfixExpression(operator string, left, right object.Object, workers int) object.Object {
+	leftVal := left.(*object.Raster).Value
+	rightVal := right.(*object.Raster).Value
+	if leftVal.Width != rightVal.Width || leftVal.Height != rightVal.Height {
+		return newError(fmt.Sprintf("non compatible rasters: Different width/height dimensions found. %d*%d %d*%d", leftVal.Width, leftVal.Height, rightVal.Width, rightVal.Height))
+	}
 
 	switch operator {
 	case "#":
-		fmt.Println("Start filtering")
 		if rightVal.RasterType != raster.BOOL {
 			return newError("Raster on the right must be a Boolean raster type.")
 		}
-		for i, val := range rightVal.Data {
-			if val == 1.0 {
-				canvas[i] = leftVal.NoData
-			} else {
-				canvas[i] = leftVal.Data[i]
+		rasterType, noData := raster.Masked(leftVal.RasterType, leftVal.NoData)
+		out := zipPixels(leftVal, rightVal, rasterType, workers, func(dst, l, r []float64) {
+			for i := range dst {
+				if r[i] == 1.0 {
+					dst[i] = noData
+				} else {
+					dst[i] = l[i]
+				}
 			}
-		}
-		fmt.Println("Done filtering")
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
-	}
-
-	if leftVal.RasterType != rightVal.RasterType {
-		return newError("non compatible rasters: Different RasterType values found.")
+		})
+		out.NoData = noData
+		return &object.Raster{Value: out}
 	}
 
 	if leftVal.NoData != rightVal.NoData {
 		return newError("non compatible rasters: Different NoData values found.")
 	}
 
+	var fn func(dst, l, r []float64)
+
 	switch operator {
 	case "+":
-		for i, val := range leftVal.Data {
-			canvas[i] = val + rightVal.Data[i]
+		fn = func(dst, l, r []float64) {
+			for i := range dst {
+				dst[i] = l[i] + r[i]
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "-":
-		for i, val := range leftVal.Data {
-			canvas[i] = val - rightVal.Data[i]
+		fn = func(dst, l, r []float64) {
+			for i := range dst {
+				dst[i] = l[i] - r[i]
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "*":
-		for i, val := range leftVal.Data {
-			canvas[i] = val * rightVal.Data[i]
+		fn = func(dst, l, r []float64) {
+			for i := range dst {
+				dst[i] = l[i] * r[i]
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
 	case "/":
-		for i, val := range leftVal.Data {
-			canvas[i] = val / rightVal.Data[i]
+		fn = func(dst, l, r []float64) {
+			for i := range dst {
+				dst[i] = l[i] / r[i]
+			}
 		}
-		return &object.Raster{Value: raster.FlexRaster{leftVal.RasterType, int(leftVal.Width), int(leftVal.Height), canvas, leftVal.NoData}}
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	}
 
-	return newError("unknown operator: %s %s %s",
-		left.Type(), operator, right.Type())
+	rasterType := raster.Promote(leftVal.RasterType, rightVal.RasterType)
+	return &object.Raster{Value: zipPixels(leftVal, rightVal, rasterType, workers, fn)}
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	r, err := raster.GetRaster(node.Value)
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+
+	r, err := ReadBand(resolveBand(node.Value, env), env.Options())
+	if err != nil {
+		return newError("Raster reading operation failed: %v", err)
+	}
+	return &object.Raster{Value: *r}
+}
+
+func evalIndexExpression(node *ast.IndexExpression, env *object.Environment) object.Object {
+	var index object.Object
+	if node.Token.Type != token.DOT {
+		index = Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+	}
+
+	band, err := SelectBand(node, index, env)
 	if err != nil {
-		return newError("Raster reading operation failed")
+		return newError("%v", err)
+	}
+	r, err := ReadBand(band, env.Options())
+	if err != nil {
+		return newError("Raster reading operation failed: %v", err)
 	}
 	return &object.Raster{Value: *r}
 }
 
+// SelectBand returns the band of a multi-band file an index expression
+// selects, given the value of the index in brackets: a number selects the
+// band by number, from 1, and a string by name. Bands selected after a dot,
+// as in scene.nir, are selected by name and index is ignored. The file name
+// goes through the sensor profile like other band names.
+func SelectBand(node *ast.IndexExpression, index object.Object, env *object.Environment) (string, error) {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return "", fmt.Errorf("cannot select a band of %s", node.Left.String())
+	}
+	if val, ok := env.Get(ident.Value); ok {
+		return "", fmt.Errorf("cannot select a band of %s value bound to %s", val.Type(), ident.Value)
+	}
+	name := resolveBand(ident.Value, env)
+
+	if node.Token.Type == token.DOT {
+		return raster.NamedBand(name, node.Index.String()), nil
+	}
+	switch index := index.(type) {
+	case *object.Number:
+		n := int(index.Value)
+		if float32(n) != index.Value || n < 1 {
+			return "", fmt.Errorf("band number must be a positive integer, got %v", index.Value)
+		}
+		return raster.IndexedBand(name, n), nil
+	case *object.String:
+		return raster.NamedBand(name, index.Value), nil
+	}
+	return "", fmt.Errorf("band index must be NUMBER or STRING, got %s", index.Type())
+}
+
+func resolveBand(name string, env *object.Environment) string {
+	if s := env.Options().Sensor; s != nil {
+		return s.Resolve(name)
+	}
+	return name
+}
+
+// BeginRun prepares options for evaluating a program with any of the
+// strategies, setting up the cache that has each band read once during the
+// run and the scripts it imports, unless an enclosing run, such as the one
+// importing a script, already has. Imports already set up, as they are for
+// the tiles of a tiled evaluation, are shared with the enclosing run. The
+// function returned ends the run.
+func BeginRun(options *object.Options) (end func()) {
+	if options.Bands != nil {
+		return func() {}
+	}
+	options.Bands = raster.NewCache(0)
+	if options.Imports != nil {
+		return func() { options.Bands = nil }
+	}
+	options.Imports = map[string]*ast.Program{}
+	return func() { options.Bands, options.Imports = nil, nil }
+}
+
+// ReadBand reads the part of a band selected by the evaluation options, at
+// their overview, recording the band's grid if it is the first one read.
+// Bands already read during the evaluation, or held by the options cache,
+// are not read again.
+func ReadBand(band string, options *object.Options) (*raster.FlexRaster, error) {
+	if options.Align != nil {
+		return readAligned(band, options)
+	}
+
+	source := bandSource(options)
+	if options.Grid == nil {
+		if options.PreviewSize > 0 && options.Overview == 0 {
+			overview, err := raster.BestOverview(source, band, options.PreviewSize)
+			if err != nil {
+				return nil, err
+			}
+			options.Overview = overview
+		}
+		info, err := source.Describe(band, options.Overview)
+		if err != nil {
+			return nil, err
+		}
+		if options.BBox != nil {
+			options.Regions = raster.NewRegions()
+		}
+		region, err := bandRegion(band, options)
+		if err != nil {
+			return nil, err
+		}
+		if region != nil {
+			r := region.Intersect(raster.Window{Width: info.Width, Height: info.Height})
+			if r.Empty() {
+				return nil, fmt.Errorf("Region %v outside of band %v", *region, band)
+			}
+			info = info.Subset(r)
+		}
+		options.Grid = info
+	}
+
+	window, err := bandWindow(band, options)
+	if err != nil {
+		return nil, err
+	}
+
+	key := raster.NewCacheKey(source, band, options.Overview, window)
+	if r, ok := cachedBand(key, options); ok {
+		return r, nil
+	}
+
+	r, err := source.Read(band, options.Overview, window)
+	if err != nil {
+		return nil, err
+	}
+	cacheBand(key, r, options)
+	return r, nil
+}
+
+// cachedBand looks a read up in the caches of the options, copying reads
+// found in the long-lived cache into the evaluation's own.
+func cachedBand(key raster.CacheKey, options *object.Options) (*raster.FlexRaster, bool) {
+	for _, cache := range []*raster.Cache{options.Bands, options.Cache} {
+		if cache == nil {
+			continue
+		}
+		if r, ok := cache.Get(key); ok {
+			if options.Bands != nil && cache != options.Bands {
+				options.Bands.Put(key, r)
+			}
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// cacheBand keeps a read in the caches of the options.
+func cacheBand(key raster.CacheKey, r *raster.FlexRaster, options *object.Options) {
+	if options.Bands != nil {
+		options.Bands.Put(key, r)
+	}
+	if options.Cache != nil {
+		options.Cache.Put(key, r)
+	}
+}
+
+// bandSource returns the source bands are read from.
+func bandSource(options *object.Options) raster.Source {
+	if options.Source != nil {
+		return options.Source
+	}
+	return raster.DefaultSource
+}
+
+// bandRegion returns the pixels of band within the region of interest, or
+// nil if the options set none.
+func bandRegion(band string, options *object.Options) (*raster.Window, error) {
+	if options.BBox != nil {
+		find := raster.BBoxWindow
+		if options.Regions != nil {
+			find = options.Regions.BBoxWindow
+		}
+		w, err := find(bandSource(options), band, options.Overview, *options.BBox)
+		if err != nil {
+			return nil, err
+		}
+		return &w, nil
+	}
+	return options.Region, nil
+}
+
+// bandWindow returns the pixels of band to read: the options window, which
+// is relative to the grid, moved to the region of interest.
+func bandWindow(band string, options *object.Options) (*raster.Window, error) {
+	region, err := bandRegion(band, options)
+	if err != nil || region == nil {
+		return options.Window, err
+	}
+	if options.Window == nil {
+		return region, nil
+	}
+
+	w := options.Window
+	window := raster.Window{XOff: region.XOff + w.XOff, YOff: region.YOff + w.YOff, Width: w.Width, Height: w.Height}.Intersect(*region)
+	return &window, nil
+}
+
+// evalFunction looks up the callee of a call expression. User definitions
+// come first, then builtins and catalogue indices, all before band names so
+// that qa(qa, "cloud") works with sensor profiles that alias the quality
+// band as qa.
+func evalFunction(node ast.Expression, env *object.Environment) object.Object {
+	if ident, ok := node.(*ast.Identifier); ok {
+		if val, ok := env.Get(ident.Value); ok {
+			return val
+		}
+		if builtin, ok := builtins[ident.Value]; ok {
+			return builtin
+		}
+		if index, ok := indices.Get(ident.Value); ok {
+			return indexBuiltin(index)
+		}
+	}
+	return Eval(node, env)
+}
+
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+		}
+		// The language has no conditionals, so a recursive call never
+		// returns.
+		if env.Calling(fn) {
+			return newError("recursive function call")
+		}
+		extendedEnv := extendFunctionEnv(fn, args, env)
+		evaluated := Eval(fn.Body, extendedEnv)
+		return unwrapReturnValue(evaluated)
+	case *object.Builtin:
+		return fn.Fn(env, args...)
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object, caller *object.Environment) *object.Environment {
+	env := object.NewCallEnvironment(fn, caller)
+
+	for paramIdx, param := range fn.Parameters {
+		env.Set(param.Value, args[paramIdx])
+	}
+
+	return env
+}
+
+func unwrapReturnValue(obj object.Object) object.Object {
+	if returnValue, ok := obj.(*object.ReturnValue); ok {
+		return returnValue.Value
+	}
+
+	return obj
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
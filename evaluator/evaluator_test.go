@@ -0,0 +1,158 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/lexer"
+	"go_raster_eval/object"
+	"go_raster_eval/parser"
+	"go_raster_eval/raster"
+	"go_raster_eval/sensor"
+)
+
+// memSource serves bands held in memory on a single 10 m grid.
+type memSource map[string]*raster.FlexRaster
+
+func (s memSource) Path(band string) string { return band }
+
+func (s memSource) Describe(band string, overview int) (*raster.Info, error) {
+	r, ok := s[band]
+	if !ok {
+		return nil, fmt.Errorf("no band %s", band)
+	}
+	return &raster.Info{Width: r.Width, Height: r.Height, GeoTransform: [6]float64{0, 10, 0, 0, 0, -10}}, nil
+}
+
+func (s memSource) Overviews(band string) (int, error) { return 0, nil }
+
+func (s memSource) Read(band string, overview int, window *raster.Window) (*raster.FlexRaster, error) {
+	r, ok := s[band]
+	if !ok {
+		return nil, fmt.Errorf("no band %s", band)
+	}
+	if window == nil {
+		return r, nil
+	}
+
+	w := window.Intersect(raster.Window{Width: r.Width, Height: r.Height})
+	out := &raster.FlexRaster{RasterType: r.RasterType, Width: w.Width, Height: w.Height, Data: raster.NewData(r.RasterType, w.Width*w.Height), NoData: r.NoData}
+	for y := 0; y < w.Height; y++ {
+		row := r.Float64s((w.YOff+y)*r.Width+w.XOff, (w.YOff+y)*r.Width+w.XOff+w.Width, nil)
+		out.SetFloat64s(y*w.Width, row)
+	}
+	return out, nil
+}
+
+// uint16Band returns a UINT16 band of width*height pixels.
+func uint16Band(width, height int, pixels ...uint16) *raster.FlexRaster {
+	return &raster.FlexRaster{RasterType: raster.UINT16, Width: width, Height: height, Data: pixels, NoData: 0}
+}
+
+// parse parses a program, failing the test on parse errors.
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%s: %v", input, p.Errors())
+	}
+	return program
+}
+
+// evalString evaluates a program with the given options.
+func evalString(t *testing.T, input string, options *object.Options) object.Object {
+	t.Helper()
+	return Eval(parse(t, input), object.NewEnvironmentWithOptions(options))
+}
+
+// pixels returns the pixels of a raster result as float64.
+func pixels(t testing.TB, obj object.Object) []float64 {
+	t.Helper()
+	r, ok := obj.(*object.Raster)
+	if !ok {
+		t.Fatalf("result is %s, not a raster: %s", obj.Type(), obj.Inspect())
+	}
+	return r.Value.Float64s(0, r.Value.Len(), nil)
+}
+
+func TestQAConfidence(t *testing.T) {
+	// Collection 1 BQA values with cloud confidences 0, 1, 2 and 3.
+	options := &object.Options{Source: memSource{"BQA": uint16Band(4, 1, 0, 1<<5, 2<<5, 3<<5|1<<4)}}
+	for input, want := range map[string]string{
+		`qa(BQA, "cloud_confidence") == 3;`: "[0 0 0 1]",
+		`qa(BQA, "cloud_confidence") == 1;`: "[0 1 0 0]",
+		`qa(BQA, "cloud_confidence") >= 2;`: "[0 0 1 1]",
+		`qa(BQA, "cloud");`:                 "[0 0 0 1]",
+	} {
+		if got := fmt.Sprint(pixels(t, evalString(t, input, options))); got != want {
+			t.Errorf("%s = %s, want %s", input, got, want)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	p := parser.New(lexer.New(`qa(BQA, "cloud`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Fatal("unterminated string parsed without error")
+	}
+}
+
+func TestReflectanceIndex(t *testing.T) {
+	s2, err := sensor.GetProfile("sentinel2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reflectances of 0.5 and 0.1.
+	options := &object.Options{Sensor: s2, Source: memSource{"B08": uint16Band(1, 1, 5000), "B04": uint16Band(1, 1, 1000)}}
+	got := pixels(t, evalString(t, "savi();", options))
+	if want := 1.5 * 0.4 / 1.1; math.Abs(got[0]-want) > 1e-6 {
+		t.Errorf("savi() = %v, want %v", got[0], want)
+	}
+
+	l8, _ := sensor.GetProfile("landsat8")
+	options = &object.Options{Sensor: l8, Source: memSource{"B5": uint16Band(1, 1, 5000), "B4": uint16Band(1, 1, 1000)}}
+	if obj := evalString(t, "savi();", options); obj.Type() != object.ERROR_OBJ {
+		t.Errorf("savi() of digital numbers = %s, want an error", obj.Inspect())
+	}
+}
+
+func TestNoResult(t *testing.T) {
+	options := &object.Options{Source: memSource{"B1": uint16Band(1, 1, 1)}}
+	for _, input := range []string{
+		"let x = B1;",
+		"def f(a) = a * 2;",
+		"",
+	} {
+		obj := evalString(t, input, options)
+		err, ok := obj.(*object.Error)
+		if !ok || !strings.Contains(err.Message, "no result") {
+			t.Errorf("%q = %v, want a no result error", input, obj)
+		}
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	options := &object.Options{Source: memSource{"B1": uint16Band(1, 1, 1)}}
+	for _, input := range []string{
+		"def f(x) = f(x) + 1; f(1);",
+		"def f(a) = g(a); def g(a) = f(a) * 2; g(B1);",
+		"let f = fn(a) { let g = fn(b) { f(b) }; g(a) }; f(B1);",
+	} {
+		obj := evalString(t, input, options)
+		err, ok := obj.(*object.Error)
+		if !ok || !strings.Contains(err.Message, "recursive") {
+			t.Errorf("%q = %v, want a recursion error", input, obj)
+		}
+	}
+
+	// Calling a function again once a call has returned isn't recursion.
+	obj := evalString(t, "def f(a) = a * 2; f(f(B1)) + f(B1);", options)
+	if _, ok := obj.(*object.Raster); !ok {
+		t.Errorf("nested calls = %v, want a raster", obj)
+	}
+}
@@ -0,0 +1,9 @@
+package evaluator
+
+// MemSource and Uint16Band let the external tests in package
+// evaluator_test, which import packages that can't be imported here, read
+// bands from memory. Like every _test.go file, this one is only built with
+// the tests of this directory.
+type MemSource = memSource
+
+var Uint16Band = uint16Band
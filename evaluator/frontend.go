@@ -0,0 +1,424 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/indices"
+	"go_raster_eval/object"
+	"go_raster_eval/qa"
+	"go_raster_eval/token"
+)
+
+// Backend lowers what is left of a program once the Frontend has resolved
+// its names, inlined its calls and folded its constants: into the nodes of
+// a fused Kernel for Compile, or into VM instructions for the compiler
+// package. V is the backend's compiled expression.
+type Backend[V any] interface {
+	// Number returns a constant.
+	Number(value float32) V
+	// Constant reports whether v is a constant, and its value.
+	Constant(v V) (float32, bool)
+	// Band reads a band.
+	Band(band string) V
+	// Infix applies an operator to two values, at least one a raster.
+	Infix(operator string, left, right V) (V, error)
+	// QA decodes a field of a quality band.
+	QA(band V, field qa.Field) (V, error)
+	// Bind keeps a raster for a let binding or function argument, and
+	// returns a function giving it back wherever the name is used.
+	Bind(v V) func() V
+	// Discard drops the raster computed by an expression statement that
+	// isn't the result.
+	Discard(v V)
+}
+
+// Frontend compiles programs for a Backend. Function calls and catalogue
+// indices are inlined, with their arguments bound once, and imports are
+// evaluated into the environment.
+type Frontend[V any] struct {
+	backend Backend[V]
+	env     *object.Environment
+
+	// inlining holds the bodies of the functions being inlined. Inlining a
+	// recursive function would never end, so they can't be compiled.
+	inlining map[*ast.BlockStatement]bool
+}
+
+// NewFrontend returns a front end resolving names through env: values and
+// functions already defined in it, and band aliases of its sensor profile.
+func NewFrontend[V any](backend Backend[V], env *object.Environment) *Frontend[V] {
+	return &Frontend[V]{backend: backend, env: env, inlining: map[*ast.BlockStatement]bool{}}
+}
+
+// frontScope holds the names bound while compiling.
+type frontScope[V any] struct {
+	names map[string]*frontBinding[V]
+	outer *frontScope[V]
+}
+
+// frontBinding is either a value or a function.
+type frontBinding[V any] struct {
+	load     func() V
+	function *frontFunction[V]
+}
+
+type frontFunction[V any] struct {
+	parameters []*ast.Identifier
+	body       *ast.BlockStatement
+	scope      *frontScope[V]
+	env        *object.Environment // set for functions defined before compiling
+}
+
+func newFrontScope[V any](outer *frontScope[V]) *frontScope[V] {
+	return &frontScope[V]{names: map[string]*frontBinding[V]{}, outer: outer}
+}
+
+func (s *frontScope[V]) get(name string) (*frontBinding[V], bool) {
+	b, ok := s.names[name]
+	if !ok && s.outer != nil {
+		b, ok = s.outer.get(name)
+	}
+	return b, ok
+}
+
+// Compile compiles a program, returning its result.
+func (f *Frontend[V]) Compile(program *ast.Program) (V, error) {
+	defer BeginRun(f.env.Options())()
+
+	result, ok, err := f.compileStatements(program.Statements, newFrontScope[V](nil))
+	switch {
+	case err != nil:
+	case !ok:
+		err = fmt.Errorf("cannot compile: program has no result")
+	case f.isConstant(result):
+		err = fmt.Errorf("cannot compile: program does not read any raster")
+	}
+	return result, err
+}
+
+func (f *Frontend[V]) isConstant(v V) bool {
+	_, ok := f.backend.Constant(v)
+	return ok
+}
+
+// compileStatements returns the value of the last expression statement, or
+// of the first return statement, reporting false if there is none.
+func (f *Frontend[V]) compileStatements(statements []ast.Statement, scope *frontScope[V]) (V, bool, error) {
+	var result V
+	var ok bool
+
+	for _, statement := range statements {
+		// The value of an expression statement is only kept if it is the
+		// last one.
+		if ok && !f.isConstant(result) {
+			f.backend.Discard(result)
+		}
+		ok = false
+
+		switch statement := statement.(type) {
+		case *ast.ExpressionStatement:
+			v, err := f.compileExpression(statement.Expression, scope)
+			if err != nil {
+				return result, false, err
+			}
+			result, ok = v, true
+
+		case *ast.ReturnStatement:
+			v, err := f.compileExpression(statement.ReturnValue, scope)
+			return v, err == nil, err
+
+		case *ast.LetStatement:
+			if fn, isFn := statement.Value.(*ast.FunctionLiteral); isFn {
+				scope.names[statement.Name.Value] = &frontBinding[V]{function: &frontFunction[V]{parameters: fn.Parameters, body: fn.Body, scope: scope}}
+				continue
+			}
+			v, err := f.compileExpression(statement.Value, scope)
+			if err != nil {
+				return result, false, err
+			}
+			scope.names[statement.Name.Value] = f.bind(v)
+
+		case *ast.DefStatement:
+			scope.names[statement.Name.Value] = &frontBinding[V]{function: &frontFunction[V]{parameters: statement.Function.Parameters, body: statement.Function.Body, scope: scope}}
+
+		case *ast.ImportStatement:
+			if err := evalImportStatement(statement, f.env); err != nil {
+				return result, false, fmt.Errorf("%s", err.Message)
+			}
+
+		default:
+			return result, false, fmt.Errorf("cannot compile statement %s", statement.String())
+		}
+	}
+
+	return result, ok, nil
+}
+
+// bind binds a compiled value to a name. Constants are used as they are,
+// rasters are kept by the backend.
+func (f *Frontend[V]) bind(v V) *frontBinding[V] {
+	if value, ok := f.backend.Constant(v); ok {
+		return &frontBinding[V]{load: func() V { return f.backend.Number(value) }}
+	}
+	return &frontBinding[V]{load: f.backend.Bind(v)}
+}
+
+func (f *Frontend[V]) compileExpression(node ast.Expression, scope *frontScope[V]) (V, error) {
+	var none V
+
+	switch node := node.(type) {
+	case *ast.NumberLiteral:
+		return f.backend.Number(node.Value), nil
+
+	case *ast.PrefixExpression:
+		right, err := f.compileExpression(node.Right, scope)
+		if err != nil {
+			return none, err
+		}
+		value, ok := f.backend.Constant(right)
+		if node.Operator != "-" || !ok {
+			return none, fmt.Errorf("cannot compile prefix expression %s", node.String())
+		}
+		return f.backend.Number(-value), nil
+
+	case *ast.InfixExpression:
+		left, err := f.compileExpression(node.Left, scope)
+		if err != nil {
+			return none, err
+		}
+		right, err := f.compileExpression(node.Right, scope)
+		if err != nil {
+			return none, err
+		}
+		l, lok := f.backend.Constant(left)
+		r, rok := f.backend.Constant(right)
+		if lok && rok {
+			value, err := foldNumbers(node.Operator, l, r)
+			if err != nil {
+				return none, err
+			}
+			return f.backend.Number(value), nil
+		}
+		return f.backend.Infix(node.Operator, left, right)
+
+	case *ast.Identifier:
+		return f.compileIdentifier(node.Value, scope)
+
+	case *ast.IndexExpression:
+		return f.compileIndex(node, scope)
+
+	case *ast.CallExpression:
+		return f.compileCall(node, scope)
+	}
+
+	return none, fmt.Errorf("cannot compile expression %s", node.String())
+}
+
+func foldNumbers(operator string, left, right float32) (float32, error) {
+	switch operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		return left / right, nil
+	}
+	return 0, fmt.Errorf("cannot compile NUMBER %s NUMBER", operator)
+}
+
+// compileIdentifier resolves a name like evalIdentifier does: bindings made
+// while compiling, then values already in env, then bands.
+func (f *Frontend[V]) compileIdentifier(name string, scope *frontScope[V]) (V, error) {
+	var none V
+
+	if b, ok := scope.get(name); ok {
+		if b.function != nil {
+			return none, fmt.Errorf("cannot compile function %s used as a value", name)
+		}
+		return b.load(), nil
+	}
+
+	if val, ok := f.env.Get(name); ok {
+		if number, ok := val.(*object.Number); ok {
+			return f.backend.Number(number.Value), nil
+		}
+		return none, fmt.Errorf("cannot compile %s value bound to %s", val.Type(), name)
+	}
+
+	return f.backend.Band(resolveBand(name, f.env)), nil
+}
+
+// compileIndex selects a band of a multi-band file like evalIndexExpression
+// does, the index in brackets being a string or a number known while
+// compiling.
+func (f *Frontend[V]) compileIndex(node *ast.IndexExpression, scope *frontScope[V]) (V, error) {
+	var none V
+
+	if ident, ok := node.Left.(*ast.Identifier); ok {
+		if _, ok := scope.get(ident.Value); ok {
+			return none, fmt.Errorf("cannot select a band of %s", ident.Value)
+		}
+	}
+
+	var index object.Object
+	if node.Token.Type != token.DOT {
+		if str, ok := node.Index.(*ast.StringLiteral); ok {
+			index = &object.String{Value: str.Value}
+		} else {
+			v, err := f.compileExpression(node.Index, scope)
+			if err != nil {
+				return none, err
+			}
+			number, ok := f.backend.Constant(v)
+			if !ok {
+				return none, fmt.Errorf("cannot compile band index %s", node.Index.String())
+			}
+			index = &object.Number{Value: number}
+		}
+	}
+
+	band, err := SelectBand(node, index, f.env)
+	if err != nil {
+		return none, err
+	}
+	return f.backend.Band(band), nil
+}
+
+func (f *Frontend[V]) compileCall(node *ast.CallExpression, scope *frontScope[V]) (V, error) {
+	var none V
+
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return none, fmt.Errorf("cannot compile call %s", node.String())
+	}
+	name := ident.Value
+
+	if b, ok := scope.get(name); ok {
+		if b.function == nil {
+			return none, fmt.Errorf("not a function: %s", name)
+		}
+		return f.inline(name, b.function, node.Arguments, scope)
+	}
+	if val, ok := f.env.Get(name); ok {
+		fn, ok := val.(*object.Function)
+		if !ok {
+			return none, fmt.Errorf("not a function: %s", val.Type())
+		}
+		return f.inline(name, &frontFunction[V]{parameters: fn.Parameters, body: fn.Body, env: fn.Env}, node.Arguments, scope)
+	}
+
+	if name == "qa" {
+		return f.compileQA(node.Arguments, scope)
+	}
+	if _, ok := builtins[name]; ok {
+		return none, fmt.Errorf("cannot compile builtin %s", name)
+	}
+
+	if index, ok := indices.Get(name); ok {
+		if len(node.Arguments) != 0 {
+			return none, fmt.Errorf("wrong number of arguments to `%s`. got=%d, want=0", name, len(node.Arguments))
+		}
+		program, err := index.Program(f.env.Options().Sensor)
+		if err != nil {
+			return none, err
+		}
+		// The formula sees the bands and nothing the program binds, as in
+		// Eval.
+		saved := f.env
+		f.env = object.NewEnvironmentWithOptions(f.env.Options())
+		defer func() { f.env = saved }()
+
+		v, ok, err := f.compileStatements(program.Statements, newFrontScope[V](nil))
+		if err == nil && !ok {
+			err = fmt.Errorf("index %s has no result", name)
+		}
+		return v, err
+	}
+
+	return none, fmt.Errorf("cannot compile call %s", node.String())
+}
+
+// inline compiles the body of fn with its parameters bound to the compiled
+// arguments.
+func (f *Frontend[V]) inline(name string, fn *frontFunction[V], arguments []ast.Expression, scope *frontScope[V]) (V, error) {
+	var none V
+
+	if len(arguments) != len(fn.parameters) {
+		return none, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(arguments), len(fn.parameters))
+	}
+	if f.inlining[fn.body] {
+		return none, fmt.Errorf("cannot compile recursive function %s", name)
+	}
+
+	inner := newFrontScope(fn.scope)
+	for i, param := range fn.parameters {
+		arg, err := f.compileExpression(arguments[i], scope)
+		if err != nil {
+			return none, err
+		}
+		inner.names[param.Value] = f.bind(arg)
+	}
+
+	f.inlining[fn.body] = true
+	defer delete(f.inlining, fn.body)
+
+	// Functions defined before compiling close over an environment rather
+	// than a compile scope, so names they don't bind resolve through it.
+	if fn.env != nil {
+		saved := f.env
+		f.env = fn.env
+		defer func() { f.env = saved }()
+	}
+
+	v, ok, err := f.compileStatements(fn.body.Statements, inner)
+	if err == nil && !ok {
+		err = fmt.Errorf("function %s has no result", name)
+	}
+	return v, err
+}
+
+func (f *Frontend[V]) compileQA(arguments []ast.Expression, scope *frontScope[V]) (V, error) {
+	var none V
+
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return none, fmt.Errorf("wrong number of arguments to `qa`. got=%d, want=2 or 3", len(arguments))
+	}
+
+	band, err := f.compileExpression(arguments[0], scope)
+	if err != nil {
+		return none, err
+	}
+	if f.isConstant(band) {
+		return none, fmt.Errorf("first argument to `qa` must be RASTER, got %s", object.NUMBER_OBJ)
+	}
+
+	strs := []string{}
+	for _, arg := range arguments[1:] {
+		lit, ok := arg.(*ast.StringLiteral)
+		if !ok {
+			return none, fmt.Errorf("cannot compile `qa` argument %s", arg.String())
+		}
+		strs = append(strs, lit.Value)
+	}
+
+	profileName := qa.DefaultProfile
+	if s := f.env.Options().Sensor; s != nil && s.QA != "" {
+		profileName = s.QA
+	}
+	if len(strs) == 2 {
+		profileName = strs[1]
+	}
+	profile, err := qa.GetProfile(profileName)
+	if err != nil {
+		return none, err
+	}
+	field, err := profile.Field(strs[0])
+	if err != nil {
+		return none, err
+	}
+
+	return f.backend.QA(band, field)
+}
@@ -0,0 +1,349 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/object"
+	"go_raster_eval/qa"
+	"go_raster_eval/raster"
+)
+
+// Kernel is a program compiled into a single per-pixel function. Each band
+// is read once per run and no intermediate rasters are allocated, unlike
+// Eval which builds a full canvas for every node of the expression.
+type Kernel struct {
+	bands []string
+	root  fusedNode
+}
+
+// Compile fuses program into a Kernel. Definitions and let bindings are
+// inlined; imports are evaluated into env first. Programs using anything
+// else, such as string results or rasters bound in env, can't be fused and
+// return an error so that callers can fall back to Eval.
+func Compile(program *ast.Program, env *object.Environment) (*Kernel, error) {
+	k := &Kernel{}
+	root, err := NewFrontend[fusedNode](&fuseBackend{kernel: k, bandIndex: map[string]int{}}, env).Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	k.root = root
+
+	return k, nil
+}
+
+// Run reads the kernel's bands through the evaluation options and applies
+// the kernel to every pixel.
+func (k *Kernel) Run(env *object.Environment) (*raster.FlexRaster, error) {
+	options := env.Options()
+	defer BeginRun(options)()
+
+	bands := make([]*raster.FlexRaster, len(k.bands))
+	for i, band := range k.bands {
+		r, err := ReadBand(band, options)
+		if err != nil {
+			return nil, fmt.Errorf("Raster reading operation failed: %v", err)
+		}
+		if i > 0 && (r.Width != bands[0].Width || r.Height != bands[0].Height) {
+			return nil, fmt.Errorf("non compatible rasters: Different width/height dimensions found. %d*%d %d*%d", bands[0].Width, bands[0].Height, r.Width, r.Height)
+		}
+		bands[i] = r
+	}
+
+	b, err := k.root.bind(bands)
+	if err != nil {
+		return nil, err
+	}
+	if b.pixel == nil {
+		return nil, fmt.Errorf("expression result is NUMBER, not a raster")
+	}
+
+	width, height := bands[0].Width, bands[0].Height
+	out := &raster.FlexRaster{RasterType: b.rasterType, Width: width, Height: height, Data: raster.NewData(b.rasterType, width*height), NoData: b.noData}
+	parallelRows(width, height, options.Workers, func(start, end int) {
+		buf := make([]float64, chunkSize)
+		for s := start; s < end; s += chunkSize {
+			e := min(s+chunkSize, end)
+			for i := s; i < e; i++ {
+				buf[i-s] = b.pixel(i)
+			}
+			out.SetFloat64s(s, buf[:e-s])
+		}
+	})
+
+	return out, nil
+}
+
+// fusedNode is a node of the kernel tree. Binding it to the bands read for
+// a run yields either a constant or a per-pixel function together with the
+// type and nodata value Eval would have given the same node.
+type fusedNode interface {
+	bind(bands []*raster.FlexRaster) (boundNode, error)
+}
+
+type boundNode struct {
+	pixel      func(i int) float64 // nil for constants
+	value      float32
+	rasterType raster.RasterType
+	noData     float64
+}
+
+type fusedBand struct {
+	index int
+}
+
+func (n *fusedBand) bind(bands []*raster.FlexRaster) (boundNode, error) {
+	band := bands[n.index]
+	return boundNode{pixel: pixelFunc(band), rasterType: band.RasterType, noData: band.NoData}, nil
+}
+
+// pixelFunc returns a function reading the pixels of r as float64.
+func pixelFunc(r *raster.FlexRaster) func(i int) float64 {
+	switch data := r.Data.(type) {
+	case []uint8:
+		return func(i int) float64 { return float64(data[i]) }
+	case []int16:
+		return func(i int) float64 { return float64(data[i]) }
+	case []uint16:
+		return func(i int) float64 { return float64(data[i]) }
+	case []int32:
+		return func(i int) float64 { return float64(data[i]) }
+	case []uint32:
+		return func(i int) float64 { return float64(data[i]) }
+	case []float32:
+		return func(i int) float64 { return float64(data[i]) }
+	case []float64:
+		return func(i int) float64 { return data[i] }
+	}
+	panic(fmt.Sprintf("unsupported raster data %T", r.Data))
+}
+
+type fusedNumber struct {
+	value float32
+}
+
+func (n *fusedNumber) bind(bands []*raster.FlexRaster) (boundNode, error) {
+	return boundNode{value: n.value}, nil
+}
+
+type fusedQA struct {
+	band  fusedNode
+	field qa.Field
+}
+
+func (n *fusedQA) bind(bands []*raster.FlexRaster) (boundNode, error) {
+	b, err := n.band.bind(bands)
+	if err != nil {
+		return boundNode{}, err
+	}
+	if b.rasterType != raster.UINT16 && b.rasterType != raster.UINT8 {
+		return boundNode{}, fmt.Errorf("QA decoding not implemented for type %s", b.rasterType)
+	}
+
+	rasterType := raster.UINT8
+	if n.field.IsFlag() {
+		rasterType = raster.BOOL
+	}
+
+	pixel, field := b.pixel, n.field
+	return boundNode{
+		pixel:      func(i int) float64 { return float64(field.Decode(uint16(pixel(i)))) },
+		rasterType: rasterType,
+		noData:     raster.NoNoData,
+	}, nil
+}
+
+type fusedInfix struct {
+	operator    string
+	left, right fusedNode
+}
+
+func (n *fusedInfix) bind(bands []*raster.FlexRaster) (boundNode, error) {
+	l, err := n.left.bind(bands)
+	if err != nil {
+		return boundNode{}, err
+	}
+	r, err := n.right.bind(bands)
+	if err != nil {
+		return boundNode{}, err
+	}
+
+	switch {
+	case l.pixel != nil && r.pixel == nil:
+		return bindRASTERNUMBER(n.operator, l, r.value)
+	case l.pixel == nil && r.pixel != nil:
+		return bindNUMBERRASTER(n.operator, l.value, r)
+	case l.pixel != nil && r.pixel != nil:
+		return bindRASTER(n.operator, l, r)
+	default:
+		return boundNode{}, fmt.Errorf("unexpected NUMBER %s NUMBER in kernel", n.operator)
+	}
+}
+
+func bindRASTERNUMBER(operator string, l boundNode, value float32) (boundNode, error) {
+	pixel, v := l.pixel, float64(value)
+	out := boundNode{rasterType: raster.Promote(l.rasterType), noData: l.noData}
+
+	switch operator {
+	case "+":
+		out.pixel = rounded(out.rasterType, func(i int) float64 { return pixel(i) + v })
+	case "-":
+		out.pixel = rounded(out.rasterType, func(i int) float64 { return pixel(i) - v })
+	case "*":
+		out.pixel = rounded(out.rasterType, func(i int) float64 { return pixel(i) * v })
+	case "/":
+		out.pixel = rounded(out.rasterType, func(i int) float64 { return pixel(i) / v })
+	case "==":
+		switch l.rasterType {
+		case raster.UINT8:
+			out.pixel = func(i int) float64 { return boolPixel(pixel(i) == v) }
+		case raster.UINT16:
+			mask := uint16(value)
+			out.pixel = func(i int) float64 { return boolPixel(uint16(pixel(i))&mask > 0) }
+		case raster.INT16:
+			mask := int16(value)
+			out.pixel = func(i int) float64 { return boolPixel(int16(pixel(i))&mask > 0) }
+		case raster.UINT32:
+			mask := uint32(value)
+			out.pixel = func(i int) float64 { return boolPixel(uint32(pixel(i))&mask > 0) }
+		case raster.INT32:
+			mask := int32(value)
+			out.pixel = func(i int) float64 { return boolPixel(int32(pixel(i))&mask > 0) }
+		default:
+			return boundNode{}, fmt.Errorf("Masking not implemented for type %s", l.rasterType)
+		}
+		out.rasterType = raster.BOOL
+	case "<", ">", "<=", ">=":
+		out.pixel = func(i int) float64 { return boolPixel(compare(operator, pixel(i), v)) }
+		out.rasterType = raster.BOOL
+	default:
+		return boundNode{}, fmt.Errorf("unknown operator: %s %s %s", object.RASTER_OBJ, operator, object.NUMBER_OBJ)
+	}
+
+	return out, nil
+}
+
+func bindNUMBERRASTER(operator string, value float32, r boundNode) (boundNode, error) {
+	pixel, v := r.pixel, float64(value)
+	rasterType := raster.Promote(r.rasterType)
+
+	switch operator {
+	case "-":
+		return boundNode{pixel: rounded(rasterType, func(i int) float64 { return v - pixel(i) }), rasterType: rasterType, noData: r.noData}, nil
+	case "/":
+		return boundNode{pixel: rounded(rasterType, func(i int) float64 { return v / pixel(i) }), rasterType: rasterType, noData: r.noData}, nil
+	case "<":
+		return bindRASTERNUMBER(">", r, value)
+	case ">":
+		return bindRASTERNUMBER("<", r, value)
+	case "<=":
+		return bindRASTERNUMBER(">=", r, value)
+	case ">=":
+		return bindRASTERNUMBER("<=", r, value)
+	default:
+		return bindRASTERNUMBER(operator, r, value)
+	}
+}
+
+func bindRASTER(operator string, l, r boundNode) (boundNode, error) {
+	left, right := l.pixel, r.pixel
+	out := boundNode{rasterType: l.rasterType, noData: l.noData}
+
+	if operator == "#" {
+		if r.rasterType != raster.BOOL {
+			return boundNode{}, fmt.Errorf("Raster on the right must be a Boolean raster type.")
+		}
+		var noData float64
+		out.rasterType, noData = raster.Masked(l.rasterType, l.noData)
+		out.noData = noData
+		out.pixel = func(i int) float64 {
+			if right(i) == 1.0 {
+				return noData
+			}
+			return left(i)
+		}
+		return out, nil
+	}
+
+	if l.noData != r.noData {
+		return boundNode{}, fmt.Errorf("non compatible rasters: Different NoData values found.")
+	}
+	out.rasterType = raster.Promote(l.rasterType, r.rasterType)
+
+	switch operator {
+	case "+":
+		out.pixel = func(i int) float64 { return left(i) + right(i) }
+	case "-":
+		out.pixel = func(i int) float64 { return left(i) - right(i) }
+	case "*":
+		out.pixel = func(i int) float64 { return left(i) * right(i) }
+	case "/":
+		out.pixel = func(i int) float64 { return left(i) / right(i) }
+	default:
+		return boundNode{}, fmt.Errorf("unknown operator: %s %s %s", object.RASTER_OBJ, operator, object.RASTER_OBJ)
+	}
+	out.pixel = rounded(out.rasterType, out.pixel)
+
+	return out, nil
+}
+
+// rounded rounds the results of an arithmetic pixel function to float32
+// when they are stored as FLOAT32, as Eval does between operations.
+func rounded(rasterType raster.RasterType, pixel func(i int) float64) func(i int) float64 {
+	if rasterType != raster.FLOAT32 {
+		return pixel
+	}
+	return func(i int) float64 { return float64(float32(pixel(i))) }
+}
+
+func boolPixel(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
+
+// fuseBackend lowers programs into the nodes of a kernel.
+type fuseBackend struct {
+	kernel    *Kernel
+	bandIndex map[string]int
+}
+
+func (b *fuseBackend) Number(value float32) fusedNode {
+	return &fusedNumber{value: value}
+}
+
+func (b *fuseBackend) Constant(node fusedNode) (float32, bool) {
+	number, ok := node.(*fusedNumber)
+	if !ok {
+		return 0, false
+	}
+	return number.value, true
+}
+
+// Band reads a band into the kernel, once however often it is used.
+func (b *fuseBackend) Band(band string) fusedNode {
+	index, ok := b.bandIndex[band]
+	if !ok {
+		index = len(b.kernel.bands)
+		b.bandIndex[band] = index
+		b.kernel.bands = append(b.kernel.bands, band)
+	}
+	return &fusedBand{index: index}
+}
+
+func (b *fuseBackend) Infix(operator string, left, right fusedNode) (fusedNode, error) {
+	return &fusedInfix{operator: operator, left: left, right: right}, nil
+}
+
+func (b *fuseBackend) QA(band fusedNode, field qa.Field) (fusedNode, error) {
+	return &fusedQA{band: band, field: field}, nil
+}
+
+// Bind shares the node: kernels compute every pixel from scratch, so there
+// is nothing to keep.
+func (b *fuseBackend) Bind(node fusedNode) func() fusedNode {
+	return func() fusedNode { return node }
+}
+
+func (b *fuseBackend) Discard(fusedNode) {}
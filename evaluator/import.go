@@ -0,0 +1,85 @@
+package evaluator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/lexer"
+	"go_raster_eval/object"
+	"go_raster_eval/parser"
+)
+
+// evalImportStatement parses the imported script and evaluates it into env,
+// so its definitions become visible to the importing script. Scripts are
+// parsed once per run and kept in the options, as a tiled evaluation
+// imports them again for every tile.
+func evalImportStatement(node *ast.ImportStatement, env *object.Environment) *object.Error {
+	options := env.Options()
+
+	path, err := resolveImport(node.Path, options)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range options.ImportStack {
+		if p == path {
+			cycle := append(append([]string{}, options.ImportStack[i:]...), path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	program, ok := options.Imports[path]
+	if !ok {
+		src, ioErr := ioutil.ReadFile(path)
+		if ioErr != nil {
+			return newError("import %q: %v", node.Path, ioErr)
+		}
+
+		p := parser.New(lexer.New(string(src)))
+		program = p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			return newError("import %q: %s", node.Path, strings.Join(p.Errors(), "; "))
+		}
+
+		options.Imports[path] = program
+	}
+
+	options.ImportStack = append(options.ImportStack, path)
+	defer func() { options.ImportStack = options.ImportStack[:len(options.ImportStack)-1] }()
+
+	if result, ok := evalProgram(program, env).(*object.Error); ok {
+		return newError("import %q: %s", node.Path, result.Message)
+	}
+	return nil
+}
+
+// resolveImport finds an imported script. Absolute paths are used as they
+// are; relative ones are looked up next to the importing script, or the
+// working directory at the top level, and then along the search path.
+func resolveImport(name string, options *object.Options) (string, *object.Error) {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name), nil
+	}
+
+	dirs := []string{"."}
+	if n := len(options.ImportStack); n > 0 {
+		dirs[0] = filepath.Dir(options.ImportStack[n-1])
+	}
+	dirs = append(dirs, options.SearchPath...)
+
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			abs, err := filepath.Abs(candidate)
+			if err != nil {
+				return "", newError("import %q: %v", name, err)
+			}
+			return abs, nil
+		}
+	}
+
+	return "", newError("import %q: not found in %s", name, strings.Join(dirs, string(filepath.ListSeparator)))
+}
@@ -0,0 +1,88 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+)
+
+func TestImportParsedOncePerRun(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.expr")
+	writeLib := func(factor string) {
+		if err := os.WriteFile(lib, []byte("def scale(x) = x * "+factor+";"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	band := uint16Band(4, 4, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16)
+	input := `import "lib.expr"; scale(B1);`
+
+	// Each run parses the script again, so a later run sees it change.
+	options := &object.Options{Source: memSource{"B1": band}, SearchPath: []string{dir}}
+	for _, test := range []struct {
+		factor string
+		want   float64
+	}{{"2", 2}, {"3", 3}} {
+		writeLib(test.factor)
+		obj := evalString(t, input, options)
+		r, ok := obj.(*object.Raster)
+		if !ok {
+			t.Fatal(obj.Inspect())
+		}
+		if got := r.Value.Float64s(0, 1, nil)[0]; got != test.want {
+			t.Errorf("first pixel = %v, want %v", got, test.want)
+		}
+		if options.Imports != nil {
+			t.Fatalf("run left its imports in the options: %v", options.Imports)
+		}
+	}
+
+	// Within a tiled run the script is parsed by the first tile only: the
+	// change made once it has read its band doesn't reach later tiles.
+	writeLib("3")
+	source := &hookSource{Source: memSource{"B1": band}, hook: func() { writeLib("5") }}
+	options = &object.Options{Source: source, SearchPath: []string{dir}}
+	var sum float64
+	open := func(grid *raster.Info, result *raster.FlexRaster) (raster.Writer, error) {
+		return writerFunc(func(w raster.Window, r *raster.FlexRaster) error {
+			for _, v := range r.Float64s(0, r.Len(), nil) {
+				sum += v
+			}
+			return nil
+		}), nil
+	}
+	if err := EvalTiled(parse(t, input), object.NewEnvironmentWithOptions(options), 2, open); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3*136 {
+		t.Errorf("sum of tiles = %v, want %v", sum, 3*136)
+	}
+	if options.Imports != nil {
+		t.Errorf("tiled run left its imports in the options: %v", options.Imports)
+	}
+}
+
+// hookSource calls hook once the first band has been read.
+type hookSource struct {
+	raster.Source
+	hook func()
+}
+
+func (s *hookSource) Read(band string, overview int, window *raster.Window) (*raster.FlexRaster, error) {
+	r, err := s.Source.Read(band, overview, window)
+	if s.hook != nil {
+		s.hook()
+		s.hook = nil
+	}
+	return r, err
+}
+
+// writerFunc adapts a function to raster.Writer.
+type writerFunc func(raster.Window, *raster.FlexRaster) error
+
+func (f writerFunc) Write(w raster.Window, r *raster.FlexRaster) error { return f(w, r) }
+func (f writerFunc) Close() error                                      { return nil }
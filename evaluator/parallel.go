@@ -0,0 +1,76 @@
+package evaluator
+
+import (
+	"runtime"
+	"sync"
+
+	"go_raster_eval/raster"
+)
+
+// parallelRows splits a width*height raster into contiguous blocks of rows
+// and calls fn with the [start, end) pixel range of each block on its own
+// goroutine. Blocks never overlap, so kernels writing only to their own
+// range produce the same result whatever the number of workers. A workers
+// value of zero or less uses one worker per CPU.
+func parallelRows(width, height, workers int, fn func(start, end int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > height {
+		workers = height
+	}
+	if workers <= 1 {
+		fn(0, width*height)
+		return
+	}
+
+	rows := (height + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for row := 0; row < height; row += rows {
+		end := row + rows
+		if end > height {
+			end = height
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(row*width, end*width)
+	}
+	wg.Wait()
+}
+
+// chunkSize is the number of pixels kernels convert to float64 at once.
+const chunkSize = 4096
+
+// mapPixels applies fn to the pixels of src, converted to float64 a chunk
+// at a time, storing the results in a new raster of rasterType.
+func mapPixels(src raster.FlexRaster, rasterType raster.RasterType, workers int, fn func(dst, src []float64)) raster.FlexRaster {
+	out := raster.FlexRaster{RasterType: rasterType, Width: src.Width, Height: src.Height, Data: raster.NewData(rasterType, src.Len()), NoData: src.NoData}
+	parallelRows(src.Width, src.Height, workers, func(start, end int) {
+		buf, dst := make([]float64, chunkSize), make([]float64, chunkSize)
+		for s := start; s < end; s += chunkSize {
+			e := min(s+chunkSize, end)
+			fn(dst[:e-s], src.Float64s(s, e, buf))
+			out.SetFloat64s(s, dst[:e-s])
+		}
+	})
+	return out
+}
+
+// zipPixels is mapPixels over the pixels of two rasters of the same size.
+// The result takes the nodata value of left.
+func zipPixels(left, right raster.FlexRaster, rasterType raster.RasterType, workers int, fn func(dst, left, right []float64)) raster.FlexRaster {
+	out := raster.FlexRaster{RasterType: rasterType, Width: left.Width, Height: left.Height, Data: raster.NewData(rasterType, left.Len()), NoData: left.NoData}
+	parallelRows(left.Width, left.Height, workers, func(start, end int) {
+		lBuf, rBuf, dst := make([]float64, chunkSize), make([]float64, chunkSize), make([]float64, chunkSize)
+		for s := start; s < end; s += chunkSize {
+			e := min(s+chunkSize, end)
+			fn(dst[:e-s], left.Float64s(s, e, lBuf), right.Float64s(s, e, rBuf))
+			out.SetFloat64s(s, dst[:e-s])
+		}
+	})
+	return out
+}
@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"go_raster_eval/object"
+)
+
+// scene returns a source holding bands B1 to B3 of width*height pixels.
+func scene(width, height int) memSource {
+	source := memSource{}
+	for b := 1; b <= 3; b++ {
+		pixels := make([]uint16, width*height)
+		for i := range pixels {
+			pixels[i] = uint16((i*7919 + b*104729) % 10007)
+		}
+		source[fmt.Sprintf("B%d", b)] = uint16Band(width, height, pixels...)
+	}
+	return source
+}
+
+var parallelPrograms = []string{
+	"(B2 - B1) / (B2 + B1);",
+	"B1 * 2 + B3 - 1;",
+	"B1 # (B2 > 5000);",
+	"(B3 > 4000) # (B2 < 6000);",
+}
+
+func TestParallelRows(t *testing.T) {
+	for _, tt := range []struct{ width, height, workers int }{
+		{1, 1, 4}, {7, 5, 0}, {7, 5, 1}, {7, 5, 2}, {7, 5, 5}, {7, 5, 9}, {100, 97, 8},
+	} {
+		seen := make([]int, tt.width*tt.height)
+		parallelRows(tt.width, tt.height, tt.workers, func(start, end int) {
+			if start%tt.width != 0 || end%tt.width != 0 {
+				t.Errorf("%+v: block [%d, %d) does not cover whole rows", tt, start, end)
+			}
+			for i := start; i < end; i++ {
+				seen[i]++
+			}
+		})
+		for i, n := range seen {
+			if n != 1 {
+				t.Fatalf("%+v: pixel %d visited %d times", tt, i, n)
+			}
+		}
+	}
+}
+
+func TestWorkersIdentical(t *testing.T) {
+	source := scene(97, 61)
+	for _, input := range parallelPrograms {
+		program := parse(t, input)
+		var want []float64
+		for _, workers := range []int{1, 2, 3, 8, 61, 200} {
+			env := object.NewEnvironmentWithOptions(&object.Options{Source: source, Workers: workers})
+			got := pixels(t, Eval(program, env))
+
+			kernel, err := Compile(program, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := kernel.Run(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fused := r.Float64s(0, r.Len(), nil)
+
+			if want == nil {
+				want = got
+			}
+			for i := range want {
+				if got[i] != want[i] || fused[i] != want[i] {
+					t.Fatalf("%s with %d workers: pixel %d = %v (eval), %v (kernel), want %v", input, workers, i, got[i], fused[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkEval(b *testing.B) {
+	source := scene(1024, 1024)
+	for _, workers := range []int{1, max(runtime.NumCPU(), 4)} {
+		for _, input := range parallelPrograms {
+			b.Run(fmt.Sprintf("workers=%d/%s", workers, input), func(b *testing.B) {
+				p := parse(b, input)
+				options := &object.Options{Source: source, Workers: workers}
+				for i := 0; i < b.N; i++ {
+					if obj := Eval(p, object.NewEnvironmentWithOptions(options)); obj.Type() == object.ERROR_OBJ {
+						b.Fatal(obj.Inspect())
+					}
+				}
+			})
+		}
+	}
+}
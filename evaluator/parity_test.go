@@ -0,0 +1,319 @@
+package evaluator_test
+
+import (
+	"strings"
+	"testing"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/compiler"
+	"go_raster_eval/evaluator"
+	"go_raster_eval/lexer"
+	"go_raster_eval/object"
+	"go_raster_eval/optimizer"
+	"go_raster_eval/parser"
+	"go_raster_eval/raster"
+	"go_raster_eval/sensor"
+	"go_raster_eval/vm"
+)
+
+// strategies run a program with each evaluation strategy.
+var strategies = map[string]func(program *ast.Program, options *object.Options) (*raster.FlexRaster, error){
+	"eval": func(program *ast.Program, options *object.Options) (*raster.FlexRaster, error) {
+		switch obj := evaluator.Eval(program, object.NewEnvironmentWithOptions(options)).(type) {
+		case *object.Raster:
+			return &obj.Value, nil
+		case *object.Error:
+			return nil, errorString(obj.Message)
+		default:
+			return nil, errorString("result is " + string(obj.Type()))
+		}
+	},
+	"kernel": func(program *ast.Program, options *object.Options) (*raster.FlexRaster, error) {
+		env := object.NewEnvironmentWithOptions(options)
+		kernel, err := evaluator.Compile(program, env)
+		if err != nil {
+			return nil, err
+		}
+		return kernel.Run(env)
+	},
+	"vm": func(program *ast.Program, options *object.Options) (*raster.FlexRaster, error) {
+		env := object.NewEnvironmentWithOptions(options)
+		c := compiler.New(env)
+		if err := c.Compile(program); err != nil {
+			return nil, err
+		}
+		return vm.New(c.Bytecode()).Run(env)
+	},
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%s: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestParity(t *testing.T) {
+	// Landsat 8 Collection 1 bands with a BQA band holding clear pixels,
+	// cloud with confidence 3, and fill.
+	source := evaluator.MemSource{
+		"B3":  evaluator.Uint16Band(3, 2, 900, 1200, 0, 4000, 1500, 1100),
+		"B4":  evaluator.Uint16Band(3, 2, 1000, 2000, 3000, 4000, 5000, 6000),
+		"B5":  evaluator.Uint16Band(3, 2, 3000, 2500, 3500, 4500, 9000, 7000),
+		"BQA": evaluator.Uint16Band(3, 2, 2720, 2720, 1<<4|3<<5, 1, 2720, 1<<4|3<<5),
+	}
+	l8, err := sensor.GetProfile("landsat8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programs := []string{
+		"(B5 - B4) / (B5 + B4);",
+		"B5 * 2 + 1;",
+		"1 - B4 / 10000;",
+		"2 * (B5 - 3 * B4);",
+		"B4 / 2;",
+		"B5 > 3000;",
+		"3000 <= B5;",
+		"B5 == 1024;",
+		"B5 # (B4 > 3500);",
+		`B5 # qa(BQA, "cloud");`,
+		`(B5 - B4) # (qa(BQA, "cloud_confidence") == 3);`,
+		`qa(BQA, "cloud_confidence") >= 2;`,
+		"let d = B5 - B4; let s = B5 + B4; d / s;",
+		"let k = 2 * 3; B4 * k;",
+		"def nd(a, b) = (a - b) / (a + b); nd(B5, B3);",
+		"def scaled(a) = a * 0.0001; scaled(B5) - scaled(B4);",
+		"let f = fn(a) { a + 1 }; f(B4) * 2;",
+		"B4 + 1; B5 - 1;",
+		"ndvi();",
+		"(nir - red) / (nir + red);",
+		"def nd(a, b) = (a - b) / (a + b); nd(nir, green) - ndwi();",
+	}
+
+	for _, program := range programs {
+		var want *raster.FlexRaster
+		for _, name := range []string{"eval", "kernel", "vm"} {
+			got, err := strategies[name](parse(t, program), &object.Options{Source: source, Sensor: l8})
+			if err != nil {
+				t.Errorf("%s with %s: %v", program, name, err)
+				continue
+			}
+			if want == nil {
+				want = got
+				continue
+			}
+			if got.RasterType != want.RasterType || got.NoData != want.NoData || got.Width != want.Width || got.Height != want.Height {
+				t.Errorf("%s with %s: %s %dx%d nodata %v, eval gave %s %dx%d nodata %v", program, name,
+					got.RasterType, got.Width, got.Height, got.NoData, want.RasterType, want.Width, want.Height, want.NoData)
+				continue
+			}
+			g, w := got.Float64s(0, got.Len(), nil), want.Float64s(0, want.Len(), nil)
+			for i := range w {
+				if g[i] != w[i] {
+					t.Errorf("%s with %s: pixel %d = %v, eval gave %v", program, name, i, g[i], w[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestOptimizeParity checks that optimised programs give the same types
+// and pixels, or the same errors, as the programs they were optimised
+// from. == masks the bits of integer rasters but isn't defined for float
+// ones, so types matter beyond the output file.
+func TestOptimizeParity(t *testing.T) {
+	source := evaluator.MemSource{
+		"B4":  evaluator.Uint16Band(3, 1, 1000, 2000, 1024),
+		"B5":  evaluator.Uint16Band(3, 1, 3000, 1024, 3500),
+		"BQA": evaluator.Uint16Band(3, 1, 2720, 1<<4|3<<5, 1),
+	}
+	l8, err := sensor.GetProfile("landsat8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programs := []string{
+		"B5 + 0;",
+		"B5 * 1 == 1024;",
+		"0 + B4 * 1;",
+		"(B5 - B4) * 1;",
+		"(B5 # (B4 > 1500)) - 0;",
+		`qa(BQA, "cloud_confidence") + 0 == 1;`,
+		"let x = B4 / 1; x == 1024;",
+		"ndvi() * 1;",
+		"2 * 3 + B4;",
+	}
+
+	for _, program := range programs {
+		for name, run := range strategies {
+			want, wantErr := run(parse(t, program), &object.Options{Source: source, Sensor: l8})
+			optimized := optimizer.Optimize(parse(t, program))
+			got, err := run(optimized, &object.Options{Source: source, Sensor: l8})
+			if err != nil || wantErr != nil {
+				if (err == nil) != (wantErr == nil) {
+					t.Errorf("%s with %s: error %v, unoptimised %v", optimized, name, err, wantErr)
+				}
+				continue
+			}
+			if got.RasterType != want.RasterType || got.NoData != want.NoData {
+				t.Errorf("%s with %s: %s nodata %v, unoptimised %s nodata %v", optimized, name, got.RasterType, got.NoData, want.RasterType, want.NoData)
+				continue
+			}
+			g, w := got.Float64s(0, got.Len(), nil), want.Float64s(0, want.Len(), nil)
+			for i := range w {
+				if g[i] != w[i] {
+					t.Errorf("%s with %s: pixels %v, unoptimised %v", optimized, name, g, w)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestFilterNoData filters an integer band without a nodata value of its
+// own, whose nodata value can't be stored in its type.
+func TestFilterNoData(t *testing.T) {
+	b4 := evaluator.Uint16Band(2, 1, 1000, 4000)
+	b4.NoData = -1e10
+	source := evaluator.MemSource{"B4": b4}
+
+	for name, run := range strategies {
+		got, err := run(parse(t, "B4 # (B4 > 3500);"), &object.Options{Source: source})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.RasterType != raster.FLOAT32 || got.NoData != float64(float32(-1e10)) {
+			t.Errorf("%s: %s nodata %v, want FLOAT32 nodata %v", name, got.RasterType, got.NoData, float32(-1e10))
+		}
+		if pixels := got.Float64s(0, got.Len(), nil); pixels[0] != 1000 || pixels[1] != got.NoData {
+			t.Errorf("%s: pixels %v, want [1000 %v]", name, pixels, got.NoData)
+		}
+	}
+}
+
+// TestQANoData decodes a quality band whose nodata value is one of the
+// decoded levels, as the Landsat fill value 1 is.
+func TestQANoData(t *testing.T) {
+	bqa := evaluator.Uint16Band(4, 1, 2720, 1, 1<<4|3<<5, 1<<4|1<<5)
+	bqa.NoData = 1
+	source := evaluator.MemSource{"BQA": bqa}
+	l8, err := sensor.GetProfile("landsat8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for program, want := range map[string][]float64{
+		`qa(BQA, "cloud");`:            {0, 0, 1, 1},
+		`qa(BQA, "cloud_confidence");`: {1, 0, 3, 1},
+	} {
+		for name, run := range strategies {
+			got, err := run(parse(t, program), &object.Options{Source: source, Sensor: l8})
+			if err != nil {
+				t.Fatalf("%s with %s: %v", program, name, err)
+			}
+			pixels := got.Float64s(0, got.Len(), nil)
+			for i, w := range want {
+				if pixels[i] != w {
+					t.Errorf("%s with %s: pixels %v, want %v", program, name, pixels, want)
+					break
+				}
+			}
+			if got.NoData != raster.NoNoData {
+				t.Errorf("%s with %s: nodata %v, want none", program, name, got.NoData)
+			}
+		}
+	}
+}
+
+// TestIndexScope calls an index from a program binding names its formula
+// uses, which must still read the bands.
+func TestIndexScope(t *testing.T) {
+	source := evaluator.MemSource{
+		"B4": evaluator.Uint16Band(2, 1, 1000, 2000),
+		"B5": evaluator.Uint16Band(2, 1, 3000, 2500),
+	}
+	l8, err := sensor.GetProfile("landsat8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, run := range strategies {
+		want, err := run(parse(t, "ndvi();"), &object.Options{Source: source, Sensor: l8})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, program := range []string{"let nir = 1; ndvi();", "let B4 = B5; ndvi();", "let red = 2; ndvi() * 1;"} {
+			got, err := run(parse(t, program), &object.Options{Source: source, Sensor: l8})
+			if err != nil {
+				t.Errorf("%s with %s: %v", program, name, err)
+				continue
+			}
+			g, w := got.Float64s(0, got.Len(), nil), want.Float64s(0, want.Len(), nil)
+			for i := range w {
+				if g[i] != w[i] {
+					t.Errorf("%s with %s: pixels %v, want %v", program, name, g, w)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestCannotCompile(t *testing.T) {
+	source := evaluator.MemSource{"B1": evaluator.Uint16Band(1, 1, 1)}
+	for program, want := range map[string]string{
+		"def f(a) = f(a) + 1; f(B1);":                  "recursive function f",
+		"def f(a) = g(a); def g(a) = f(a) * 2; g(B1);": "recursive function",
+		"let x = B1;":          "no result",
+		"1 + 2;":               "does not read any raster",
+		"def f(a) = a + 1; f;": "used as a value",
+	} {
+		for _, name := range []string{"kernel", "vm"} {
+			_, err := strategies[name](parse(t, program), &object.Options{Source: source})
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%s with %s: error %v, want %q", program, name, err, want)
+			}
+		}
+	}
+}
+
+// countingSource counts the reads of a source.
+type countingSource struct {
+	raster.Source
+	reads int
+}
+
+func (s *countingSource) Read(band string, overview int, window *raster.Window) (*raster.FlexRaster, error) {
+	s.reads++
+	return s.Source.Read(band, overview, window)
+}
+
+func TestBandCaches(t *testing.T) {
+	for _, name := range []string{"eval", "kernel", "vm"} {
+		source := &countingSource{Source: evaluator.MemSource{
+			"B4": evaluator.Uint16Band(2, 1, 1, 2),
+			"B5": evaluator.Uint16Band(2, 1, 3, 4),
+		}}
+		options := &object.Options{Source: source, Cache: raster.NewCache(1 << 20)}
+		for i := 0; i < 2; i++ {
+			if _, err := strategies[name](parse(t, "(B5 - B4) / (B5 + B4) + B5;"), options); err != nil {
+				t.Fatal(err)
+			}
+			if options.Bands != nil {
+				t.Errorf("%s: run left its band cache in the options", name)
+			}
+		}
+		if source.reads != 2 {
+			t.Errorf("%s: %d reads, want each band read once across both runs", name, source.reads)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+)
+
+// WriterFunc opens the destination of a tiled evaluation once the grid and
+// the type of the result are known.
+type WriterFunc func(grid *raster.Info, result *raster.FlexRaster) (raster.Writer, error)
+
+// EvalTiled evaluates program one tile of tileSize*tileSize pixels at a
+// time, handing each result to the writer returned by open. Only one tile
+// of every band is held in memory at once. The grid is taken from the
+// first band the program reads.
+func EvalTiled(program *ast.Program, env *object.Environment, tileSize int, open WriterFunc) error {
+	return EvalTiledFunc(func(env *object.Environment) (*raster.FlexRaster, error) { return evalTile(program, env) }, env, tileSize, open)
+}
+
+// RunTiled runs the kernel one tile at a time like EvalTiled.
+func (k *Kernel) RunTiled(env *object.Environment, tileSize int, open WriterFunc) error {
+	return EvalTiledFunc(k.Run, env, tileSize, open)
+}
+
+// EvalTiledFunc drives a tiled evaluation for any evaluation strategy. eval
+// is called once per tile with an environment enclosed by env whose options
+// window is set to the tile.
+func EvalTiledFunc(eval func(env *object.Environment) (*raster.FlexRaster, error), env *object.Environment, tileSize int, open WriterFunc) error {
+	evalAll := func(env *object.Environment) ([]*raster.FlexRaster, error) {
+		result, err := eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return []*raster.FlexRaster{result}, nil
+	}
+	openAll := func(grid *raster.Info, results []*raster.FlexRaster) ([]raster.Writer, error) {
+		w, err := open(grid, results[0])
+		if err != nil {
+			return nil, err
+		}
+		return []raster.Writer{w}, nil
+	}
+	return evalTiled(evalAll, env, tileSize, openAll)
+}
+
+// OutputsWriterFunc opens a writer for each output of a tiled evaluation,
+// in the order of names, once the grid and the types of the outputs are
+// known.
+type OutputsWriterFunc func(grid *raster.Info, names []string, results []*raster.FlexRaster) ([]raster.Writer, error)
+
+// EvalTiledOutputs evaluates a program with out statements one tile at a
+// time like EvalTiled, handing each output to its own writer. The outputs
+// of a tile are evaluated together, so bands they share are read once.
+func EvalTiledOutputs(program *ast.Program, env *object.Environment, tileSize int, open OutputsWriterFunc) error {
+	names := OutputNames(program)
+	if len(names) == 0 {
+		return fmt.Errorf("program has no out statements")
+	}
+	openAll := func(grid *raster.Info, results []*raster.FlexRaster) ([]raster.Writer, error) {
+		return open(grid, names, results)
+	}
+	return evalTiled(func(env *object.Environment) ([]*raster.FlexRaster, error) { return evalOutputsTile(program, env) }, env, tileSize, openAll)
+}
+
+// evalTiled evaluates results one tile at a time, writing the nth result
+// of each tile to the nth writer returned by open. Every tile is evaluated
+// with its own copy of the options of env, which are left untouched.
+func evalTiled(eval func(env *object.Environment) ([]*raster.FlexRaster, error), env *object.Environment, tileSize int, open func(*raster.Info, []*raster.FlexRaster) ([]raster.Writer, error)) error {
+	if tileSize <= 0 {
+		return fmt.Errorf("invalid tile size %d", tileSize)
+	}
+
+	// tile returns the options and environment evaluating window, with
+	// options copied from base.
+	tile := func(base object.Options, window raster.Window) (*object.Options, *object.Environment) {
+		base.Window = &window
+		return &base, object.NewEnclosedEnvironmentWithOptions(env, &base)
+	}
+
+	// Tiles share the scripts they import, parsed by the first one.
+	base := *env.Options()
+	if base.Imports == nil {
+		base.Imports = map[string]*ast.Program{}
+	}
+
+	// The extent isn't known until a band has been read, so the first tile
+	// is evaluated on its own and clipped against the grid it discovers.
+	// Later tiles start from its options, with the grid and the overview
+	// picked for it.
+	first, tileEnv := tile(base, raster.Window{Width: tileSize, Height: tileSize})
+	results, err := eval(tileEnv)
+	if err != nil {
+		return err
+	}
+	grid := first.Grid
+	if grid == nil {
+		return fmt.Errorf("expression does not read any raster")
+	}
+
+	extent := raster.Window{Width: grid.Width, Height: grid.Height}
+	writers, err := open(grid, results)
+	if err != nil {
+		return err
+	}
+	closeAll := func() error {
+		var err error
+		for _, w := range writers {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+
+	for i, window := range extent.Tiles(tileSize) {
+		if i > 0 {
+			_, tileEnv := tile(*first, window)
+			results, err = eval(tileEnv)
+			if err != nil {
+				closeAll()
+				return err
+			}
+		}
+		for j, w := range writers {
+			if err := w.Write(window, results[j]); err != nil {
+				closeAll()
+				return err
+			}
+		}
+	}
+
+	return closeAll()
+}
+
+func evalTile(program *ast.Program, env *object.Environment) (*raster.FlexRaster, error) {
+	switch result := Eval(program, env).(type) {
+	case *object.Raster:
+		return &result.Value, nil
+	case *object.Error:
+		return nil, fmt.Errorf("%s", result.Message)
+	case nil:
+		return nil, fmt.Errorf("expression has no result")
+	default:
+		return nil, fmt.Errorf("expression result is %s, not a raster", result.Type())
+	}
+}
+
+func evalOutputsTile(program *ast.Program, env *object.Environment) ([]*raster.FlexRaster, error) {
+	switch result := Eval(program, env).(type) {
+	case *object.Outputs:
+		results := make([]*raster.FlexRaster, len(result.Values))
+		for i, val := range result.Values {
+			r, ok := val.(*object.Raster)
+			if !ok {
+				return nil, fmt.Errorf("output %s is %s, not a raster", result.Names[i], val.Type())
+			}
+			results[i] = &r.Value
+		}
+		return results, nil
+	case *object.Error:
+		return nil, fmt.Errorf("%s", result.Message)
+	case nil:
+		return nil, fmt.Errorf("expression has no result")
+	default:
+		return nil, fmt.Errorf("program returned %s before its outputs", result.Type())
+	}
+}
@@ -0,0 +1,51 @@
+package evaluator
+
+import (
+	"testing"
+
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+)
+
+func TestTiledLeavesOptions(t *testing.T) {
+	source := scene(37, 23)
+	input := "(B2 - B1) / (B2 + B1);"
+	want := pixels(t, evalString(t, input, &object.Options{Source: source}))
+
+	options := &object.Options{Source: source}
+	env := object.NewEnvironmentWithOptions(options)
+	program := parse(t, input)
+	kernel, err := Compile(program, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tiled := range map[string]func(open WriterFunc) error{
+		"eval":   func(open WriterFunc) error { return EvalTiled(program, env, 8, open) },
+		"kernel": func(open WriterFunc) error { return kernel.RunTiled(env, 8, open) },
+	} {
+		got := make([]float64, len(want))
+		open := func(grid *raster.Info, result *raster.FlexRaster) (raster.Writer, error) {
+			if grid.Width != 37 || grid.Height != 23 {
+				t.Errorf("%s: grid %dx%d, want 37x23", name, grid.Width, grid.Height)
+			}
+			return writerFunc(func(w raster.Window, r *raster.FlexRaster) error {
+				for y := 0; y < w.Height; y++ {
+					r.Float64s(y*w.Width, (y+1)*w.Width, got[(w.YOff+y)*37+w.XOff:])
+				}
+				return nil
+			}), nil
+		}
+		if err := tiled(open); err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: pixel %d = %v, want %v", name, i, got[i], want[i])
+			}
+		}
+		if options.Window != nil || options.Grid != nil {
+			t.Errorf("%s: tiled evaluation left window %v and grid %v in the options", name, options.Window, options.Grid)
+		}
+	}
+}
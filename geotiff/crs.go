@@ -0,0 +1,64 @@
+package geotiff
+
+import (
+	"strconv"
+	"strings"
+)
+
+// EPSGCode returns the EPSG code of a CRS given as "EPSG:<code>", as read
+// from GeoKeys, or as WKT whose root names its EPSG authority, as GDAL
+// reports it. The authorities of the elements nested in the root, such as
+// its datum or units, don't name the CRS itself and are ignored.
+func EPSGCode(crs string) (int, bool) {
+	crs = strings.TrimSpace(crs)
+	if code, ok := strings.CutPrefix(crs, "EPSG:"); ok {
+		return parseCode(code)
+	}
+
+	authority := rootAuthority(crs)
+	if authority == "" {
+		return 0, false
+	}
+	name, code, ok := strings.Cut(authority, ",")
+	if !ok || strings.Trim(strings.TrimSpace(name), `"`) != "EPSG" {
+		return 0, false
+	}
+	return parseCode(strings.Trim(strings.TrimSpace(code), `"`))
+}
+
+func parseCode(code string) (int, bool) {
+	n, err := strconv.Atoi(code)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// rootAuthority returns the contents of the last AUTHORITY (WKT1) or ID
+// (WKT2) element directly within the root element of wkt, empty if there
+// is none.
+func rootAuthority(wkt string) string {
+	depth, quoted, start := 0, false, -1
+	authority := ""
+	for i := 0; i < len(wkt); i++ {
+		switch c := wkt[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '(':
+			depth++
+			if depth == 2 {
+				keyword := strings.TrimSpace(wkt[strings.LastIndexAny(wkt[:i], "[(,")+1 : i])
+				if keyword == "AUTHORITY" || keyword == "ID" {
+					start = i + 1
+				}
+			}
+		case c == ']' || c == ')':
+			if depth == 2 && start >= 0 {
+				authority, start = wkt[start:i], -1
+			}
+			depth--
+		}
+	}
+	return authority
+}
@@ -0,0 +1,61 @@
+package geotiff
+
+import "testing"
+
+const (
+	utm33WKT1 = `PROJCS["WGS 84 / UTM zone 33N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]],PROJECTION["Transverse_Mercator"],PARAMETER["central_meridian",15],UNIT["metre",1,AUTHORITY["EPSG","9001"]],AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","32633"]]`
+	utm33WKT2 = `PROJCRS["WGS 84 / UTM zone 33N",
+    BASEGEOGCRS["WGS 84",
+        DATUM["World Geodetic System 1984",
+            ELLIPSOID["WGS 84",6378137,298.257223563]],
+        ID["EPSG",4326]],
+    CONVERSION["UTM zone 33N",
+        METHOD["Transverse Mercator",
+            ID["EPSG",9807]]],
+    CS[Cartesian,2],
+    ID["EPSG",32633]]`
+	// A CRS without an authority of its own, whose last element has one.
+	localWKT = `PROJCS["local",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],AUTHORITY["EPSG","4326"]],PROJECTION["Transverse_Mercator"],UNIT["metre",1,AUTHORITY["EPSG","9001"]]]`
+)
+
+func TestEPSGCode(t *testing.T) {
+	for crs, want := range map[string]int{
+		"EPSG:32633":                             32633,
+		" EPSG:4326 ":                            4326,
+		utm33WKT1:                                32633,
+		utm33WKT2:                                32633,
+		localWKT:                                 0,
+		"EPSG:x":                                 0,
+		`GEOGCS["x",AUTHORITY["ESRI","104000"]]`: 0,
+		"":                                       0,
+	} {
+		code, ok := EPSGCode(crs)
+		if code != want || ok != (want != 0) {
+			t.Errorf("EPSGCode(%.40q) = %d, %v, want %d", crs, code, ok, want)
+		}
+	}
+}
+
+func TestGeoKeys(t *testing.T) {
+	projected := []uint16{1, 1, 0, 3, keyModelType, 0, 1, modelProjected, keyRasterType, 0, 1, rasterPixelIsArea, keyProjectedType, 0, 1, 32633}
+	for _, crs := range []string{"EPSG:32633", utm33WKT1, utm33WKT2} {
+		if got := geoKeys(crs); !equalKeys(got, projected) {
+			t.Errorf("geoKeys(%.40q) = %v, want %v", crs, got, projected)
+		}
+	}
+	if got := geoKeys(localWKT); len(got) != 8 {
+		t.Errorf("geoKeys of a CRS without an EPSG code = %v, want no CRS", got)
+	}
+}
+
+func equalKeys(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
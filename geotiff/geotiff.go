@@ -0,0 +1,622 @@
+// Package geotiff reads GeoTIFF files without GDAL: classic and BigTIFF,
+// stripped and tiled, uncompressed, LZW, deflate or zstd compressed, along
+// with their reduced resolution overviews and the GeoKeys that georeference
+// them. It also writes Cloud Optimized GeoTIFFs.
+package geotiff
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TIFF tags used by the reader.
+const (
+	tagNewSubfileType      = 254
+	tagImageWidth          = 256
+	tagImageLength         = 257
+	tagBitsPerSample       = 258
+	tagCompression         = 259
+	tagPhotometric         = 262
+	tagStripOffsets        = 273
+	tagSamplesPerPixel     = 277
+	tagRowsPerStrip        = 278
+	tagStripByteCounts     = 279
+	tagPlanarConfiguration = 284
+	tagPredictor           = 317
+	tagTileWidth           = 322
+	tagTileLength          = 323
+	tagTileOffsets         = 324
+	tagTileByteCounts      = 325
+	tagSampleFormat        = 339
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagModelTransformation = 34264
+	tagGeoKeyDirectory     = 34735
+	tagGDALMetadata        = 42112
+	tagGDALNoData          = 42113
+)
+
+// GeoKeys used by the reader.
+const (
+	keyRasterType     = 1025
+	keyGeographicType = 2048
+	keyProjectedType  = 3072
+
+	rasterPixelIsPoint = 2
+	userDefined        = 32767
+)
+
+// Compression schemes.
+const (
+	CompressionNone       = 1
+	CompressionLZW        = 5
+	CompressionDeflate    = 8
+	CompressionOldDeflate = 32946
+	CompressionZstd       = 50000
+)
+
+// Photometric interpretations.
+const (
+	PhotometricMinIsBlack = 1
+	PhotometricRGB        = 2
+)
+
+// NewSubfileType flags.
+const (
+	subfileReduced = 1
+	subfileMask    = 4
+)
+
+// DataType is the type of the samples of an image.
+type DataType int
+
+const (
+	Uint8 DataType = iota + 1
+	Int16
+	Uint16
+	Int32
+	Uint32
+	Float32
+	Float64
+)
+
+func (t DataType) String() string {
+	switch t {
+	case Uint8:
+		return "uint8"
+	case Int16:
+		return "int16"
+	case Uint16:
+		return "uint16"
+	case Int32:
+		return "int32"
+	case Uint32:
+		return "uint32"
+	case Float32:
+		return "float32"
+	case Float64:
+		return "float64"
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// Size returns the bytes taken by a sample of type t.
+func (t DataType) Size() int {
+	switch t {
+	case Uint8:
+		return 1
+	case Int16, Uint16:
+		return 2
+	case Float64:
+		return 8
+	default:
+		return 4
+	}
+}
+
+// maxBlockSamples bounds the samples of a block, so that a corrupt block
+// size is refused rather than allocated. It leaves room for images stored
+// as a single strip, well beyond the tiles of a few hundred pixels and the
+// strips of a few rows GDAL writes.
+const maxBlockSamples = 1 << 31
+
+// File is an open GeoTIFF.
+type File struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	big   bool
+	// size is the size of the file, -1 if the reader can't tell it.
+	size int64
+
+	// Images holds the full resolution image followed by its overviews,
+	// from the finest to the coarsest.
+	Images []*Image
+}
+
+// Image is a full resolution image or one of its overviews.
+type Image struct {
+	Width, Height int
+	Samples       int
+	DataType      DataType
+	// Photometric is the colour space of the samples, such as
+	// PhotometricRGB.
+	Photometric int
+	// Descriptions holds the description GDAL gives each sample, empty
+	// for samples without one.
+	Descriptions []string
+
+	Compression int
+	Predictor   int
+	// Planar is true for images storing each sample in its own blocks,
+	// false for images interleaving the samples of each pixel.
+	Planar bool
+
+	// Images are stored in blocks: tiles, or strips of whole rows.
+	Tiled                   bool
+	BlockWidth, BlockHeight int
+	Offsets, ByteCounts     []uint64
+
+	// GeoTransform is the GDAL affine transform from pixel to CRS
+	// coordinates, and Projection the CRS as "EPSG:<code>", empty if the
+	// file doesn't identify one.
+	GeoTransform [6]float64
+	Projection   string
+
+	NoData    float64
+	HasNoData bool
+
+	file *File
+}
+
+// Open reads the header and image directories of a GeoTIFF.
+func Open(r io.ReaderAt) (*File, error) {
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], 0); err != nil {
+		return nil, fmt.Errorf("reading TIFF header: %v", err)
+	}
+
+	f := &File{r: r}
+	switch string(header[:2]) {
+	case "II":
+		f.order = binary.LittleEndian
+	case "MM":
+		f.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+
+	var next uint64
+	switch f.order.Uint16(header[2:]) {
+	case 42:
+		next = uint64(f.order.Uint32(header[4:]))
+	case 43:
+		f.big = true
+		if _, err := r.ReadAt(header, 0); err != nil {
+			return nil, fmt.Errorf("reading BigTIFF header: %v", err)
+		}
+		if f.order.Uint16(header[4:]) != 8 {
+			return nil, fmt.Errorf("unsupported BigTIFF offset size %d", f.order.Uint16(header[4:]))
+		}
+		next = f.order.Uint64(header[8:])
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+
+	f.size = readerSize(r)
+
+	seen := map[uint64]bool{}
+	var ifds []ifd
+	for next != 0 {
+		if seen[next] {
+			return nil, fmt.Errorf("loop in image directories at offset %d", next)
+		}
+		seen[next] = true
+
+		d, n, err := f.readIFD(next)
+		if err != nil {
+			return nil, err
+		}
+		ifds = append(ifds, d)
+		next = n
+	}
+	if len(ifds) == 0 {
+		return nil, fmt.Errorf("TIFF file without images")
+	}
+
+	full, err := f.newImage(ifds[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := full.georeference(ifds[0]); err != nil {
+		return nil, err
+	}
+	f.Images = append(f.Images, full)
+
+	for _, d := range ifds[1:] {
+		var subfile uint64
+		if v := d.uints(tagNewSubfileType, f.order); len(v) > 0 {
+			subfile = v[0]
+		}
+		if subfile&subfileReduced == 0 || subfile&subfileMask != 0 {
+			continue
+		}
+		ov, err := f.newImage(d)
+		if err != nil {
+			return nil, err
+		}
+		ov.overviewOf(full)
+		f.Images = append(f.Images, ov)
+	}
+	sort.SliceStable(f.Images[1:], func(i, j int) bool { return f.Images[i+1].Width > f.Images[j+1].Width })
+
+	return f, nil
+}
+
+// Sizer is implemented by readers that know the size of the file they
+// read, such as *bytes.Reader and *io.SectionReader. Open checks the
+// lengths a file gives its directories, tags and blocks against the size
+// of files read by a Sizer or an *os.File.
+type Sizer interface {
+	// Size returns the size of the file, or -1 if it isn't known.
+	Size() int64
+}
+
+// readerSize returns the size of the file read by r, -1 if r can't tell.
+func readerSize(r io.ReaderAt) int64 {
+	switch r := r.(type) {
+	case Sizer:
+		return r.Size()
+	case *os.File:
+		if fi, err := r.Stat(); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// field is a decoded IFD entry.
+type field struct {
+	typ   uint16
+	count uint64
+	data  []byte
+}
+
+type ifd map[uint16]field
+
+// typeSizes maps TIFF field types to the bytes taken by one value.
+var typeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4, 16: 8, 17: 8, 18: 8,
+}
+
+// readIFD reads the directory at offset, returning its fields and the
+// offset of the next directory.
+func (f *File) readIFD(offset uint64) (ifd, uint64, error) {
+	countSize, entrySize, offsetSize := 2, 12, 4
+	if f.big {
+		countSize, entrySize, offsetSize = 8, 20, 8
+	}
+
+	buf := make([]byte, countSize)
+	if _, err := f.r.ReadAt(buf, int64(offset)); err != nil {
+		return nil, 0, fmt.Errorf("reading image directory at %d: %v", offset, err)
+	}
+	n := uint64(f.order.Uint16(buf))
+	if f.big {
+		n = f.order.Uint64(buf)
+	}
+	// There are only as many tags as classic TIFFs can hold entries.
+	length := n*uint64(entrySize) + uint64(offsetSize)
+	if n > math.MaxUint16 || f.size >= 0 && offset+uint64(countSize)+length > uint64(f.size) {
+		return nil, 0, fmt.Errorf("image directory at %d with %d entries overruns the file", offset, n)
+	}
+
+	buf = make([]byte, length)
+	if _, err := f.r.ReadAt(buf, int64(offset)+int64(countSize)); err != nil {
+		return nil, 0, fmt.Errorf("reading image directory at %d: %v", offset, err)
+	}
+
+	d := ifd{}
+	for i := uint64(0); i < n; i++ {
+		e := buf[i*uint64(entrySize) : (i+1)*uint64(entrySize)]
+		tag, typ := f.order.Uint16(e), f.order.Uint16(e[2:])
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+
+		var count uint64
+		var value []byte
+		if f.big {
+			count, value = f.order.Uint64(e[4:]), e[12:20]
+		} else {
+			count, value = uint64(f.order.Uint32(e[4:])), e[8:12]
+		}
+
+		if count > math.MaxInt64/uint64(size) || f.size >= 0 && count*uint64(size) > uint64(f.size) {
+			return nil, 0, fmt.Errorf("tag %d with %d values overruns the file", tag, count)
+		}
+		length := count * uint64(size)
+		data := make([]byte, length)
+		if length <= uint64(offsetSize) {
+			copy(data, value)
+		} else {
+			at := uint64(f.order.Uint32(value))
+			if f.big {
+				at = f.order.Uint64(value)
+			}
+			if _, err := f.r.ReadAt(data, int64(at)); err != nil {
+				return nil, 0, fmt.Errorf("reading tag %d: %v", tag, err)
+			}
+		}
+		d[tag] = field{typ, count, data}
+	}
+
+	last := buf[n*uint64(entrySize):]
+	next := uint64(f.order.Uint32(last))
+	if f.big {
+		next = f.order.Uint64(last)
+	}
+	return d, next, nil
+}
+
+// uints returns the values of an integer tag, nil if absent.
+func (d ifd) uints(tag uint16, order binary.ByteOrder) []uint64 {
+	fd, ok := d[tag]
+	if !ok {
+		return nil
+	}
+	values := make([]uint64, fd.count)
+	for i := range values {
+		switch fd.typ {
+		case 1, 6, 7:
+			values[i] = uint64(fd.data[i])
+		case 3, 8:
+			values[i] = uint64(order.Uint16(fd.data[2*i:]))
+		case 4, 9, 13:
+			values[i] = uint64(order.Uint32(fd.data[4*i:]))
+		case 16, 17, 18:
+			values[i] = order.Uint64(fd.data[8*i:])
+		}
+	}
+	return values
+}
+
+// floats returns the values of a floating point tag, nil if absent.
+func (d ifd) floats(tag uint16, order binary.ByteOrder) []float64 {
+	fd, ok := d[tag]
+	if !ok {
+		return nil
+	}
+	values := make([]float64, fd.count)
+	for i := range values {
+		switch fd.typ {
+		case 11:
+			values[i] = float64(math.Float32frombits(order.Uint32(fd.data[4*i:])))
+		case 12:
+			values[i] = math.Float64frombits(order.Uint64(fd.data[8*i:]))
+		}
+	}
+	return values
+}
+
+// ascii returns the value of a text tag without its terminating NULs.
+func (d ifd) ascii(tag uint16) (string, bool) {
+	fd, ok := d[tag]
+	if !ok {
+		return "", false
+	}
+	return strings.TrimRight(string(fd.data), "\x00"), true
+}
+
+func (f *File) newImage(d ifd) (*Image, error) {
+	get := func(tag uint16, def uint64) uint64 {
+		if v := d.uints(tag, f.order); len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+
+	img := &Image{
+		Width:       int(get(tagImageWidth, 0)),
+		Height:      int(get(tagImageLength, 0)),
+		Samples:     int(get(tagSamplesPerPixel, 1)),
+		Photometric: int(get(tagPhotometric, PhotometricMinIsBlack)),
+		Compression: int(get(tagCompression, CompressionNone)),
+		Predictor:   int(get(tagPredictor, 1)),
+		Planar:      get(tagPlanarConfiguration, 1) == 2,
+		file:        f,
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return nil, fmt.Errorf("image without size")
+	}
+	if img.Samples <= 0 {
+		return nil, fmt.Errorf("image without samples")
+	}
+
+	bits := d.uints(tagBitsPerSample, f.order)
+	if len(bits) == 0 {
+		bits = []uint64{1}
+	}
+	for _, b := range bits {
+		if b != bits[0] {
+			return nil, fmt.Errorf("unsupported mixed sample sizes %v", bits)
+		}
+	}
+	format := get(tagSampleFormat, 1)
+	switch {
+	case format == 1 && bits[0] == 8:
+		img.DataType = Uint8
+	case format == 1 && bits[0] == 16:
+		img.DataType = Uint16
+	case format == 2 && bits[0] == 16:
+		img.DataType = Int16
+	case format == 1 && bits[0] == 32:
+		img.DataType = Uint32
+	case format == 2 && bits[0] == 32:
+		img.DataType = Int32
+	case format == 3 && bits[0] == 32:
+		img.DataType = Float32
+	case format == 3 && bits[0] == 64:
+		img.DataType = Float64
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", format, bits[0])
+	}
+
+	switch img.Compression {
+	case CompressionNone, CompressionLZW, CompressionDeflate, CompressionOldDeflate, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", img.Compression)
+	}
+	switch img.Predictor {
+	case 1, 2:
+	case 3:
+		if img.DataType != Float32 && img.DataType != Float64 {
+			return nil, fmt.Errorf("floating point predictor on %v samples", img.DataType)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported predictor %d", img.Predictor)
+	}
+
+	if _, img.Tiled = d[tagTileWidth]; img.Tiled {
+		img.BlockWidth = int(get(tagTileWidth, 0))
+		img.BlockHeight = int(get(tagTileLength, 0))
+		img.Offsets = d.uints(tagTileOffsets, f.order)
+		img.ByteCounts = d.uints(tagTileByteCounts, f.order)
+	} else {
+		img.BlockWidth = img.Width
+		img.BlockHeight = int(min(get(tagRowsPerStrip, math.MaxUint32), uint64(img.Height)))
+		img.Offsets = d.uints(tagStripOffsets, f.order)
+		img.ByteCounts = d.uints(tagStripByteCounts, f.order)
+	}
+	if img.BlockWidth <= 0 || img.BlockHeight <= 0 || img.BlockWidth > maxBlockSamples/img.BlockHeight/img.Samples {
+		return nil, fmt.Errorf("invalid block size %dx%d", img.BlockWidth, img.BlockHeight)
+	}
+
+	blocks := img.blocksAcross() * img.blocksDown()
+	if img.Planar {
+		blocks *= img.Samples
+	}
+	if len(img.Offsets) != blocks || len(img.ByteCounts) != blocks {
+		return nil, fmt.Errorf("image has %d block offsets and %d byte counts, want %d", len(img.Offsets), len(img.ByteCounts), blocks)
+	}
+
+	if s, ok := d.ascii(tagGDALNoData); ok {
+		noData, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nodata value %q", s)
+		}
+		img.NoData, img.HasNoData = noData, true
+	}
+
+	img.Descriptions = make([]string, img.Samples)
+	if s, ok := d.ascii(tagGDALMetadata); ok {
+		if err := parseDescriptions(s, img.Descriptions); err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+// gdalMetadata is the XML document GDAL stores metadata of the dataset and
+// its bands in.
+type gdalMetadata struct {
+	Items []struct {
+		Name   string `xml:"name,attr"`
+		Sample *int   `xml:"sample,attr"`
+		Role   string `xml:"role,attr"`
+		Value  string `xml:",chardata"`
+	} `xml:"Item"`
+}
+
+// parseDescriptions sets the descriptions of the samples of an image from
+// its GDAL metadata.
+func parseDescriptions(doc string, descriptions []string) error {
+	var m gdalMetadata
+	if err := xml.Unmarshal([]byte(doc), &m); err != nil {
+		return fmt.Errorf("invalid GDAL metadata: %v", err)
+	}
+	for _, item := range m.Items {
+		if item.Role == "description" && item.Sample != nil && *item.Sample >= 0 && *item.Sample < len(descriptions) {
+			descriptions[*item.Sample] = strings.TrimSpace(item.Value)
+		}
+	}
+	return nil
+}
+
+func (img *Image) blocksAcross() int {
+	return (img.Width + img.BlockWidth - 1) / img.BlockWidth
+}
+
+func (img *Image) blocksDown() int {
+	return (img.Height + img.BlockHeight - 1) / img.BlockHeight
+}
+
+// georeference sets the geotransform and projection of the full resolution
+// image from its model tags and GeoKeys.
+func (img *Image) georeference(d ifd) error {
+	order := img.file.order
+
+	img.GeoTransform = [6]float64{0, 1, 0, 0, 0, 1}
+	if m := d.floats(tagModelTransformation, order); len(m) == 16 {
+		img.GeoTransform = [6]float64{m[3], m[0], m[1], m[7], m[4], m[5]}
+	} else if tie, scale := d.floats(tagModelTiepoint, order), d.floats(tagModelPixelScale, order); len(tie) >= 6 && len(scale) >= 2 {
+		img.GeoTransform = [6]float64{tie[3] - tie[0]*scale[0], scale[0], 0, tie[4] + tie[1]*scale[1], 0, -scale[1]}
+	}
+
+	keys := map[uint64]uint64{}
+	if dir := d.uints(tagGeoKeyDirectory, order); len(dir) >= 4 {
+		n := int(dir[3])
+		if len(dir) < 4+4*n {
+			return fmt.Errorf("truncated GeoKey directory")
+		}
+		for i := 0; i < n; i++ {
+			entry := dir[4+4*i : 8+4*i]
+			// Only short valued keys, stored in the directory itself, are
+			// needed.
+			if entry[1] == 0 {
+				keys[entry[0]] = entry[3]
+			}
+		}
+	}
+
+	// GDAL georeferences the corner of the first pixel, while PixelIsPoint
+	// files georeference its centre.
+	if keys[keyRasterType] == rasterPixelIsPoint {
+		gt := &img.GeoTransform
+		gt[0] -= 0.5*gt[1] + 0.5*gt[2]
+		gt[3] -= 0.5*gt[4] + 0.5*gt[5]
+	}
+
+	for _, key := range []uint64{keyProjectedType, keyGeographicType} {
+		if code, ok := keys[key]; ok && code != 0 && code != userDefined {
+			img.Projection = fmt.Sprintf("EPSG:%d", code)
+			break
+		}
+	}
+
+	return nil
+}
+
+// overviewOf georeferences an overview from its full resolution image.
+func (img *Image) overviewOf(full *Image) {
+	fx := float64(full.Width) / float64(img.Width)
+	fy := float64(full.Height) / float64(img.Height)
+
+	gt := full.GeoTransform
+	gt[1], gt[4] = gt[1]*fx, gt[4]*fx
+	gt[2], gt[5] = gt[2]*fy, gt[5]*fy
+	img.GeoTransform = gt
+	img.Projection = full.Projection
+
+	if !img.HasNoData {
+		img.NoData, img.HasNoData = full.NoData, full.HasNoData
+	}
+}
@@ -0,0 +1,88 @@
+package geotiff
+
+import "fmt"
+
+const (
+	lzwClear = 256
+	lzwEOI   = 257
+)
+
+// decodeLZW decompresses TIFF LZW data, expecting size bytes. TIFF LZW
+// differs from compress/lzw in switching to a wider code one code early,
+// so it is decoded here.
+func decodeLZW(src []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+
+	// Each table entry is a previous entry followed by one byte.
+	prefix := make([]int, 4096)
+	suffix := make([]byte, 4096)
+	first := make([]byte, 4096)
+	length := make([]int, 4096)
+	for i := 0; i < 256; i++ {
+		suffix[i], first[i], length[i] = byte(i), byte(i), 1
+	}
+
+	var bits uint32
+	var nBits uint
+	pos := 0
+	width := uint(9)
+	next := 258
+	prev := -1
+
+	for {
+		for nBits < width {
+			if pos >= len(src) {
+				// Some writers omit the end of information code.
+				return out, nil
+			}
+			bits = bits<<8 | uint32(src[pos])
+			pos++
+			nBits += 8
+		}
+		code := int(bits>>(nBits-width)) & (1<<width - 1)
+		nBits -= width
+
+		switch {
+		case code == lzwEOI:
+			return out, nil
+		case code == lzwClear:
+			width, next, prev = 9, 258, -1
+			continue
+		case prev == -1:
+			if code > 255 {
+				return nil, fmt.Errorf("invalid LZW code %d after clear", code)
+			}
+			out = append(out, byte(code))
+			prev = code
+			continue
+		case code > next || code == next && next >= 4096:
+			return nil, fmt.Errorf("invalid LZW code %d", code)
+		}
+
+		// A code not yet in the table is the previous string followed by
+		// its own first byte.
+		var c byte
+		if code == next {
+			c = first[prev]
+		} else {
+			c = first[code]
+		}
+		if next < 4096 {
+			prefix[next], suffix[next], first[next], length[next] = prev, c, first[prev], length[prev]+1
+			next++
+		}
+
+		start := len(out)
+		for i := 0; i < length[code]; i++ {
+			out = append(out, 0)
+		}
+		for i, k := len(out)-1, code; i >= start; i, k = i-1, prefix[k] {
+			out[i] = suffix[k]
+		}
+
+		prev = code
+		if next+1 >= 1<<width && width < 12 {
+			width++
+		}
+	}
+}
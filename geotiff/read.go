@@ -0,0 +1,316 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"go_raster_eval/zstdpool"
+)
+
+type sample interface {
+	~uint8 | ~int16 | ~uint16 | ~int32 | ~uint32 | ~float32 | ~float64
+}
+
+type integer interface {
+	~uint8 | ~int16 | ~uint16 | ~int32 | ~uint32
+}
+
+// Prefetcher is implemented by readers that benefit from knowing all the
+// byte ranges about to be read, such as readers over HTTP that can fetch
+// them in fewer requests. Image.Read passes them the blocks of the window
+// before reading them one at a time.
+type Prefetcher interface {
+	Prefetch(ranges []Range) error
+}
+
+// Range is a span of bytes of a file.
+type Range struct {
+	Offset, Length int64
+}
+
+// newSamples allocates n samples of type t.
+func newSamples(t DataType, n int) interface{} {
+	switch t {
+	case Uint8:
+		return make([]uint8, n)
+	case Int16:
+		return make([]int16, n)
+	case Uint16:
+		return make([]uint16, n)
+	case Int32:
+		return make([]int32, n)
+	case Uint32:
+		return make([]uint32, n)
+	case Float64:
+		return make([]float64, n)
+	default:
+		return make([]float32, n)
+	}
+}
+
+// Read reads sample band, numbered from 0, of the pixels of the window of
+// width w and height h at x, y, which must lie within the image. The
+// samples are returned in row major order, in the slice type matching the
+// DataType of the image. Only the blocks overlapping the window are read.
+func (img *Image) Read(band, x, y, w, h int) (interface{}, error) {
+	if band < 0 || band >= img.Samples {
+		return nil, fmt.Errorf("band %d out of range, image has %d", band, img.Samples)
+	}
+	if x < 0 || y < 0 || w <= 0 || h <= 0 || x+w > img.Width || y+h > img.Height {
+		return nil, fmt.Errorf("window %d %d %d %d outside %dx%d image", x, y, w, h, img.Width, img.Height)
+	}
+
+	if p, ok := img.file.r.(Prefetcher); ok {
+		var ranges []Range
+		for by := y / img.BlockHeight; by*img.BlockHeight < y+h; by++ {
+			for bx := x / img.BlockWidth; bx*img.BlockWidth < x+w; bx++ {
+				rg, err := img.blockRange(img.blockIndex(band, bx, by), by)
+				if err != nil {
+					return nil, err
+				}
+				ranges = append(ranges, rg)
+			}
+		}
+		if err := p.Prefetch(ranges); err != nil {
+			return nil, err
+		}
+	}
+
+	out := newSamples(img.DataType, w*h)
+	for by := y / img.BlockHeight; by*img.BlockHeight < y+h; by++ {
+		for bx := x / img.BlockWidth; bx*img.BlockWidth < x+w; bx++ {
+			block, err := img.readBlock(band, bx, by)
+			if err != nil {
+				return nil, err
+			}
+
+			// The part of the window within the block, relative to both.
+			x0, y0 := max(x, bx*img.BlockWidth), max(y, by*img.BlockHeight)
+			x1, y1 := min(x+w, (bx+1)*img.BlockWidth), min(y+h, (by+1)*img.BlockHeight)
+			stride, first := img.Samples, band
+			if img.Planar {
+				stride, first = 1, 0
+			}
+			p := placement{
+				srcOff:    ((y0-by*img.BlockHeight)*img.BlockWidth+x0-bx*img.BlockWidth)*stride + first,
+				srcRow:    img.BlockWidth * stride,
+				srcStride: stride,
+				dstOff:    (y0-y)*w + x0 - x,
+				dstRow:    w,
+				width:     x1 - x0,
+				height:    y1 - y0,
+			}
+
+			switch out := out.(type) {
+			case []uint8:
+				place(out, block.([]uint8), p)
+			case []int16:
+				place(out, block.([]int16), p)
+			case []uint16:
+				place(out, block.([]uint16), p)
+			case []int32:
+				place(out, block.([]int32), p)
+			case []uint32:
+				place(out, block.([]uint32), p)
+			case []float32:
+				place(out, block.([]float32), p)
+			case []float64:
+				place(out, block.([]float64), p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// placement locates a rectangle of samples within a block and the window
+// it is copied to.
+type placement struct {
+	srcOff, srcRow, srcStride int
+	dstOff, dstRow            int
+	width, height             int
+}
+
+func place[T sample](dst, src []T, p placement) {
+	for row := 0; row < p.height; row++ {
+		s, d := p.srcOff+row*p.srcRow, p.dstOff+row*p.dstRow
+		for col := 0; col < p.width; col++ {
+			dst[d+col] = src[s+col*p.srcStride]
+		}
+	}
+}
+
+// blockIndex returns the index in Offsets of the block at column bx and
+// row by of the block grid holding band.
+func (img *Image) blockIndex(band, bx, by int) int {
+	index := by*img.blocksAcross() + bx
+	if img.Planar {
+		index += band * img.blocksAcross() * img.blocksDown()
+	}
+	return index
+}
+
+// blockShape returns the rows of the blocks in row by of the block grid and
+// the samples of each of their pixels.
+func (img *Image) blockShape(by int) (rows, samples int) {
+	samples = img.Samples
+	if img.Planar {
+		samples = 1
+	}
+	// Strips are cut at the bottom of the image, tiles are padded.
+	rows = img.BlockHeight
+	if !img.Tiled {
+		rows = min(img.BlockHeight, img.Height-by*img.BlockHeight)
+	}
+	return rows, samples
+}
+
+// blockRange returns the bytes of the file holding block index, in row by
+// of the block grid. The byte count of the block is checked against the
+// bytes its samples can take, with the worst-case expansion of its
+// compression, and against the size of the file, so that a corrupt count
+// is refused rather than allocated.
+func (img *Image) blockRange(index, by int) (Range, error) {
+	rows, samples := img.blockShape(by)
+	size := uint64(img.BlockWidth*rows*samples) * uint64(img.DataType.Size())
+
+	offset, length := img.Offsets[index], img.ByteCounts[index]
+	limit := size
+	switch img.Compression {
+	case CompressionNone:
+		// Any bytes beyond the samples aren't needed.
+		length = min(length, size)
+	case CompressionLZW:
+		// Codes take up to 12 bits per byte, plus clear codes.
+		limit = size + size/2 + 1024
+	default:
+		// Deflate and zstd store incompressible data in raw blocks.
+		limit = size + size/8 + 1024
+	}
+	if length > limit {
+		return Range{}, fmt.Errorf("block %d has %d bytes, more than its %d bytes of samples can take", index, length, size)
+	}
+	if end := offset + length; end < offset || end > math.MaxInt64 || img.file.size >= 0 && end > uint64(img.file.size) {
+		return Range{}, fmt.Errorf("block %d at %d, of %d bytes, lies beyond the end of the file", index, offset, length)
+	}
+	return Range{int64(offset), int64(length)}, nil
+}
+
+// readBlock reads and decodes the block at column bx and row by of the
+// block grid, returning all its samples: every sample of each pixel for
+// interleaved images, only those of band for planar ones.
+func (img *Image) readBlock(band, bx, by int) (interface{}, error) {
+	index := img.blockIndex(band, bx, by)
+	rows, samples := img.blockShape(by)
+	n := img.BlockWidth * rows * samples
+	size := n * img.DataType.Size()
+
+	block := newSamples(img.DataType, n)
+	if img.ByteCounts[index] == 0 {
+		// Sparse files leave empty blocks unwritten.
+		return block, nil
+	}
+
+	rg, err := img.blockRange(index, by)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, rg.Length)
+	if _, err := img.file.r.ReadAt(raw, rg.Offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading block %d: %v", index, err)
+	}
+
+	switch img.Compression {
+	case CompressionLZW:
+		raw, err = decodeLZW(raw, size)
+	case CompressionDeflate, CompressionOldDeflate:
+		raw, err = inflate(raw, size)
+	case CompressionZstd:
+		raw, err = zstdpool.Decoder().DecodeAll(raw, make([]byte, 0, size))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing block %d: %v", index, err)
+	}
+	if len(raw) < size {
+		return nil, fmt.Errorf("block %d holds %d bytes, want %d", index, len(raw), size)
+	}
+	raw = raw[:size]
+
+	order := img.file.order
+	if img.Predictor == 3 {
+		raw = unpredictFloats(raw, img.BlockWidth, rows, samples, img.DataType.Size())
+		order = binary.BigEndian
+	}
+	if err := binary.Read(bytes.NewReader(raw), order, block); err != nil {
+		return nil, fmt.Errorf("decoding block %d: %v", index, err)
+	}
+
+	if img.Predictor == 2 {
+		switch block := block.(type) {
+		case []uint8:
+			unpredict(block, img.BlockWidth, samples)
+		case []int16:
+			unpredict(block, img.BlockWidth, samples)
+		case []uint16:
+			unpredict(block, img.BlockWidth, samples)
+		case []int32:
+			unpredict(block, img.BlockWidth, samples)
+		case []uint32:
+			unpredict(block, img.BlockWidth, samples)
+		default:
+			return nil, fmt.Errorf("horizontal predictor on %v samples", img.DataType)
+		}
+	}
+
+	return block, nil
+}
+
+func inflate(raw []byte, size int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out := make([]byte, size)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// unpredict undoes horizontal differencing, which stores each sample as
+// the difference from the same sample of the previous pixel of the row.
+func unpredict[T integer](block []T, width, samples int) {
+	row := width * samples
+	for start := 0; start < len(block); start += row {
+		r := block[start : start+row]
+		for i := samples; i < len(r); i++ {
+			r[i] += r[i-samples]
+		}
+	}
+}
+
+// unpredictFloats undoes the floating point predictor, which splits the
+// samples of each row into planes of bytes, most significant first, and
+// differences the bytes. The samples are returned big endian.
+func unpredictFloats(raw []byte, width, rows, samples, size int) []byte {
+	out := make([]byte, len(raw))
+	count := width * samples
+	row := count * size
+	for y := 0; y < rows; y++ {
+		r, o := raw[y*row:(y+1)*row], out[y*row:(y+1)*row]
+		for i := samples; i < len(r); i++ {
+			r[i] += r[i-samples]
+		}
+		for i := 0; i < count; i++ {
+			for b := 0; b < size; b++ {
+				o[i*size+b] = r[b*count+i]
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,243 @@
+package geotiff
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCorruptDirectory(t *testing.T) {
+	classic := []byte("II*\x00\x08\x00\x00\x00\xff\xff")
+	big := make([]byte, 24)
+	copy(big, "II+\x00\x08\x00\x00\x00\x10")
+	binary.LittleEndian.PutUint64(big[16:], 1<<60)
+
+	for name, file := range map[string][]byte{"classic": classic, "BigTIFF": big} {
+		_, err := Open(bytes.NewReader(file))
+		if err == nil || !strings.Contains(err.Error(), "overruns the file") {
+			t.Errorf("%s: Open of a directory with more entries than the file holds: %v", name, err)
+		}
+	}
+}
+
+func TestCorruptByteCount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "b.tif")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := Raster{Width: 32, Height: 32, DataType: Uint16, Data: make([]uint16, 32*32)}
+	if err := WriteCOG(f, []Raster{level}, Georef{}, COGOptions{BlockSize: 16, Compression: CompressionDeflate}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiff, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := tiff.Images[0]
+	if _, err := img.Read(0, 0, 0, 32, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	img.ByteCounts[0] = 1 << 40
+	if _, err := img.Read(0, 0, 0, 16, 16); err == nil || !strings.Contains(err.Error(), "more than its 512 bytes") {
+		t.Errorf("Read of a block longer than its samples can take: %v", err)
+	}
+	img.ByteCounts[0] = 520
+	img.Offsets[0] = uint64(len(data)) - 100
+	if _, err := img.Read(0, 0, 0, 16, 16); err == nil || !strings.Contains(err.Error(), "beyond the end of the file") {
+		t.Errorf("Read of a block past the end of the file: %v", err)
+	}
+}
+
+// stripFile assembles a little-endian TIFF holding one image of width by
+// height pixels stored in strips of rowsPerStrip rows, with the tags given
+// besides those locating the strips. The strips are written as they are,
+// so images can be decoded from blocks encoded by other software.
+func stripFile(t *testing.T, width, height, rowsPerStrip int, tags []entry, strips ...[]byte) *Image {
+	t.Helper()
+	e := &encoder{order: binary.LittleEndian}
+
+	data := []byte("II*\x00\x00\x00\x00\x00")
+	var offsets, counts []uint64
+	for _, strip := range strips {
+		offsets, counts = append(offsets, uint64(len(data))), append(counts, uint64(len(strip)))
+		data = append(data, strip...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+
+	tags = append(tags,
+		e.long(tagImageWidth, uint32(width)),
+		e.long(tagImageLength, uint32(height)),
+		e.long(tagRowsPerStrip, uint32(rowsPerStrip)),
+		e.offsets(tagStripOffsets, offsets...),
+		e.offsets(tagStripByteCounts, counts...),
+	)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].tag < tags[j].tag })
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+	data = append(data, e.ifd(tags, uint64(len(data)), true)...)
+
+	tiff, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tiff.Images[0]
+}
+
+// readAll reads sample band of a whole image.
+func readAll(t *testing.T, img *Image, band int) interface{} {
+	t.Helper()
+	data, err := img.Read(band, 0, 0, img.Width, img.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// lzwFixture is 320 bytes, byte(i*37 + i/256), compressed with TIFF LZW
+// and checked against golang.org/x/image/tiff/lzw. It holds over 254 codes,
+// so it is read past the switch from 9 to 10 bit codes, which TIFF makes
+// one code earlier than other LZW formats.
+const lzwFixture = "gAAEpKN6UXLeAYoJpyS68cIGFZQOqaX7kBIuKZ4TrCc4MGJWPagYrqB40LJ+UbIdoSG5cQKmZbwCo6L6EVLO" +
+	"eYYHpiQ6saL2DZAMqKV7UfIeIZoRqya78EJGNaQWraf4kJJuSa4boCE5MOKWXbgAoqJ50TK+cYIFpSO6cYLmBYwK" +
+	"p6T7EdIOGZYPqiY7sCI2LaAUrKd4UHJeQaoZryC48MKGVbQeoaH5kRKuab4DpCM6MWLWfYgIpqR60bL+EZINqSW7" +
+	"cAImJZwSq6b4EFJOOaYXriA4sKJ2TbAcoKF5UPKeYboBoyK58ULGdYQGpaP6kZLuCY4LqCU7MeIWHZgQqqZ70DI+" +
+	"MaIVrSe4cIJmRawar6D5ENKOWbYfoiI5sSJbG217Ytm2rbty3bet+4LhuK47kuW5rnui6bquu7Ltu677wvG8rzvS" +
+	"9b2oCA=="
+
+func TestDecodeLZW(t *testing.T) {
+	src, err := base64.StdEncoding.DecodeString(lzwFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]uint8, 320)
+	for i := range want {
+		want[i] = byte(i*37 + i/256)
+	}
+
+	e := &encoder{order: binary.LittleEndian}
+	img := stripFile(t, 20, 16, 16, []entry{
+		e.shorts(tagBitsPerSample, 8),
+		e.shorts(tagCompression, CompressionLZW),
+	}, src)
+	if got := readAll(t, img, 0).([]uint8); !bytes.Equal(got, want) {
+		t.Errorf("LZW strip decoded to %v, want %v", got, want)
+	}
+}
+
+func TestHorizontalPredictor(t *testing.T) {
+	// Two samples a pixel, each stored as the difference from the same
+	// sample of the previous pixel of its row.
+	e := &encoder{order: binary.LittleEndian}
+	strip := uint16Bytes(
+		100, 7, 5, 1, 65535, 2, // 100 7, 105 8, 104 10
+		200, 0, 65436, 3, 50, 65533, // 200 0, 100 3, 150 0
+	)
+	img := stripFile(t, 3, 2, 2, []entry{
+		e.shorts(tagBitsPerSample, 16, 16),
+		e.shorts(tagCompression, CompressionNone),
+		e.shorts(tagSamplesPerPixel, 2),
+		e.shorts(tagPredictor, 2),
+	}, strip)
+
+	for band, want := range [][]uint16{{100, 105, 104, 200, 100, 150}, {7, 8, 10, 0, 3, 0}} {
+		if got := readAll(t, img, band).([]uint16); !equalSamples(got, want) {
+			t.Errorf("band %d = %v, want %v", band, got, want)
+		}
+	}
+}
+
+func TestFloatingPointPredictor(t *testing.T) {
+	// Each row holds the bytes of its samples big endian, split into
+	// planes of the most significant bytes first, then differenced:
+	// 1.0 and 2.5 are 3f800000 and 40200000, planes 3f 40 80 20 00 00 00
+	// 00; -1.0 and 0.5 are bf800000 and 3f000000.
+	e := &encoder{order: binary.LittleEndian}
+	strip := []byte{
+		0x3f, 0x01, 0x40, 0xa0, 0xe0, 0x00, 0x00, 0x00,
+		0xbf, 0x80, 0x41, 0x80, 0x00, 0x00, 0x00, 0x00,
+	}
+	img := stripFile(t, 2, 2, 2, []entry{
+		e.shorts(tagBitsPerSample, 32),
+		e.shorts(tagCompression, CompressionNone),
+		e.shorts(tagSampleFormat, 3),
+		e.shorts(tagPredictor, 3),
+	}, strip)
+
+	want := []float32{1, 2.5, -1, 0.5}
+	if got := readAll(t, img, 0).([]float32); !equalSamples(got, want) {
+		t.Errorf("floating point predictor decoded to %v, want %v", got, want)
+	}
+}
+
+func TestStrips(t *testing.T) {
+	// Five rows in strips of two, the last strip holding a single row.
+	e := &encoder{order: binary.LittleEndian}
+	img := stripFile(t, 3, 5, 2, []entry{
+		e.shorts(tagBitsPerSample, 8),
+		e.shorts(tagCompression, CompressionNone),
+	}, []byte{1, 2, 3, 4, 5, 6}, []byte{7, 8, 9, 10, 11, 12}, []byte{13, 14, 15})
+
+	if got, want := readAll(t, img, 0).([]uint8), []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}; !bytes.Equal(got, want) {
+		t.Errorf("strips decoded to %v, want %v", got, want)
+	}
+	// A window across the strips reads the rows of each.
+	got, err := img.Read(0, 1, 1, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint8{5, 6, 8, 9, 11, 12, 14, 15}; !bytes.Equal(got.([]uint8), want) {
+		t.Errorf("window decoded to %v, want %v", got, want)
+	}
+}
+
+func TestPlanarConfiguration(t *testing.T) {
+	// Each sample is stored in strips of its own, those of the first
+	// sample first.
+	e := &encoder{order: binary.LittleEndian}
+	img := stripFile(t, 2, 3, 2, []entry{
+		e.shorts(tagBitsPerSample, 8, 8),
+		e.shorts(tagCompression, CompressionNone),
+		e.shorts(tagSamplesPerPixel, 2),
+		e.shorts(tagPlanarConfiguration, 2),
+	}, []byte{1, 2, 3, 4}, []byte{5, 6}, []byte{10, 20, 30, 40}, []byte{50, 60})
+
+	for band, want := range [][]uint8{{1, 2, 3, 4, 5, 6}, {10, 20, 30, 40, 50, 60}} {
+		if got := readAll(t, img, band).([]uint8); !bytes.Equal(got, want) {
+			t.Errorf("band %d = %v, want %v", band, got, want)
+		}
+	}
+}
+
+func uint16Bytes(values ...uint16) []byte {
+	b := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+func equalSamples[T sample](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,564 @@
+package geotiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"go_raster_eval/zstdpool"
+)
+
+// Tags and GeoKeys written in addition to those the reader uses.
+const (
+	keyModelType = 1024
+
+	modelProjected    = 1
+	modelGeographic   = 2
+	rasterPixelIsArea = 1
+)
+
+// TIFF field types written.
+const (
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+	typeASCII  = 2
+	typeLong8  = 16
+)
+
+// Raster is an image to write, its samples in row major order in the slice
+// type matching DataType. Images of several bands hold them one after the
+// other, and are written with each band in its own tiles.
+type Raster struct {
+	Width, Height int
+	// Samples is the number of bands, zero meaning one.
+	Samples  int
+	DataType DataType
+	Data     interface{}
+}
+
+func (r Raster) samples() int {
+	return max(r.Samples, 1)
+}
+
+// Georef georeferences a written image. Projection is "EPSG:<code>" or WKT
+// naming its EPSG authority; other CRSs are written without GeoKeys.
+type Georef struct {
+	GeoTransform [6]float64
+	Projection   string
+	NoData       float64
+	HasNoData    bool
+	// Descriptions name the bands, written as GDAL metadata if any of them
+	// is set.
+	Descriptions []string
+}
+
+// COGOptions configure WriteCOG.
+type COGOptions struct {
+	// BlockSize is the side of the square tiles, a multiple of 16.
+	BlockSize int
+	// Compression is one of CompressionNone, CompressionDeflate and
+	// CompressionZstd.
+	Compression int
+	// Predictor differences samples before compressing them: horizontally
+	// for integers, byte-wise for floating point.
+	Predictor bool
+}
+
+// structuralMetadata is the GDAL ghost area describing the layout of the
+// files WriteCOG writes, so readers can rely on it without checking.
+const structuralMetadata = "LAYOUT=IFDS_BEFORE_DATA\nBLOCK_ORDER=ROW_MAJOR\nKNOWN_INCOMPATIBLE_EDITION=NO\n"
+
+// WriteCOG writes a Cloud Optimized GeoTIFF of levels, the full resolution
+// image followed by its overviews from the finest to the coarsest. All the
+// image directories come first, in that order, followed by the tiles of the
+// coarsest overview through to those of the full resolution image, so that
+// clients fetch the header in one request and each level in as few as
+// possible.
+func WriteCOG(w io.WriteSeeker, levels []Raster, geo Georef, options COGOptions) error {
+	if len(levels) == 0 {
+		return fmt.Errorf("nothing to write")
+	}
+	if options.BlockSize <= 0 || options.BlockSize%16 != 0 {
+		return fmt.Errorf("invalid block size %d, must be a positive multiple of 16", options.BlockSize)
+	}
+	switch options.Compression {
+	case CompressionNone, CompressionDeflate, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression %d", options.Compression)
+	}
+
+	// Compression rarely grows data, so the uncompressed size tells
+	// whether offsets could overflow 32 bits, with room to spare for data
+	// that doesn't compress.
+	var size int64
+	for _, level := range levels {
+		size += int64(level.Width) * int64(level.Height) * int64(level.samples()) * int64(level.DataType.Size())
+	}
+	e := &encoder{order: binary.LittleEndian, big: size > math.MaxUint32/2, options: options}
+
+	ghost := fmt.Sprintf("GDAL_STRUCTURAL_METADATA_SIZE=%06d bytes\n%s", len(structuralMetadata), structuralMetadata)
+	if len(ghost)%2 == 1 {
+		ghost += " "
+	}
+	headerSize := 8
+	if e.big {
+		headerSize = 16
+	}
+
+	// Lay the directories out with zeroed tile offsets to find where the
+	// data starts, then write the tiles and fill the offsets in.
+	offsets := make([][]uint64, len(levels))
+	counts := make([][]uint64, len(levels))
+	for i, level := range levels {
+		n := blocks(level.Width, options.BlockSize) * blocks(level.Height, options.BlockSize) * level.samples()
+		offsets[i], counts[i] = make([]uint64, n), make([]uint64, n)
+	}
+	dirs := func() ([]byte, error) {
+		var out []byte
+		start := uint64(headerSize + len(ghost))
+		for i, level := range levels {
+			entries, err := e.entries(level, i > 0, geo, offsets[i], counts[i])
+			if err != nil {
+				return nil, err
+			}
+			last := i == len(levels)-1
+			dir := e.ifd(entries, start+uint64(len(out)), last)
+			out = append(out, dir...)
+		}
+		return out, nil
+	}
+	header, err := dirs()
+	if err != nil {
+		return err
+	}
+
+	at := uint64(headerSize + len(ghost) + len(header))
+	if _, err := w.Seek(int64(at), io.SeekStart); err != nil {
+		return err
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		level := levels[i]
+		tiles := 0
+		for band := 0; band < level.samples(); band++ {
+			for by := 0; by < blocks(level.Height, options.BlockSize); by++ {
+				for bx := 0; bx < blocks(level.Width, options.BlockSize); bx++ {
+					tile, err := e.tile(level, band, bx, by)
+					if err != nil {
+						return err
+					}
+					if _, err := w.Write(tile); err != nil {
+						return err
+					}
+					offsets[i][tiles], counts[i][tiles] = at, uint64(len(tile))
+					at += uint64(len(tile))
+					tiles++
+				}
+			}
+		}
+	}
+
+	if header, err = dirs(); err != nil {
+		return err
+	}
+	head := make([]byte, headerSize, headerSize+len(ghost)+len(header))
+	copy(head, "II")
+	if e.big {
+		e.order.PutUint16(head[2:], 43)
+		e.order.PutUint16(head[4:], 8)
+		e.order.PutUint64(head[8:], uint64(headerSize+len(ghost)))
+	} else {
+		e.order.PutUint16(head[2:], 42)
+		e.order.PutUint32(head[4:], uint32(headerSize+len(ghost)))
+	}
+	head = append(append(head, ghost...), header...)
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = w.Write(head)
+	return err
+}
+
+func blocks(size, blockSize int) int {
+	return (size + blockSize - 1) / blockSize
+}
+
+type encoder struct {
+	order   binary.ByteOrder
+	big     bool
+	options COGOptions
+}
+
+type entry struct {
+	tag, typ uint16
+	count    int
+	data     []byte
+}
+
+func (e *encoder) shorts(tag uint16, values ...uint16) entry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		e.order.PutUint16(data[2*i:], v)
+	}
+	return entry{tag, typeShort, len(values), data}
+}
+
+func (e *encoder) long(tag uint16, value uint32) entry {
+	data := make([]byte, 4)
+	e.order.PutUint32(data, value)
+	return entry{tag, typeLong, 1, data}
+}
+
+// offsets encodes file offsets and sizes, which are 64 bit in BigTIFF.
+func (e *encoder) offsets(tag uint16, values ...uint64) entry {
+	if e.big {
+		data := make([]byte, 8*len(values))
+		for i, v := range values {
+			e.order.PutUint64(data[8*i:], v)
+		}
+		return entry{tag, typeLong8, len(values), data}
+	}
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		e.order.PutUint32(data[4*i:], uint32(v))
+	}
+	return entry{tag, typeLong, len(values), data}
+}
+
+func (e *encoder) doubles(tag uint16, values ...float64) entry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		e.order.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return entry{tag, typeDouble, len(values), data}
+}
+
+func (e *encoder) ascii(tag uint16, s string) entry {
+	return entry{tag, typeASCII, len(s) + 1, append([]byte(s), 0)}
+}
+
+// entries returns the tags of a level, sorted.
+func (e *encoder) entries(level Raster, overview bool, geo Georef, offsets, counts []uint64) ([]entry, error) {
+	format, bits := uint16(1), uint16(8*level.DataType.Size())
+	switch level.DataType {
+	case Int16, Int32:
+		format = 2
+	case Float32, Float64:
+		format = 3
+	case Uint8, Uint16, Uint32:
+	default:
+		return nil, fmt.Errorf("unsupported data type %v", level.DataType)
+	}
+
+	predictor := uint16(1)
+	if e.options.Predictor && e.options.Compression != CompressionNone {
+		predictor = 2
+		if format == 3 {
+			predictor = 3
+		}
+	}
+
+	samples := level.samples()
+	planar := uint16(1)
+	if samples > 1 {
+		planar = 2
+	}
+
+	var entries []entry
+	if overview {
+		entries = append(entries, e.long(tagNewSubfileType, subfileReduced))
+	}
+	entries = append(entries,
+		e.long(tagImageWidth, uint32(level.Width)),
+		e.long(tagImageLength, uint32(level.Height)),
+		e.shorts(tagBitsPerSample, repeat(bits, samples)...),
+		e.shorts(tagCompression, uint16(e.options.Compression)),
+		e.shorts(tagPhotometric, PhotometricMinIsBlack),
+		e.shorts(tagSamplesPerPixel, uint16(samples)),
+		e.shorts(tagPlanarConfiguration, planar),
+		e.shorts(tagPredictor, predictor),
+		e.shorts(tagTileWidth, uint16(e.options.BlockSize)),
+		e.shorts(tagTileLength, uint16(e.options.BlockSize)),
+		e.offsets(tagTileOffsets, offsets...),
+		e.offsets(tagTileByteCounts, counts...),
+		e.shorts(tagSampleFormat, repeat(format, samples)...),
+	)
+
+	if !overview {
+		gt := geo.GeoTransform
+		if gt[2] == 0 && gt[4] == 0 {
+			entries = append(entries,
+				e.doubles(tagModelPixelScale, gt[1], -gt[5], 0),
+				e.doubles(tagModelTiepoint, 0, 0, 0, gt[0], gt[3], 0))
+		} else {
+			entries = append(entries, e.doubles(tagModelTransformation,
+				gt[1], gt[2], 0, gt[0],
+				gt[4], gt[5], 0, gt[3],
+				0, 0, 0, 0,
+				0, 0, 0, 1))
+		}
+		entries = append(entries, e.shorts(tagGeoKeyDirectory, geoKeys(geo.Projection)...))
+		if metadata := gdalMetadataXML(geo.Descriptions); metadata != "" {
+			entries = append(entries, e.ascii(tagGDALMetadata, metadata))
+		}
+	}
+	if geo.HasNoData {
+		noData := strconv.FormatFloat(geo.NoData, 'g', -1, 64)
+		if math.IsNaN(geo.NoData) {
+			noData = "nan"
+		}
+		entries = append(entries, e.ascii(tagGDALNoData, noData))
+	}
+
+	return entries, nil
+}
+
+func repeat(v uint16, n int) []uint16 {
+	values := make([]uint16, n)
+	for i := range values {
+		values[i] = v
+	}
+	return values
+}
+
+// gdalMetadataXML returns the GDAL metadata document describing the bands,
+// or "" if none of them has a description.
+func gdalMetadataXML(descriptions []string) string {
+	var b strings.Builder
+	for i, d := range descriptions {
+		if d == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "  <Item name=\"DESCRIPTION\" sample=\"%d\" role=\"description\">", i)
+		xml.EscapeText(&b, []byte(d))
+		b.WriteString("</Item>\n")
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "<GDALMetadata>\n" + b.String() + "</GDALMetadata>"
+}
+
+// geoKeys returns the GeoKey directory of a CRS. Codes given as "EPSG:<code>"
+// are taken as geographic in the 4000-4999 range of the EPSG registry, and
+// projected otherwise.
+func geoKeys(projection string) []uint16 {
+	projection = strings.TrimSpace(projection)
+
+	code, ok := EPSGCode(projection)
+	var geographic bool
+	if strings.HasPrefix(projection, "EPSG:") {
+		geographic = code >= 4000 && code < 5000
+	} else {
+		geographic = strings.HasPrefix(projection, "GEOGCS") || strings.HasPrefix(projection, "GEOGCRS")
+	}
+
+	// Codes that don't fit a GeoKey, such as ESRI codes above 65535, can't
+	// be recorded, so the CRS is left out.
+	keys := []uint16{1, 1, 0, 1, keyRasterType, 0, 1, rasterPixelIsArea}
+	if !ok || code > math.MaxUint16 {
+		return keys
+	}
+
+	model, crsKey := uint16(modelProjected), uint16(keyProjectedType)
+	if geographic {
+		model, crsKey = modelGeographic, keyGeographicType
+	}
+	keys = []uint16{1, 1, 0, 3,
+		keyModelType, 0, 1, model,
+		keyRasterType, 0, 1, rasterPixelIsArea,
+		crsKey, 0, 1, uint16(code)}
+	return keys
+}
+
+// ifd encodes a directory to be written at offset, followed by the values
+// that don't fit in its entries.
+func (e *encoder) ifd(entries []entry, offset uint64, last bool) []byte {
+	countSize, entrySize, valueSize := 2, 12, 4
+	if e.big {
+		countSize, entrySize, valueSize = 8, 20, 8
+	}
+
+	dirSize := countSize + len(entries)*entrySize + valueSize
+	dir := make([]byte, dirSize)
+	if e.big {
+		e.order.PutUint64(dir, uint64(len(entries)))
+	} else {
+		e.order.PutUint16(dir, uint16(len(entries)))
+	}
+
+	var values []byte
+	for i, en := range entries {
+		b := dir[countSize+i*entrySize:]
+		e.order.PutUint16(b, en.tag)
+		e.order.PutUint16(b[2:], en.typ)
+		value := b[8:12]
+		if e.big {
+			e.order.PutUint64(b[4:], uint64(en.count))
+			value = b[12:20]
+		} else {
+			e.order.PutUint32(b[4:], uint32(en.count))
+		}
+
+		if len(en.data) <= valueSize {
+			copy(value, en.data)
+			continue
+		}
+		at := offset + uint64(dirSize+len(values))
+		if e.big {
+			e.order.PutUint64(value, at)
+		} else {
+			e.order.PutUint32(value, uint32(at))
+		}
+		values = append(values, en.data...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+
+	if !last {
+		next := offset + uint64(dirSize+len(values))
+		if e.big {
+			e.order.PutUint64(dir[dirSize-valueSize:], next)
+		} else {
+			e.order.PutUint32(dir[dirSize-valueSize:], uint32(next))
+		}
+	}
+	return append(dir, values...)
+}
+
+// tile encodes the tile of a band at column bx and row by of level, padding
+// it with zeros past the edges of the image.
+func (e *encoder) tile(level Raster, band, bx, by int) ([]byte, error) {
+	size := e.options.BlockSize
+	x0, y0 := bx*size, by*size
+	width, height := min(size, level.Width-x0), min(size, level.Height-y0)
+
+	offset := band * level.Width * level.Height
+	block, err := cutBlock(level.Data, offset, level.Width, x0, y0, width, height, size)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := e.samples(&buf, block); err != nil {
+		return nil, err
+	}
+	raw := buf.Bytes()
+
+	if e.options.Predictor && e.options.Compression != CompressionNone {
+		switch level.DataType {
+		case Float32, Float64:
+			raw = predictFloats(raw, size, size, level.DataType.Size())
+		}
+	}
+
+	switch e.options.Compression {
+	case CompressionDeflate:
+		var out bytes.Buffer
+		z := zlib.NewWriter(&out)
+		if _, err := z.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	case CompressionZstd:
+		return zstdpool.Encoder().EncodeAll(raw, nil), nil
+	}
+	return raw, nil
+}
+
+// cutBlock copies the w*h pixels at x0, y0 of the image of the given width
+// starting at offset in data into a new size*size block.
+func cutBlock(data interface{}, offset, width, x0, y0, w, h, size int) (interface{}, error) {
+	switch data := data.(type) {
+	case []uint8:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []int16:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []uint16:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []int32:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []uint32:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []float32:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	case []float64:
+		return cut(data[offset:], width, x0, y0, w, h, size), nil
+	}
+	return nil, fmt.Errorf("unsupported raster data %T", data)
+}
+
+func cut[T sample](data []T, width, x0, y0, w, h, size int) []T {
+	block := make([]T, size*size)
+	for y := 0; y < h; y++ {
+		copy(block[y*size:y*size+w], data[(y0+y)*width+x0:])
+	}
+	return block
+}
+
+// samples encodes a block, applying the horizontal predictor to integer
+// samples when requested.
+func (e *encoder) samples(buf *bytes.Buffer, block interface{}) error {
+	if e.options.Predictor && e.options.Compression != CompressionNone {
+		switch block := block.(type) {
+		case []uint8:
+			predict(block, e.options.BlockSize)
+		case []int16:
+			predict(block, e.options.BlockSize)
+		case []uint16:
+			predict(block, e.options.BlockSize)
+		case []int32:
+			predict(block, e.options.BlockSize)
+		case []uint32:
+			predict(block, e.options.BlockSize)
+		}
+	}
+	// Floating point samples are written big endian when predicted, as
+	// predictFloats splits them into bytes from the most significant.
+	order := e.order
+	switch block.(type) {
+	case []float32, []float64:
+		if e.options.Predictor && e.options.Compression != CompressionNone {
+			order = binary.BigEndian
+		}
+	}
+	return binary.Write(buf, order, block)
+}
+
+// predict is the inverse of unpredict for single sample pixels.
+func predict[T integer](block []T, width int) {
+	for start := 0; start < len(block); start += width {
+		r := block[start : start+width]
+		for i := len(r) - 1; i > 0; i-- {
+			r[i] -= r[i-1]
+		}
+	}
+}
+
+// predictFloats is the inverse of unpredictFloats for single sample pixels,
+// taking big endian samples.
+func predictFloats(raw []byte, width, rows, size int) []byte {
+	out := make([]byte, len(raw))
+	row := width * size
+	for y := 0; y < rows; y++ {
+		r, o := raw[y*row:(y+1)*row], out[y*row:(y+1)*row]
+		for i := 0; i < width; i++ {
+			for b := 0; b < size; b++ {
+				o[b*width+i] = r[i*size+b]
+			}
+		}
+		for i := len(o) - 1; i > 0; i-- {
+			o[i] -= o[i-1]
+		}
+	}
+	return out
+}
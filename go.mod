@@ -0,0 +1,5 @@
+module go_raster_eval
+
+go 1.22
+
+require github.com/klauspost/compress v1.17.11
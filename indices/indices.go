@@ -0,0 +1,165 @@
+// Package indices is a catalogue of spectral indices written as
+// expressions over the semantic band names of the sensor package.
+package indices
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/lexer"
+	"go_raster_eval/parser"
+	"go_raster_eval/sensor"
+	"go_raster_eval/token"
+)
+
+type Index struct {
+	Name        string
+	Description string
+	Formula     string
+	// Reflectance is set for indices whose formulas hold constants, such as
+	// the soil adjustment of SAVI, that only make sense for bands holding
+	// surface reflectance rather than digital numbers.
+	Reflectance bool
+
+	once    sync.Once
+	program *ast.Program
+	err     error
+}
+
+// Parse parses the index formula with the expression parser. The formula is
+// parsed once and the program shared by every caller, so it must not be
+// modified.
+func (i *Index) Parse() (*ast.Program, error) {
+	i.once.Do(func() {
+		p := parser.New(lexer.New(i.Formula))
+		i.program = p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			i.err = fmt.Errorf("parsing index %s: %s", i.Name, strings.Join(p.Errors(), "; "))
+		}
+	})
+	return i.program, i.err
+}
+
+// Program returns the index formula ready to be evaluated with the bands of
+// the given sensor profile. Reflectance indices have each band scaled by
+// the profile into surface reflectance, and can't be evaluated with a
+// profile that doesn't define that scaling. Without a profile the bands are
+// taken to hold reflectance already.
+func (i *Index) Program(profile *sensor.Profile) (*ast.Program, error) {
+	program, err := i.Parse()
+	if err != nil || !i.Reflectance || profile == nil {
+		return program, err
+	}
+	if profile.Scale == 0 {
+		return nil, fmt.Errorf("index %s needs surface reflectance, but sensor profile %s doesn't define the scale of its bands", i.Name, profile.Name)
+	}
+
+	scaled := &ast.Program{}
+	for _, stmt := range program.Statements {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok {
+			stmt = &ast.ExpressionStatement{Token: es.Token, Expression: scale(es.Expression, profile)}
+		}
+		scaled.Statements = append(scaled.Statements, stmt)
+	}
+	return scaled, nil
+}
+
+// scale copies an index formula with every band name replaced by the
+// band's reflectance, (band * Scale + Offset).
+func scale(node ast.Expression, profile *sensor.Profile) ast.Expression {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		var expr ast.Expression = infix("*", node, number(profile.Scale))
+		if profile.Offset != 0 {
+			expr = infix("+", expr, number(profile.Offset))
+		}
+		return expr
+	case *ast.PrefixExpression:
+		return &ast.PrefixExpression{Token: node.Token, Operator: node.Operator, Right: scale(node.Right, profile)}
+	case *ast.InfixExpression:
+		return &ast.InfixExpression{Token: node.Token, Left: scale(node.Left, profile), Operator: node.Operator, Right: scale(node.Right, profile)}
+	}
+	return node
+}
+
+func infix(operator string, left, right ast.Expression) *ast.InfixExpression {
+	return &ast.InfixExpression{Token: token.Token{Type: token.TokenType(operator), Literal: operator}, Left: left, Operator: operator, Right: right}
+}
+
+func number(value float64) *ast.NumberLiteral {
+	return &ast.NumberLiteral{Token: token.Token{Type: token.NUMBER, Literal: strconv.FormatFloat(value, 'g', -1, 64)}, Value: float32(value)}
+}
+
+var catalogue = map[string]*Index{
+	"ndvi": {
+		Name:        "ndvi",
+		Description: "Normalised Difference Vegetation Index",
+		Formula:     "(nir - red) / (nir + red);",
+	},
+	"gndvi": {
+		Name:        "gndvi",
+		Description: "Green Normalised Difference Vegetation Index",
+		Formula:     "(nir - green) / (nir + green);",
+	},
+	"evi": {
+		Name:        "evi",
+		Description: "Enhanced Vegetation Index",
+		Formula:     "2.5 * (nir - red) / (nir + 6 * red - 7.5 * blue + 1);",
+		Reflectance: true,
+	},
+	"savi": {
+		Name:        "savi",
+		Description: "Soil Adjusted Vegetation Index (L = 0.5)",
+		Formula:     "1.5 * (nir - red) / (nir + red + 0.5);",
+		Reflectance: true,
+	},
+	"ndwi": {
+		Name:        "ndwi",
+		Description: "Normalised Difference Water Index (McFeeters)",
+		Formula:     "(green - nir) / (green + nir);",
+	},
+	"mndwi": {
+		Name:        "mndwi",
+		Description: "Modified Normalised Difference Water Index",
+		Formula:     "(green - swir1) / (green + swir1);",
+	},
+	"ndmi": {
+		Name:        "ndmi",
+		Description: "Normalised Difference Moisture Index",
+		Formula:     "(nir - swir1) / (nir + swir1);",
+	},
+	"nbr": {
+		Name:        "nbr",
+		Description: "Normalised Burn Ratio",
+		Formula:     "(nir - swir2) / (nir + swir2);",
+	},
+	"nbr2": {
+		Name:        "nbr2",
+		Description: "Normalised Burn Ratio 2",
+		Formula:     "(swir1 - swir2) / (swir1 + swir2);",
+	},
+	"ndbi": {
+		Name:        "ndbi",
+		Description: "Normalised Difference Built-up Index",
+		Formula:     "(swir1 - nir) / (swir1 + nir);",
+	},
+}
+
+func Get(name string) (*Index, bool) {
+	i, ok := catalogue[name]
+	return i, ok
+}
+
+// List returns the catalogue sorted by index name.
+func List() []*Index {
+	list := make([]*Index, 0, len(catalogue))
+	for _, i := range catalogue {
+		list = append(list, i)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Name < list[b].Name })
+	return list
+}
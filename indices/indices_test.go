@@ -0,0 +1,59 @@
+package indices
+
+import (
+	"strings"
+	"testing"
+
+	"go_raster_eval/sensor"
+)
+
+func TestParseOnce(t *testing.T) {
+	for _, index := range List() {
+		first, err := index.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, _ := index.Parse()
+		if first != second {
+			t.Errorf("%s parsed twice", index.Name)
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	savi, _ := Get("savi")
+	ndvi, _ := Get("ndvi")
+	c2, _ := sensor.GetProfile("landsat8_c2")
+	s2, _ := sensor.GetProfile("sentinel2")
+	c1, _ := sensor.GetProfile("landsat8")
+
+	tests := []struct {
+		index   *Index
+		profile *sensor.Profile
+		want    string
+	}{
+		{savi, nil, "((1.5 * (nir - red)) / ((nir + red) + 0.5))"},
+		{ndvi, c2, "((nir - red) / (nir + red))"},
+		{savi, c2, "((1.5 * (((nir * 2.75e-05) + -0.2) - ((red * 2.75e-05) + -0.2))) / ((((nir * 2.75e-05) + -0.2) + ((red * 2.75e-05) + -0.2)) + 0.5))"},
+		{savi, s2, "((1.5 * ((nir * 0.0001) - (red * 0.0001))) / (((nir * 0.0001) + (red * 0.0001)) + 0.5))"},
+	}
+	for _, tt := range tests {
+		program, err := tt.index.Program(tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := program.String(); got != tt.want {
+			t.Errorf("%s with %v:\n got %s\nwant %s", tt.index.Name, tt.profile, got, tt.want)
+		}
+	}
+
+	// Scaling must leave the shared program untouched.
+	parsed, _ := savi.Parse()
+	if got := parsed.String(); strings.Contains(got, "0.0001") {
+		t.Errorf("Program modified the parsed formula: %s", got)
+	}
+
+	if _, err := savi.Program(c1); err == nil {
+		t.Error("savi computed from Collection 1 digital numbers")
+	}
+}
@@ -1,7 +1,7 @@
 package lexer
 
 import (
-	"../token"
+	"go_raster_eval/token"
 )
 
 type Lexer struct {
@@ -29,7 +29,7 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '#':
 		tok = newToken(token.FILTER, l.ch)
@@ -42,15 +42,55 @@ func (l *Lexer) NextToken() token.Token {
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case ',':
+		tok = newToken(token.COMMA, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case '"':
+		literal, ok := l.readString()
+		if !ok {
+			// The string runs to the end of the input, so there is no
+			// closing quote to step over.
+			return token.Token{Type: token.ILLEGAL, Literal: `"` + literal}
+		}
+		tok.Type = token.STRING
+		tok.Literal = literal
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
+	case '{':
+		tok = newToken(token.LBRACE, l.ch)
+	case '}':
+		tok = newToken(token.RBRACE, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
+	case '.':
+		// A dot starts a number like .5, otherwise it selects a band by
+		// name, as in scene.nir.
+		if isDecimal(l.peekChar()) {
+			tok.Type = token.NUMBER
+			tok.Literal = l.readNumber()
+			return tok
+		}
+		tok = newToken(token.DOT, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -98,12 +138,27 @@ func (l *Lexer) peekChar() byte {
 
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) || isDigit(l.ch) {
+	for isLetter(l.ch) || isDecimal(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
+// readString reads a string literal, reporting false if the input ends
+// before its closing quote.
+func (l *Lexer) readString() (string, bool) {
+	position := l.position + 1
+	for {
+		l.readChar()
+		if l.ch == '"' {
+			return l.input[position:l.position], true
+		}
+		if l.ch == 0 {
+			return l.input[position:l.position], false
+		}
+	}
+}
+
 func (l *Lexer) readNumber() string {
 	position := l.position
 	for isDigit(l.ch) {
@@ -117,7 +172,11 @@ func isLetter(ch byte) bool {
 }
 
 func isDigit(ch byte) bool {
-	return '0' <= ch && ch <= '9' || ch == '.'
+	return isDecimal(ch) || ch == '.'
+}
+
+func isDecimal(ch byte) bool {
+	return '0' <= ch && ch <= '9'
 }
 
 func newToken(tokenType token.TokenType, ch byte) token.Token {
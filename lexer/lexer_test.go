@@ -0,0 +1,38 @@
+package lexer
+
+import (
+	"testing"
+
+	"go_raster_eval/token"
+)
+
+func TestStrings(t *testing.T) {
+	l := New(`qa(BQA, "cloud")`)
+	want := []token.Token{
+		{Type: token.IDENT, Literal: "qa"},
+		{Type: token.LPAREN, Literal: "("},
+		{Type: token.IDENT, Literal: "BQA"},
+		{Type: token.COMMA, Literal: ","},
+		{Type: token.STRING, Literal: "cloud"},
+		{Type: token.RPAREN, Literal: ")"},
+		{Type: token.EOF, Literal: ""},
+	}
+	for i, w := range want {
+		if tok := l.NextToken(); tok != w {
+			t.Fatalf("token %d = %+v, want %+v", i, tok, w)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := New(`qa(BQA, "cloud`)
+	for i := 0; i < 4; i++ {
+		l.NextToken()
+	}
+	if tok := l.NextToken(); tok.Type != token.ILLEGAL || tok.Literal != `"cloud` {
+		t.Fatalf("got %+v, want ILLEGAL", tok)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("got %+v after the string, want EOF", tok)
+	}
+}
@@ -1,32 +1,442 @@
 package main
 
 import (
-	"./evaluator"
-	"./lexer"
-	"./object"
-	"./parser"
+	"flag"
 	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/compiler"
+	"go_raster_eval/evaluator"
+	"go_raster_eval/indices"
+	"go_raster_eval/lexer"
+	"go_raster_eval/object"
+	"go_raster_eval/optimizer"
+	"go_raster_eval/parser"
+	"go_raster_eval/raster"
+	"go_raster_eval/render"
+	"go_raster_eval/sensor"
+	"go_raster_eval/vm"
 )
 
 func main() {
+	sensorName := flag.String("sensor", "", "sensor profile resolving band aliases such as red or nir")
+	profiles := flag.String("profiles", "", "JSON file with additional sensor profiles")
+	script := flag.String("f", "", "script file to evaluate instead of an expression argument")
+	searchPath := flag.String("path", os.Getenv("REX_PATH"), "list of directories searched by import statements")
+	scene := flag.String("scene", raster.DefaultPattern, "band files or URLs, with %s standing for the band name")
+	reader := flag.String("reader", raster.DefaultReader, fmt.Sprintf("backend bands are read with, one of %v", raster.Readers()))
+	output := flag.String("o", "", "file or, for Zarr, directory the result is written to, evaluated tile by tile; for programs with out statements, %s stands for the output name, which is otherwise appended to the base name")
+	stack := flag.Bool("stack", false, "write the outputs of out statements as the bands of the single file -o names, rather than a file each")
+	format := flag.String("format", "gtiff", "format of -o: gtiff, through GDAL, cog, a Cloud Optimized GeoTIFF written in pure Go, or zarr, a Zarr array directory")
+	compress := flag.String("compress", "deflate", "compression of COG and Zarr output: none, deflate or zstd, or blosc for Zarr")
+	predictor := flag.Bool("predictor", false, "difference pixels before compressing COG output")
+	blockSize := flag.Int("blocksize", 512, "side of the internal tiles of COG output and the chunks of Zarr output")
+	crs := flag.String("crs", "", "CRS -o output is reprojected to, such as EPSG:4326; reprojected output is held in memory")
+	resolution := flag.Float64("resolution", 0, "pixel size of -o output in units of its CRS, 0 to keep about the resolution of the result")
+	quicklook := flag.String("quicklook", "", "PNG or JPEG file the result is drawn to, evaluated in memory; programs with three out statements are drawn as a red, green and blue composite")
+	colorMap := flag.String("colormap", "greys", fmt.Sprintf("colour map of -quicklook, one of %v, with _r reversing it", render.ColorMaps()))
+	stretchMode := flag.String("stretch", "percentile", "stretch of -quicklook: linear, between the minimum and the maximum, percentile, clipping -percent at either end, or min,max")
+	percent := flag.Float64("percent", 2, "percentage of pixels clipped at either end by the percentile stretch")
+	tileSize := flag.Int("tile", 512, "tile size in pixels used when writing to a file")
+	fused := flag.Bool("fused", false, "compile the program into a single per-pixel kernel")
+	useVM := flag.Bool("vm", false, "compile the program to bytecode and run it on the block VM")
+	overview := flag.Int("overview", 0, "overview level bands are read at, 0 for full resolution")
+	preview := flag.Int("preview", 0, "read bands at the coarsest overview at least this many pixels on a side")
+	align := flag.String("align", "", "resample bands onto a common grid: finest or coarsest, the grid of the band with the smallest or largest pixels, the grid of a named band, or a pixel size for the extent of the finest band")
+	resampling := flag.String("resampling", "nearest", "resampling of -align, -crs and -resolution: nearest, bilinear, cubic or average")
+	window := flag.String("window", "", "pixel region to evaluate as xoff,yoff,width,height")
+	bbox := flag.String("bbox", "", "georeferenced region to evaluate as minx,miny,maxx,maxy")
+	bboxCRS := flag.String("bbox-crs", "", "CRS of -bbox, such as EPSG:4326; defaults to the CRS of the bands")
+	workers := flag.Int("workers", 0, "goroutines used per raster operation, 0 for one per CPU")
+	cacheSize := flag.Int("cache", 0, "memory limit in MiB of a cache keeping the bands read for reuse by later evaluations, 0 for none")
+	optimize := flag.Bool("O", false, "optimise the program: fold constants, drop identities and share repeated subexpressions")
+	printAST := flag.Bool("ast", false, "print the program, after optimisation if -O is set, and exit")
+	listIndices := flag.Bool("indices", false, "list the built-in spectral indices and exit")
+	flag.Parse()
+
+	if *fused && *useVM {
+		fmt.Fprintln(os.Stderr, "-fused and -vm select different evaluation strategies; use one of them")
+		os.Exit(2)
+	}
+
+	if *listIndices {
+		for _, index := range indices.List() {
+			description := index.Description
+			if index.Reflectance {
+				description += " [reflectance]"
+			}
+			fmt.Printf("%-6s %-60s %s\n", index.Name, description, index.Formula)
+		}
+		return
+	}
+
+	if *profiles != "" {
+		if err := sensor.Load(*profiles); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	options := &object.Options{Workers: *workers, Overview: *overview, PreviewSize: *preview}
+	if *cacheSize > 0 {
+		options.Cache = raster.NewCache(int64(*cacheSize) << 20)
+	}
+	source := raster.DefaultSource
+	if *reader != raster.DefaultReader || *scene != raster.DefaultPattern {
+		var err error
+		source, err = raster.NewSource(*reader, *scene)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options.Source = source
+	}
+	// Sources reading local files keep them open for the whole run.
+	if closer, ok := source.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if *searchPath != "" {
+		options.SearchPath = filepath.SplitList(*searchPath)
+	}
+	method, err := raster.ParseResampling(*resampling)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *align != "" {
+		options.Align = &raster.Alignment{Grid: *align, Resampling: method}
+		if size, err := strconv.ParseFloat(*align, 64); err == nil {
+			if size <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid -align pixel size %v\n", size)
+				os.Exit(1)
+			}
+			options.Align.Grid, options.Align.PixelSize = "finest", size
+		}
+	}
+	if *window != "" {
+		var w raster.Window
+		if _, err := fmt.Sscanf(*window, "%d,%d,%d,%d", &w.XOff, &w.YOff, &w.Width, &w.Height); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -window %q: %v\n", *window, err)
+			os.Exit(1)
+		}
+		options.Region = &w
+	}
+	if *bbox != "" {
+		b := raster.BBox{CRS: *bboxCRS}
+		if _, err := fmt.Sscanf(*bbox, "%g,%g,%g,%g", &b.MinX, &b.MinY, &b.MaxX, &b.MaxY); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -bbox %q: %v\n", *bbox, err)
+			os.Exit(1)
+		}
+		options.BBox = &b
+	}
+	if *sensorName != "" {
+		s, err := sensor.GetProfile(*sensorName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options.Sensor = s
+	}
+
 	//input := `(B5 - B4) / (B5 + B4);`
 	input := `B5 # (BQA == 32768);`
+	//input := `B5 # qa(BQA, "cloud");`
 	//input := `((B4+1) / B5) + 1;`
 	//input := `((B5 + 1) / B3) + 1;`
+	//input := `(nir - red) / (nir + red);`
+	if flag.NArg() > 0 {
+		input = flag.Arg(0)
+	}
+	if *script != "" {
+		src, err := ioutil.ReadFile(*script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		input = string(src)
+
+		abs, err := filepath.Abs(*script)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options.ImportStack = []string{abs}
+	}
 	l := lexer.New(input)
 
 	p := parser.New(l)
-	fmt.Println(p)
 	prog := p.ParseProgram()
-	for _, s := range prog.Statements {
-		fmt.Println(s)
+	if len(p.Errors()) > 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		os.Exit(1)
+	}
+	if *optimize {
+		prog = optimizer.Optimize(prog)
 	}
-	env := object.NewEnvironment()
-	obj := evaluator.Eval(prog, env)
-	//fmt.Println("AAAA", obj.Type(), obj.Inspect(), env)
-	fmt.Println("AAAA", obj.(*object.Raster).Value)
+	if *printAST {
+		for _, s := range prog.Statements {
+			fmt.Println(s)
+		}
+		return
+	}
+	env := object.NewEnvironmentWithOptions(options)
+	if err := evaluator.AlignBands(prog, env); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// run evaluates the program with the selected strategy; nil means the
+	// tree-walking evaluator.
+	var run func(env *object.Environment) (*raster.FlexRaster, error)
+	if *fused {
+		kernel, err := evaluator.Compile(prog, env)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "not fusing:", err)
+		} else {
+			run = kernel.Run
+		}
+	}
+	if *useVM {
+		c := compiler.New(env)
+		if err := c.Compile(prog); err != nil {
+			fmt.Fprintln(os.Stderr, "not compiling:", err)
+		} else {
+			machine := vm.New(c.Bytecode())
+			run = machine.Run
+		}
+	}
+
+	if *quicklook != "" {
+		if err := drawQuicklook(*quicklook, prog, env, run, *colorMap, *stretchMode, *percent); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *output != "" {
+		// create opens path in the format selected, with a band for each of
+		// names, or a single band if there are none.
+		create := func(path string, grid *raster.Info, names []string, rasterType raster.RasterType, noData float64) (raster.StackWriter, error) {
+			bands := names
+			if bands == nil {
+				bands = []string{""}
+			}
+			switch *format {
+			case "gtiff":
+				return raster.NewGTiffStackWriter(path, grid, bands, rasterType, noData)
+			case "cog":
+				options := raster.COGOptions{BlockSize: *blockSize, Compression: *compress, Predictor: *predictor}
+				return raster.NewCOGStackWriter(path, grid, bands, rasterType, noData, options)
+			case "zarr":
+				options := raster.ZarrOptions{ChunkSize: *blockSize, Compression: *compress}
+				if names == nil {
+					return raster.NewZarrWriter(path, grid, rasterType, noData, options)
+				}
+				return raster.NewZarrStackWriter(path, grid, names, rasterType, noData, options)
+			}
+			return nil, fmt.Errorf("unknown output format %q, available: gtiff, cog, zarr", *format)
+		}
+		// warp returns the grid output is written on and a function
+		// wrapping the writers of that grid to take results on grid.
+		var warp *raster.Warp
+		if *crs != "" || *resolution > 0 {
+			warp = &raster.Warp{CRS: *crs, Resolution: *resolution, Resampling: method}
+		}
+		target := func(grid *raster.Info) (*raster.Info, func(raster.Writer) raster.Writer, error) {
+			if warp == nil {
+				return grid, func(w raster.Writer) raster.Writer { return w }, nil
+			}
+			to, err := warp.Grid(grid)
+			if err != nil {
+				return nil, nil, err
+			}
+			return to, func(w raster.Writer) raster.Writer { return raster.NewWarpWriter(w, grid, to, method) }, nil
+		}
+		open := func(grid *raster.Info, result *raster.FlexRaster) (raster.Writer, error) {
+			to, wrap, err := target(grid)
+			if err != nil {
+				return nil, err
+			}
+			w, err := create(*output, to, nil, result.RasterType, result.NoData)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(w), nil
+		}
+		openOutputs := func(grid *raster.Info, names []string, results []*raster.FlexRaster) ([]raster.Writer, error) {
+			to, wrap, err := target(grid)
+			if err != nil {
+				return nil, err
+			}
+			if *stack {
+				rasterType, noData := raster.StackType(results)
+				w, err := create(*output, to, names, rasterType, noData)
+				if err != nil {
+					return nil, err
+				}
+				writers := raster.SplitStack(w, len(names), rasterType, noData)
+				for i := range writers {
+					writers[i] = wrap(writers[i])
+				}
+				return writers, nil
+			}
+			writers := make([]raster.Writer, len(names))
+			for i, name := range names {
+				w, err := create(outputPath(*output, name), to, nil, results[i].RasterType, results[i].NoData)
+				if err != nil {
+					for _, w := range writers[:i] {
+						w.Close()
+					}
+					return nil, err
+				}
+				writers[i] = wrap(w)
+			}
+			return writers, nil
+		}
+		var err error
+		switch {
+		case evaluator.OutputNames(prog) != nil:
+			err = evaluator.EvalTiledOutputs(prog, env, *tileSize, openOutputs)
+		case run != nil:
+			err = evaluator.EvalTiledFunc(run, env, *tileSize, open)
+		default:
+			err = evaluator.EvalTiled(prog, env, *tileSize, open)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if run != nil {
+		r, err := run(env)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		printResult("", r)
+		return
+	}
+
+	switch obj := evaluator.Eval(prog, env).(type) {
+	case *object.Outputs:
+		for i, name := range obj.Names {
+			r, ok := obj.Values[i].(*object.Raster)
+			if !ok {
+				fmt.Printf("%s: %s\n", name, obj.Values[i].Inspect())
+				continue
+			}
+			printResult(name, &r.Value)
+		}
+	case *object.Raster:
+		printResult("", &obj.Value)
+	case *object.Error:
+		fmt.Fprintln(os.Stderr, obj.Message)
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "expression result is %s, not a raster\n", obj.Type())
+		os.Exit(1)
+	}
+}
+
+// printResult prints the type, size and statistics of a result evaluated
+// in memory, preceded by its name if it has one.
+func printResult(name string, r *raster.FlexRaster) {
+	if name != "" {
+		fmt.Printf("%s: ", name)
+	}
+	stats := r.Stats()
+	fmt.Printf("%s %dx%d, nodata %g, %d valid pixels", r.RasterType, r.Width, r.Height, r.NoData, stats.Valid)
+	if stats.Valid > 0 {
+		fmt.Printf(", min %g, max %g, mean %g", stats.Min, stats.Max, stats.Mean)
+	}
+	fmt.Println()
+}
+
+// outputPath returns the file an output of a program is written to: output
+// formatted with its name if it contains %s, and otherwise output with the
+// name appended to its base name, as in result_ndvi.tif.
+func outputPath(output, name string) string {
+	if strings.Contains(output, "%s") {
+		return fmt.Sprintf(output, name)
+	}
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "_" + name + ext
 }
 
+// drawQuicklook evaluates the program in memory and draws it to path: a
+// single result or output through the colour map named, three outputs as a
+// composite.
+func drawQuicklook(path string, prog *ast.Program, env *object.Environment, run func(*object.Environment) (*raster.FlexRaster, error), colorMap, mode string, percent float64) error {
+	var stretch render.Stretch
+	switch mode {
+	case "linear":
+	case "percentile":
+		stretch.Percent = percent
+	default:
+		if _, err := fmt.Sscanf(mode, "%g,%g", &stretch.Min, &stretch.Max); err != nil || stretch.Min == stretch.Max {
+			return fmt.Errorf("invalid -stretch %q, expected linear, percentile or min,max", mode)
+		}
+	}
+
+	var results []*raster.FlexRaster
+	if run != nil {
+		r, err := run(env)
+		if err != nil {
+			return err
+		}
+		results = []*raster.FlexRaster{r}
+	} else {
+		switch obj := evaluator.Eval(prog, env).(type) {
+		case *object.Raster:
+			results = []*raster.FlexRaster{&obj.Value}
+		case *object.Outputs:
+			for i, val := range obj.Values {
+				r, ok := val.(*object.Raster)
+				if !ok {
+					return fmt.Errorf("output %s is %s, not a raster", obj.Names[i], val.Type())
+				}
+				results = append(results, &r.Value)
+			}
+		case *object.Error:
+			return fmt.Errorf("%s", obj.Message)
+		case nil:
+			return fmt.Errorf("expression has no result")
+		default:
+			return fmt.Errorf("expression result is %s, not a raster", obj.Type())
+		}
+	}
+
+	var img image.Image
+	var err error
+	switch len(results) {
+	case 1:
+		cmap, cerr := render.GetColorMap(colorMap)
+		if cerr != nil {
+			return cerr
+		}
+		img, err = render.Render(results[0], cmap, stretch)
+	case 3:
+		img, err = render.RenderRGB(results[0], results[1], results[2], stretch)
+	default:
+		return fmt.Errorf("cannot draw %d outputs, only one or three for a composite", len(results))
+	}
+	if err != nil {
+		return err
+	}
+	return render.WriteFile(path, img)
+}
 
 /*
 package main
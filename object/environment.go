@@ -1,8 +1,16 @@
 package object
 
+import "go_raster_eval/ast"
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	outer   *Environment
+	options *Options
+
+	// caller and body are set in the environments of function calls: the
+	// environment the call was made from and the body being evaluated.
+	caller *Environment
+	body   *ast.BlockStatement
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -18,13 +26,55 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+func (e *Environment) Options() *Options {
+	return e.options
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.options = outer.options
+	return env
+}
+
+// NewCallEnvironment returns the environment a call of fn made from
+// caller evaluates its body in, enclosed by the environment fn closes over.
+func NewCallEnvironment(fn *Function, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(fn.Env)
+	env.caller, env.body = caller, fn.Body
+	return env
+}
+
+// Calling reports whether a call of fn is still being evaluated in e, so
+// a call of fn from e would recurse.
+func (e *Environment) Calling(fn *Function) bool {
+	for e != nil {
+		if e.body == nil {
+			e = e.outer
+			continue
+		}
+		if e.body == fn.Body {
+			return true
+		}
+		e = e.caller
+	}
+	return false
+}
+
+// NewEnclosedEnvironmentWithOptions returns an environment enclosed by
+// outer that evaluates with options of its own, such as a copy of the
+// options of outer restricted to a tile.
+func NewEnclosedEnvironmentWithOptions(outer *Environment, options *Options) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.options = options
 	return env
 }
 
 func NewEnvironment() *Environment {
+	return NewEnvironmentWithOptions(&Options{})
+}
+
+func NewEnvironmentWithOptions(options *Options) *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, options: options}
 }
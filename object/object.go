@@ -1,8 +1,12 @@
 package object
 
 import (
-	"../raster"
+	"bytes"
 	"fmt"
+	"strings"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/raster"
 )
 
 type ObjectType string
@@ -14,8 +18,13 @@ const (
 	RASTER_OBJ  = "RASTER"
 	NUMBER_OBJ  = "NUMBER"
 	BOOLEAN_OBJ = "BOOLEAN"
+	STRING_OBJ  = "STRING"
+
+	FUNCTION_OBJ = "FUNCTION"
+	BUILTIN_OBJ  = "BUILTIN"
 
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	OUTPUTS_OBJ      = "OUTPUTS"
 )
 
 type Object interface {
@@ -46,6 +55,47 @@ type Boolean struct {
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
 
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+
+type Function struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+type BuiltinFunction func(env *Environment, args ...Object) Object
+
+type Builtin struct {
+	Fn BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return "builtin function" }
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -58,6 +108,24 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Outputs is the result of a program with out statements: the value of
+// each output, in the order of the statements.
+type Outputs struct {
+	Names  []string
+	Values []Object
+}
+
+func (o *Outputs) Type() ObjectType { return OUTPUTS_OBJ }
+func (o *Outputs) Inspect() string {
+	var out bytes.Buffer
+
+	for i, name := range o.Names {
+		out.WriteString(name + ": " + o.Values[i].Inspect() + "\n")
+	}
+
+	return out.String()
+}
+
 type Error struct {
 	Message string
 }
@@ -0,0 +1,75 @@
+package object
+
+import (
+	"go_raster_eval/ast"
+	"go_raster_eval/raster"
+	"go_raster_eval/sensor"
+)
+
+// Options configure an evaluation run. They are attached to the outermost
+// environment and shared by every environment enclosed by it.
+type Options struct {
+	// Sensor resolves semantic band names. A nil Sensor means identifiers
+	// are used as band identifiers verbatim.
+	Sensor *sensor.Profile
+
+	// Source reads the bands. Nil reads them from raster.DefaultSource.
+	Source raster.Source
+
+	// Workers bounds the number of goroutines used by raster kernels. Zero
+	// uses one per CPU.
+	Workers int
+
+	// Overview selects the resolution bands are read at: 0 reads them at
+	// full resolution and n reads their nth overview.
+	Overview int
+	// PreviewSize, when set and Overview is 0, picks the coarsest overview
+	// still at least PreviewSize pixels on its longer side on the first
+	// band read.
+	PreviewSize int
+
+	// Region restricts the evaluation to a block of pixels of the bands.
+	// BBox does the same with a georeferenced box, translated into the
+	// pixels of every band read, and takes precedence over Region.
+	Region *raster.Window
+	BBox   *raster.BBox
+
+	// Align, when set, reads every band onto a common grid, resampling
+	// the bands that aren't on it, instead of taking the grid of the first
+	// band read and requiring the others to match it. Region and BBox then
+	// select pixels of the common grid.
+	Align *raster.Alignment
+
+	// Window restricts band reads to a block of pixels of Grid. Nil reads
+	// the whole grid.
+	Window *raster.Window
+	// Grid describes the extent of the first band read during the
+	// evaluation, or the common grid bands are aligned to, or the part of
+	// it within the region when one is set. It is filled in by the
+	// evaluator.
+	Grid *raster.Info
+	// Regions remembers the pixels of each band within BBox. It is filled
+	// in by the evaluator along with Grid.
+	Regions *raster.Regions
+
+	// Bands holds the bands read while evaluating a program so each one is
+	// read once. Every evaluation strategy sets it up for the programs it
+	// runs, through evaluator.BeginRun.
+	Bands *raster.Cache
+	// Cache, when set, keeps bands across evaluations, as in a long-running
+	// process evaluating many expressions over the same scene. It is
+	// bounded by its own memory limit.
+	Cache *raster.Cache
+
+	// SearchPath lists the directories searched by import statements after
+	// the directory of the importing script.
+	SearchPath []string
+	// ImportStack holds the absolute paths of the scripts being evaluated,
+	// innermost last. It is used to resolve relative imports and to detect
+	// import cycles.
+	ImportStack []string
+	// Imports holds the scripts parsed so far during a run, by absolute
+	// path. Like Bands, it is set up for each run through
+	// evaluator.BeginRun.
+	Imports map[string]*ast.Program
+}
@@ -0,0 +1,413 @@
+// Package optimizer rewrites programs into equivalent ones that are cheaper
+// to evaluate: arithmetic on number literals is folded, identities such as
+// x * 1 are removed where they don't change the type of x, and subexpressions repeated within a statement are
+// computed once into a let binding.
+package optimizer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"go_raster_eval/ast"
+	"go_raster_eval/token"
+)
+
+type optimizer struct {
+	names map[string]bool // identifiers already used by the program
+	next  int
+
+	// values holds the values let and out statements bind to each name,
+	// and opaque the names whose values can't be known before evaluation:
+	// function parameters and definitions.
+	values map[string][]ast.Expression
+	opaque map[string]bool
+	// imports is set if the program imports scripts, which may bind any
+	// name.
+	imports bool
+	// checking holds the names whose values are being checked by promoted.
+	checking map[string]bool
+}
+
+// Optimize rewrites program in place and returns it.
+func Optimize(program *ast.Program) *ast.Program {
+	o := &optimizer{names: map[string]bool{}, values: map[string][]ast.Expression{}, opaque: map[string]bool{}, checking: map[string]bool{}}
+	collectNames(program, o.names)
+	o.collectBindings(program)
+	program.Statements = o.statements(program.Statements)
+	return program
+}
+
+func (o *optimizer) statements(statements []ast.Statement) []ast.Statement {
+	out := []ast.Statement{}
+
+	for _, statement := range statements {
+		var root *ast.Expression
+
+		switch statement := statement.(type) {
+		case *ast.ExpressionStatement:
+			root = &statement.Expression
+		case *ast.LetStatement:
+			root = &statement.Value
+		case *ast.OutStatement:
+			root = &statement.Value
+		case *ast.ReturnStatement:
+			root = &statement.ReturnValue
+		case *ast.DefStatement:
+			// A def body is a single expression, so nothing can be hoisted
+			// out of it without turning it into a block.
+			for _, s := range statement.Function.Body.Statements {
+				if es, ok := s.(*ast.ExpressionStatement); ok {
+					es.Expression = o.fold(es.Expression)
+				}
+			}
+		}
+
+		if root != nil && *root != nil {
+			*root = o.fold(*root)
+			out = append(out, o.share([]*ast.Expression{root})...)
+		}
+		out = append(out, statement)
+	}
+
+	return out
+}
+
+// fold folds number literal arithmetic and removes identities bottom up.
+func (o *optimizer) fold(node ast.Expression) ast.Expression {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		node.Left = o.fold(node.Left)
+		node.Right = o.fold(node.Right)
+		return o.simplify(node)
+
+	case *ast.PrefixExpression:
+		node.Right = o.fold(node.Right)
+		if value, ok := number(node.Right); ok && node.Operator == "-" {
+			return numberLiteral(-value)
+		}
+
+	case *ast.CallExpression:
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = o.fold(arg)
+		}
+
+	case *ast.IndexExpression:
+		node.Index = o.fold(node.Index)
+
+	case *ast.FunctionLiteral:
+		node.Body.Statements = o.statements(node.Body.Statements)
+	}
+
+	return node
+}
+
+func (o *optimizer) simplify(node *ast.InfixExpression) ast.Expression {
+	left, leftOk := number(node.Left)
+	right, rightOk := number(node.Right)
+
+	if leftOk && rightOk {
+		switch node.Operator {
+		case "+":
+			return numberLiteral(left + right)
+		case "-":
+			return numberLiteral(left - right)
+		case "*":
+			return numberLiteral(left * right)
+		case "/":
+			// Dividing by zero is left for the evaluator to report.
+			if right != 0 {
+				return numberLiteral(left / right)
+			}
+		}
+		return node
+	}
+
+	if leftOk && !o.promoted(node.Right) || rightOk && !o.promoted(node.Left) {
+		return node
+	}
+
+	switch node.Operator {
+	case "+":
+		if rightOk && right == 0 {
+			return node.Left
+		}
+		if leftOk && left == 0 {
+			return node.Right
+		}
+	case "-":
+		if rightOk && right == 0 {
+			return node.Left
+		}
+	case "*":
+		if rightOk && right == 1 {
+			return node.Left
+		}
+		if leftOk && left == 1 {
+			return node.Right
+		}
+	case "/":
+		if rightOk && right == 1 {
+			return node.Left
+		}
+	}
+
+	return node
+}
+
+// promoted reports whether node is known to evaluate to a number or to a
+// raster arithmetic has already promoted to a float type. Identities only
+// hold for those: true + 0 is an error, not true, and B1 + 0 is a float
+// raster while B1 may be UINT16, so dropping the + 0 would change the type
+// of the result and what == means for it.
+func (o *optimizer) promoted(node ast.Expression) bool {
+	switch node := node.(type) {
+	case *ast.NumberLiteral:
+		return true
+
+	case *ast.PrefixExpression:
+		return node.Operator == "-"
+
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "+", "-", "*", "/":
+			return true
+		}
+
+	case *ast.Identifier:
+		// Names the program doesn't bind are bands, and imported scripts
+		// may bind any name.
+		name := node.Value
+		if o.imports || o.opaque[name] || o.checking[name] {
+			return false
+		}
+		values, ok := o.values[name]
+		if !ok {
+			return false
+		}
+		o.checking[name] = true
+		defer delete(o.checking, name)
+		for _, value := range values {
+			if !o.promoted(value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func number(node ast.Expression) (float32, bool) {
+	lit, ok := node.(*ast.NumberLiteral)
+	if !ok {
+		return 0, false
+	}
+	return lit.Value, true
+}
+
+func numberLiteral(value float32) *ast.NumberLiteral {
+	literal := strconv.FormatFloat(float64(value), 'f', -1, 32)
+	return &ast.NumberLiteral{Token: token.Token{Type: token.NUMBER, Literal: literal}, Value: value}
+}
+
+// share hoists the subexpressions occurring more than once under roots into
+// let statements, largest first, and returns the statements in the order
+// they have to run. Hoisted values are searched too, so subexpressions
+// shared between them are hoisted in turn.
+func (o *optimizer) share(roots []*ast.Expression) []ast.Statement {
+	lets := []ast.Statement{}
+
+	for {
+		counts := map[string]int{}
+		first := map[string]ast.Expression{}
+		for _, root := range roots {
+			countSubtrees(*root, counts, first)
+		}
+
+		repeated := []string{}
+		for key, n := range counts {
+			if n > 1 {
+				repeated = append(repeated, key)
+			}
+		}
+		if len(repeated) == 0 {
+			return lets
+		}
+		sort.Slice(repeated, func(i, j int) bool {
+			if len(repeated[i]) != len(repeated[j]) {
+				return len(repeated[i]) > len(repeated[j])
+			}
+			return repeated[i] < repeated[j]
+		})
+		key := repeated[0]
+
+		name := o.newName()
+		for _, root := range roots {
+			*root = replace(*root, key, name)
+		}
+
+		let := &ast.LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  name,
+			Value: first[key],
+		}
+		roots = append(roots, &let.Value)
+		// Values hoisted earlier are larger and may use this one, never the
+		// other way round.
+		lets = append([]ast.Statement{let}, lets...)
+	}
+}
+
+// countSubtrees counts the operations under node by their source form.
+// Function literals have their own scope and are not looked into.
+func countSubtrees(node ast.Expression, counts map[string]int, first map[string]ast.Expression) {
+	var children []ast.Expression
+
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		children = []ast.Expression{node.Left, node.Right}
+	case *ast.PrefixExpression:
+		children = []ast.Expression{node.Right}
+	case *ast.CallExpression:
+		children = node.Arguments
+	default:
+		return
+	}
+
+	key := node.String()
+	if _, ok := first[key]; !ok {
+		first[key] = node
+	}
+	counts[key]++
+
+	for _, child := range children {
+		countSubtrees(child, counts, first)
+	}
+}
+
+func replace(node ast.Expression, key string, name *ast.Identifier) ast.Expression {
+	switch node.(type) {
+	case *ast.InfixExpression, *ast.PrefixExpression, *ast.CallExpression:
+		if node.String() == key {
+			return name
+		}
+	}
+
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		node.Left = replace(node.Left, key, name)
+		node.Right = replace(node.Right, key, name)
+	case *ast.PrefixExpression:
+		node.Right = replace(node.Right, key, name)
+	case *ast.CallExpression:
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = replace(arg, key, name)
+		}
+	}
+
+	return node
+}
+
+func (o *optimizer) newName() *ast.Identifier {
+	for {
+		o.next++
+		name := fmt.Sprintf("_t%d", o.next)
+		if !o.names[name] {
+			o.names[name] = true
+			return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+		}
+	}
+}
+
+// collectBindings records the names node binds, and whether it imports
+// scripts.
+func (o *optimizer) collectBindings(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			o.collectBindings(s)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			o.collectBindings(s)
+		}
+	case *ast.ExpressionStatement:
+		o.collectBindings(node.Expression)
+	case *ast.LetStatement:
+		o.values[node.Name.Value] = append(o.values[node.Name.Value], node.Value)
+		o.collectBindings(node.Value)
+	case *ast.OutStatement:
+		o.values[node.Name.Value] = append(o.values[node.Name.Value], node.Value)
+		o.collectBindings(node.Value)
+	case *ast.ReturnStatement:
+		o.collectBindings(node.ReturnValue)
+	case *ast.DefStatement:
+		o.opaque[node.Name.Value] = true
+		o.collectBindings(node.Function)
+	case *ast.ImportStatement:
+		o.imports = true
+	case *ast.InfixExpression:
+		o.collectBindings(node.Left)
+		o.collectBindings(node.Right)
+	case *ast.PrefixExpression:
+		o.collectBindings(node.Right)
+	case *ast.CallExpression:
+		for _, arg := range node.Arguments {
+			o.collectBindings(arg)
+		}
+	case *ast.FunctionLiteral:
+		for _, param := range node.Parameters {
+			o.opaque[param.Value] = true
+		}
+		o.collectBindings(node.Body)
+	}
+}
+
+// collectNames records every identifier in node so generated names don't
+// shadow any of them.
+func collectNames(node ast.Node, names map[string]bool) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			collectNames(s, names)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			collectNames(s, names)
+		}
+	case *ast.ExpressionStatement:
+		collectNames(node.Expression, names)
+	case *ast.LetStatement:
+		names[node.Name.Value] = true
+		collectNames(node.Value, names)
+	case *ast.OutStatement:
+		names[node.Name.Value] = true
+		collectNames(node.Value, names)
+	case *ast.ReturnStatement:
+		collectNames(node.ReturnValue, names)
+	case *ast.DefStatement:
+		names[node.Name.Value] = true
+		collectNames(node.Function, names)
+	case *ast.Identifier:
+		names[node.Value] = true
+	case *ast.InfixExpression:
+		collectNames(node.Left, names)
+		collectNames(node.Right, names)
+	case *ast.PrefixExpression:
+		collectNames(node.Right, names)
+	case *ast.IndexExpression:
+		collectNames(node.Left, names)
+		collectNames(node.Index, names)
+	case *ast.CallExpression:
+		collectNames(node.Function, names)
+		for _, arg := range node.Arguments {
+			collectNames(arg, names)
+		}
+	case *ast.FunctionLiteral:
+		for _, param := range node.Parameters {
+			names[param.Value] = true
+		}
+		collectNames(node.Body, names)
+	}
+}
@@ -0,0 +1,63 @@
+package optimizer
+
+import (
+	"testing"
+
+	"go_raster_eval/lexer"
+	"go_raster_eval/parser"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"1 + 2 * 3;", "7"},
+		{"-(2 - 4);", "2"},
+		{"B1 / 0 + 0;", "(B1 / 0)"},
+		{"1 / 0;", "(1 / 0)"},
+		{"(B1 - B2) * 1;", "(B1 - B2)"},
+		{"0 + (B1 + B2) * 1;", "(B1 + B2)"},
+		{"0 + B1 * 1;", "(B1 * 1)"},
+		{"(B1 - 0) / 1;", "(B1 - 0)"},
+		{"let x = B1 - B2; x * 1;", "let x = (B1 - B2);x"},
+		{"let x = 2; x + 0;", "let x = 2;x"},
+
+		// Identities don't hold for values that aren't numbers or rasters.
+		{"(1 < 2) + 0;", "((1 < 2) + 0)"},
+		{"0 + (2 == 2);", "(0 + (2 == 2))"},
+		{"(1 < 2) * 1;", "((1 < 2) * 1)"},
+		{"\"a\" - 0;", "(\"a\" - 0)"},
+		{"let x = B1 > 2; x - 0;", "let x = (B1 > 2);(x - 0)"},
+		{"let x = 1 < 2; let y = x; y + 0;", "let x = (1 < 2);let y = x;(y + 0)"},
+		{"def f(a) = a + 0; f(B1);", "def f(a) = (a + 0);f(B1)"},
+		{"def ndvi() = 1 < 2; ndvi() + 0;", "def ndvi() = (1 < 2);(ndvi() + 0)"},
+		{"import \"lib\"; x * 1;", "import \"lib\";(x * 1)"},
+
+		// Nor for rasters of types arithmetic would promote.
+		{"B1 + 0;", "(B1 + 0)"},
+		{"B1[2] * 1;", "(B1[2] * 1)"},
+		{"qa(BQA, \"cloud\") + 0;", "(qa(BQA, \"cloud\") + 0)"},
+		{"ndvi() * 1;", "(ndvi() * 1)"},
+		{"(B1 # (B2 > 1)) - 0;", "((B1 # (B2 > 1)) - 0)"},
+		{"let x = B1; x / 1;", "let x = B1;(x / 1)"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: %v", tt.input, p.Errors())
+		}
+		if got := Optimize(program).String(); got != tt.want {
+			t.Errorf("Optimize(%s) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestShare(t *testing.T) {
+	p := parser.New(lexer.New("(B5 - B4) / (B5 + B4) + (B5 - B4);"))
+	got := Optimize(p.ParseProgram()).String()
+	if want := "let _t1 = (B5 - B4);((_t1 / (B5 + B4)) + _t1)"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Package qa decodes the pixel quality bands distributed with Landsat and
+// Sentinel-2 products into named flags, so expressions don't have to spell
+// out bit positions.
+package qa
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Field locates a flag inside a quality band. Bit packed bands use Offset
+// and Width; classification bands such as the Sentinel-2 SCL use Classes,
+// the set of pixel values that belong to the flag.
+type Field struct {
+	Offset  uint
+	Width   uint
+	Classes []uint16
+}
+
+// IsFlag reports whether the field decodes to a true/false value rather
+// than a multi-bit level such as a confidence.
+func (f Field) IsFlag() bool {
+	return len(f.Classes) > 0 || f.Width == 1
+}
+
+// Decode extracts the field from a quality pixel value.
+func (f Field) Decode(value uint16) uint16 {
+	if len(f.Classes) > 0 {
+		for _, c := range f.Classes {
+			if value == c {
+				return 1
+			}
+		}
+		return 0
+	}
+	return (value >> f.Offset) & (1<<f.Width - 1)
+}
+
+type Profile struct {
+	Name   string
+	Fields map[string]Field
+}
+
+func (p *Profile) Field(name string) (Field, error) {
+	f, ok := p.Fields[name]
+	if !ok {
+		return Field{}, fmt.Errorf("unknown QA field %q for profile %s", name, p.Name)
+	}
+	return f, nil
+}
+
+// FieldNames returns the names of the fields defined by the profile in
+// alphabetical order.
+func (p *Profile) FieldNames() []string {
+	names := make([]string, 0, len(p.Fields))
+	for name := range p.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultProfile is used when an expression doesn't name a profile.
+const DefaultProfile = "landsat8_c1"
+
+var profiles = map[string]*Profile{
+	// Landsat 4-7 Collection 1 BQA band
+	"landsat7_c1": {
+		Name: "landsat7_c1",
+		Fields: map[string]Field{
+			"fill":                    {Offset: 0, Width: 1},
+			"dropped_pixel":           {Offset: 1, Width: 1},
+			"saturation":              {Offset: 2, Width: 2},
+			"cloud":                   {Offset: 4, Width: 1},
+			"cloud_confidence":        {Offset: 5, Width: 2},
+			"cloud_shadow_confidence": {Offset: 7, Width: 2},
+			"snow_confidence":         {Offset: 9, Width: 2},
+		},
+	},
+	// Landsat 8 Collection 1 BQA band
+	"landsat8_c1": {
+		Name: "landsat8_c1",
+		Fields: map[string]Field{
+			"fill":                    {Offset: 0, Width: 1},
+			"terrain_occlusion":       {Offset: 1, Width: 1},
+			"saturation":              {Offset: 2, Width: 2},
+			"cloud":                   {Offset: 4, Width: 1},
+			"cloud_confidence":        {Offset: 5, Width: 2},
+			"cloud_shadow_confidence": {Offset: 7, Width: 2},
+			"snow_confidence":         {Offset: 9, Width: 2},
+			"cirrus_confidence":       {Offset: 11, Width: 2},
+		},
+	},
+	// Landsat 8 Collection 2 QA_PIXEL band
+	"landsat8_c2": {
+		Name: "landsat8_c2",
+		Fields: map[string]Field{
+			"fill":                    {Offset: 0, Width: 1},
+			"dilated_cloud":           {Offset: 1, Width: 1},
+			"cirrus":                  {Offset: 2, Width: 1},
+			"cloud":                   {Offset: 3, Width: 1},
+			"cloud_shadow":            {Offset: 4, Width: 1},
+			"snow":                    {Offset: 5, Width: 1},
+			"clear":                   {Offset: 6, Width: 1},
+			"water":                   {Offset: 7, Width: 1},
+			"cloud_confidence":        {Offset: 8, Width: 2},
+			"cloud_shadow_confidence": {Offset: 10, Width: 2},
+			"snow_confidence":         {Offset: 12, Width: 2},
+			"cirrus_confidence":       {Offset: 14, Width: 2},
+		},
+	},
+	// Sentinel-2 L2A scene classification layer
+	"sentinel2_scl": {
+		Name: "sentinel2_scl",
+		Fields: map[string]Field{
+			"fill":         {Classes: []uint16{0}},
+			"saturated":    {Classes: []uint16{1}},
+			"dark_area":    {Classes: []uint16{2}},
+			"cloud_shadow": {Classes: []uint16{3}},
+			"vegetation":   {Classes: []uint16{4}},
+			"bare_soil":    {Classes: []uint16{5}},
+			"water":        {Classes: []uint16{6}},
+			"unclassified": {Classes: []uint16{7}},
+			"cloud":        {Classes: []uint16{8, 9}},
+			"cloud_medium": {Classes: []uint16{8}},
+			"cloud_high":   {Classes: []uint16{9}},
+			"cirrus":       {Classes: []uint16{10}},
+			"snow":         {Classes: []uint16{11}},
+			"clear":        {Classes: []uint16{4, 5, 6}},
+		},
+	},
+}
+
+func GetProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown QA profile %q", name)
+	}
+	return p, nil
+}
+
+// Profiles returns the names of the available profiles in alphabetical
+// order.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,110 @@
+package qa
+
+import "testing"
+
+func decode(t *testing.T, profile, field string, value uint16) uint16 {
+	t.Helper()
+	p, err := GetProfile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := p.Field(field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Decode(value)
+}
+
+func TestLandsat8C1(t *testing.T) {
+	// A BQA value with the cloud bit, high cloud confidence, low cloud
+	// shadow confidence, low snow confidence and high cirrus confidence.
+	const value = 1<<4 | 3<<5 | 1<<7 | 1<<9 | 3<<11
+	for field, want := range map[string]uint16{
+		"fill":                    0,
+		"terrain_occlusion":       0,
+		"saturation":              0,
+		"cloud":                   1,
+		"cloud_confidence":        3,
+		"cloud_shadow_confidence": 1,
+		"snow_confidence":         1,
+		"cirrus_confidence":       3,
+	} {
+		if got := decode(t, "landsat8_c1", field, value); got != want {
+			t.Errorf("%s = %d, want %d", field, got, want)
+		}
+	}
+	if got := decode(t, "landsat8_c1", "fill", 1); got != 1 {
+		t.Errorf("fill = %d", got)
+	}
+	// 2720 is the value of clear land pixels in Collection 1 scenes.
+	if got := decode(t, "landsat8_c1", "cloud_confidence", 2720); got != 1 {
+		t.Errorf("cloud_confidence of 2720 = %d", got)
+	}
+}
+
+func TestLandsat8C2(t *testing.T) {
+	for _, c := range []struct {
+		value uint16
+		field string
+		want  uint16
+	}{
+		// 21824: clear with low confidences, the usual clear land value.
+		{21824, "clear", 1},
+		{21824, "cloud", 0},
+		{21824, "cloud_confidence", 1},
+		{21824, "cloud_shadow_confidence", 1},
+		{21824, "snow_confidence", 1},
+		{21824, "cirrus_confidence", 1},
+		// 22280: high confidence cloud, dilated.
+		{22280, "cloud", 1},
+		{22280, "dilated_cloud", 0},
+		{22280, "cloud_confidence", 3},
+		{22280, "clear", 0},
+		{1 << 1, "dilated_cloud", 1},
+		{1 << 2, "cirrus", 1},
+		{1 << 4, "cloud_shadow", 1},
+		{1 << 5, "snow", 1},
+		{1 << 7, "water", 1},
+		{1, "fill", 1},
+	} {
+		if got := decode(t, "landsat8_c2", c.field, c.value); got != c.want {
+			t.Errorf("%s of %d = %d, want %d", c.field, c.value, got, c.want)
+		}
+	}
+}
+
+func TestSentinel2SCL(t *testing.T) {
+	for _, c := range []struct {
+		field   string
+		classes []uint16
+	}{
+		{"fill", []uint16{0}},
+		{"cloud_shadow", []uint16{3}},
+		{"cloud", []uint16{8, 9}},
+		{"cirrus", []uint16{10}},
+		{"snow", []uint16{11}},
+		{"clear", []uint16{4, 5, 6}},
+	} {
+		for class := uint16(0); class < 12; class++ {
+			want := uint16(0)
+			for _, c := range c.classes {
+				if c == class {
+					want = 1
+				}
+			}
+			if got := decode(t, "sentinel2_scl", c.field, class); got != want {
+				t.Errorf("%s of class %d = %d, want %d", c.field, class, got, want)
+			}
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	p, _ := GetProfile("landsat8_c2")
+	if !p.Fields["cloud"].IsFlag() || p.Fields["cloud_confidence"].IsFlag() {
+		t.Error("cloud should be a flag and cloud_confidence a level")
+	}
+	if _, err := p.Field("nope"); err == nil {
+		t.Error("unknown field should fail")
+	}
+}
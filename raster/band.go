@@ -0,0 +1,69 @@
+package raster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The bands of multi-band files are identified by the name of the file
+// followed by the band within it: its number, from 1, in brackets, as in
+// scene[4], or its name after a dot, as in scene.nir. Sources match names
+// against the descriptions of the bands of the file.
+
+// IndexedBand returns the identifier of band number n of the file name.
+func IndexedBand(name string, n int) string {
+	return fmt.Sprintf("%s[%d]", name, n)
+}
+
+// NamedBand returns the identifier of the band called member of the file
+// name.
+func NamedBand(name, member string) string {
+	return name + "." + member
+}
+
+// SplitBand splits a band identifier into the name of its file and the
+// band within the file: its number, or its name. Identifiers selecting
+// neither, those of single band files, have number 0 and no name.
+func SplitBand(band string) (name string, number int, member string) {
+	if strings.HasSuffix(band, "]") {
+		if i := strings.LastIndex(band, "["); i > 0 {
+			if n, err := strconv.Atoi(band[i+1 : len(band)-1]); err == nil && n > 0 {
+				return band[:i], n, ""
+			}
+		}
+	}
+	if i := strings.LastIndex(band, "."); i > 0 && i < len(band)-1 {
+		return band[:i], 0, band[i+1:]
+	}
+	return band, 0, ""
+}
+
+// bandPath returns the path of a band read from the files named by
+// formatting pattern with the file name of the band: that of the file,
+// followed by the band within it if the identifier selects one.
+func bandPath(pattern, band string) string {
+	name, _, _ := SplitBand(band)
+	return fmt.Sprintf(pattern, name) + band[len(name):]
+}
+
+// bandFile returns the file holding a band read from the files named by
+// formatting pattern with the file name of the band.
+func bandFile(pattern, band string) string {
+	name, _, _ := SplitBand(band)
+	return fmt.Sprintf(pattern, name)
+}
+
+// findBand returns the number of the band of the file at path called
+// member, ignoring case. names holds the names of each band of the file,
+// such as its description and colour interpretation.
+func findBand(path, member string, names [][]string) (int, error) {
+	for i, bandNames := range names {
+		for _, name := range bandNames {
+			if strings.EqualFold(name, member) {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("No band called %q in granule %v", member, path)
+}
@@ -0,0 +1,72 @@
+//go:build !nogdal
+
+package raster
+
+// #include "gdal.h"
+// #include "ogr_srs_api.h"
+// #cgo LDFLAGS: -lgdal
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// edgePoints is the number of points sampled along each edge of a box when
+// transforming it, so boxes stay covered when edges curve in the target CRS.
+const edgePoints = 21
+
+// transformBBox returns the extent of bbox in the CRS given by wkt.
+func transformBBox(bbox BBox, wkt string) (float64, float64, float64, float64, error) {
+	if wkt == "" {
+		return 0, 0, 0, 0, fmt.Errorf("no projection to transform %v to", bbox.CRS)
+	}
+
+	crsCStr := C.CString(bbox.CRS)
+	defer C.free(unsafe.Pointer(crsCStr))
+	hSrcSRS := C.OSRNewSpatialReference(nil)
+	defer C.OSRDestroySpatialReference(hSrcSRS)
+	if C.OSRSetFromUserInput(hSrcSRS, crsCStr) != C.OGRERR_NONE {
+		return 0, 0, 0, 0, fmt.Errorf("unknown CRS %v", bbox.CRS)
+	}
+
+	wktCStr := C.CString(wkt)
+	defer C.free(unsafe.Pointer(wktCStr))
+	hDstSRS := C.OSRNewSpatialReference(wktCStr)
+	if hDstSRS == nil {
+		return 0, 0, 0, 0, fmt.Errorf("invalid projection %v", wkt)
+	}
+	defer C.OSRDestroySpatialReference(hDstSRS)
+
+	// Boxes are given as x/y, whatever axis order the CRS defines.
+	C.OSRSetAxisMappingStrategy(hSrcSRS, C.OAMS_TRADITIONAL_GIS_ORDER)
+	C.OSRSetAxisMappingStrategy(hDstSRS, C.OAMS_TRADITIONAL_GIS_ORDER)
+
+	hTransform := C.OCTNewCoordinateTransformation(hSrcSRS, hDstSRS)
+	if hTransform == nil {
+		return 0, 0, 0, 0, fmt.Errorf("cannot transform from %v", bbox.CRS)
+	}
+	defer C.OCTDestroyCoordinateTransformation(hTransform)
+
+	xs := make([]C.double, 0, 4*edgePoints)
+	ys := make([]C.double, 0, 4*edgePoints)
+	for i := 0; i < edgePoints; i++ {
+		t := float64(i) / float64(edgePoints-1)
+		x := bbox.MinX + t*(bbox.MaxX-bbox.MinX)
+		y := bbox.MinY + t*(bbox.MaxY-bbox.MinY)
+		xs = append(xs, C.double(x), C.double(x), C.double(bbox.MinX), C.double(bbox.MaxX))
+		ys = append(ys, C.double(bbox.MinY), C.double(bbox.MaxY), C.double(y), C.double(y))
+	}
+	if C.OCTTransform(hTransform, C.int(len(xs)), &xs[0], &ys[0], nil) == 0 {
+		return 0, 0, 0, 0, fmt.Errorf("cannot transform %v %v %v %v from %v", bbox.MinX, bbox.MinY, bbox.MaxX, bbox.MaxY, bbox.CRS)
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i := range xs {
+		minX, maxX = math.Min(minX, float64(xs[i])), math.Max(maxX, float64(xs[i]))
+		minY, maxY = math.Min(minY, float64(ys[i])), math.Max(maxY, float64(ys[i]))
+	}
+	return minX, minY, maxX, maxY, nil
+}
@@ -0,0 +1,11 @@
+//go:build nogdal
+
+package raster
+
+import "fmt"
+
+// transformBBox needs GDAL to transform between CRSs, so without it boxes
+// must be given in the CRS of the bands.
+func transformBBox(bbox BBox, projection string) (float64, float64, float64, float64, error) {
+	return 0, 0, 0, 0, fmt.Errorf("cannot transform %v boxes to %q without GDAL", bbox.CRS, projection)
+}
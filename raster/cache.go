@@ -0,0 +1,85 @@
+package raster
+
+import (
+	"container/list"
+	"sync"
+)
+
+// CacheKey identifies the result of a read: the file a band comes from,
+// the overview level it was read at and the window read. A zero Window
+// stands for the whole band. Reads resampled onto a common grid record the
+// grid and the resampling in Grid, and their window is one of that grid.
+type CacheKey struct {
+	Source   string
+	Overview int
+	Window   Window
+	Grid     string
+}
+
+type cacheEntry struct {
+	key    CacheKey
+	raster *FlexRaster
+	size   int64
+}
+
+// Cache keeps rasters that have been read, evicting the least recently used
+// ones once their data exceeds a memory limit. Cached rasters are shared:
+// their data must not be modified. A Cache is safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	limit   int64
+	size    int64
+	order   *list.List
+	entries map[CacheKey]*list.Element
+}
+
+// NewCache returns a cache holding up to limit bytes of pixel data. A limit
+// of zero or less never evicts.
+func NewCache(limit int64) *Cache {
+	return &Cache{limit: limit, order: list.New(), entries: map[CacheKey]*list.Element{}}
+}
+
+func (c *Cache) Get(key CacheKey) (*FlexRaster, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).raster, true
+}
+
+func (c *Cache) Put(key CacheKey, r *FlexRaster) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	entry := &cacheEntry{key: key, raster: r, size: r.DataSize()}
+	if c.limit > 0 && entry.size > c.limit {
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += entry.size
+
+	for c.limit > 0 && c.size > c.limit {
+		c.remove(c.order.Back())
+	}
+}
+
+// Size returns the bytes of pixel data held by the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
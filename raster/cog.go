@@ -0,0 +1,215 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"go_raster_eval/geotiff"
+)
+
+// COGOptions configure the Cloud Optimized GeoTIFFs written by COGWriter.
+type COGOptions struct {
+	// BlockSize is the side of the square tiles, a multiple of 16. Zero
+	// uses 512.
+	BlockSize int
+	// Compression is "none", "deflate" or "zstd". Empty uses deflate.
+	Compression string
+	// Predictor differences pixels before compressing them, which usually
+	// compresses smooth bands better.
+	Predictor bool
+}
+
+var compressions = map[string]int{
+	"":        geotiff.CompressionDeflate,
+	"none":    geotiff.CompressionNone,
+	"deflate": geotiff.CompressionDeflate,
+	"zstd":    geotiff.CompressionZstd,
+}
+
+// COGWriter writes a Cloud Optimized GeoTIFF in pure Go. Overviews are
+// generated from the evaluated raster and all the image directories must
+// precede the tiles, so the result is held in memory until Close writes
+// the file.
+type COGWriter struct {
+	file    *os.File
+	info    *Info
+	bands   []*FlexRaster
+	names   []string
+	options geotiff.COGOptions
+}
+
+// NewCOGWriter creates a single band COG on the grid described by info,
+// storing pixels in the type matching rasterType.
+func NewCOGWriter(path string, info *Info, rasterType RasterType, noData float64, options COGOptions) (*COGWriter, error) {
+	return NewCOGStackWriter(path, info, []string{""}, rasterType, noData, options)
+}
+
+// NewCOGStackWriter creates a COG with a band for each of names, described
+// by them, on the grid described by info, storing pixels in the type
+// matching rasterType.
+func NewCOGStackWriter(path string, info *Info, names []string, rasterType RasterType, noData float64, options COGOptions) (*COGWriter, error) {
+	compression, ok := compressions[options.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q, available: none, deflate, zstd", options.Compression)
+	}
+	blockSize := options.BlockSize
+	if blockSize == 0 {
+		blockSize = 512
+	}
+	if blockSize < 0 || blockSize%16 != 0 {
+		return nil, fmt.Errorf("invalid block size %d, must be a multiple of 16", blockSize)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bands := make([]*FlexRaster, len(names))
+	for i := range bands {
+		bands[i] = &FlexRaster{rasterType, info.Width, info.Height, NewData(rasterType, info.Width*info.Height), noData}
+	}
+	return &COGWriter{
+		file:    file,
+		info:    info,
+		bands:   bands,
+		names:   names,
+		options: geotiff.COGOptions{BlockSize: blockSize, Compression: compression, Predictor: options.Predictor},
+	}, nil
+}
+
+func (w *COGWriter) Write(window Window, r *FlexRaster) error {
+	return w.WriteBand(0, window, r)
+}
+
+func (w *COGWriter) WriteBand(band int, window Window, r *FlexRaster) error {
+	if r.Width != window.Width || r.Height != window.Height {
+		return fmt.Errorf("Raster of %d*%d does not fit window %v", r.Width, r.Height, window)
+	}
+
+	dst := w.bands[band]
+	buf := make([]float64, r.Width)
+	for y := 0; y < r.Height; y++ {
+		row := r.Float64s(y*r.Width, (y+1)*r.Width, buf)
+		dst.SetFloat64s((window.YOff+y)*dst.Width+window.XOff, row)
+	}
+	return nil
+}
+
+// Close generates the overviews and writes the file.
+func (w *COGWriter) Close() error {
+	err := w.write()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *COGWriter) write() error {
+	first := w.bands[0]
+	dataType, err := cogType(first.RasterType)
+	if err != nil {
+		return err
+	}
+
+	// Overviews halve the resolution until the coarsest fits in a tile.
+	var levels []geotiff.Raster
+	bands := w.bands
+	for {
+		level := geotiff.Raster{Width: bands[0].Width, Height: bands[0].Height, Samples: len(bands), DataType: dataType, Data: bands[0].Data}
+		if len(bands) > 1 {
+			data := make([]interface{}, len(bands))
+			for i, b := range bands {
+				data[i] = b.Data
+			}
+			level.Data = concatData(first.RasterType, data)
+		}
+		levels = append(levels, level)
+		if max(level.Width, level.Height) <= w.options.BlockSize {
+			break
+		}
+
+		coarser := make([]*FlexRaster, len(bands))
+		for i, b := range bands {
+			coarser[i] = Downsample(b)
+		}
+		bands = coarser
+	}
+
+	// Nodata values out of the range of the band, such as the default of
+	// integer results, can't be stored in it and are left out.
+	geo := geotiff.Georef{
+		GeoTransform: w.info.GeoTransform,
+		Projection:   w.info.Projection,
+		NoData:       first.NoData,
+		HasNoData:    fits(first.RasterType, first.NoData),
+		Descriptions: w.names,
+	}
+	return geotiff.WriteCOG(w.file, levels, geo, w.options)
+}
+
+func cogType(rasterType RasterType) (geotiff.DataType, error) {
+	switch rasterType {
+	case BOOL, UINT8:
+		return geotiff.Uint8, nil
+	case INT16:
+		return geotiff.Int16, nil
+	case UINT16:
+		return geotiff.Uint16, nil
+	case INT32:
+		return geotiff.Int32, nil
+	case UINT32:
+		return geotiff.Uint32, nil
+	case FLOAT32:
+		return geotiff.Float32, nil
+	case FLOAT64:
+		return geotiff.Float64, nil
+	}
+	return 0, fmt.Errorf("unsupported raster type %v", rasterType)
+}
+
+// Downsample returns r at half its resolution, rounded up. Each pixel
+// averages the valid pixels of the block of 2*2 it covers, and is nodata
+// where all of them are. Masks take the top left pixel of each block
+// instead, so they stay true or false.
+func Downsample(r *FlexRaster) *FlexRaster {
+	width, height := (r.Width+1)/2, (r.Height+1)/2
+	out := &FlexRaster{r.RasterType, width, height, NewData(r.RasterType, width*height), r.NoData}
+
+	integer := r.RasterType != FLOAT32 && r.RasterType != FLOAT64
+	topBuf, bottomBuf := make([]float64, r.Width), make([]float64, r.Width)
+	row := make([]float64, width)
+	for y := 0; y < height; y++ {
+		top := r.Float64s(2*y*r.Width, (2*y+1)*r.Width, topBuf)
+		bottom := top
+		if 2*y+1 < r.Height {
+			bottom = r.Float64s((2*y+1)*r.Width, (2*y+2)*r.Width, bottomBuf)
+		}
+
+		for x := range row {
+			if r.RasterType == BOOL {
+				row[x] = top[2*x]
+				continue
+			}
+
+			sum, n := 0.0, 0
+			for _, v := range [4]float64{top[2*x], top[min(2*x+1, r.Width-1)], bottom[2*x], bottom[min(2*x+1, r.Width-1)]} {
+				if v != r.NoData && !math.IsNaN(v) {
+					sum += v
+					n++
+				}
+			}
+			switch {
+			case n == 0:
+				row[x] = r.NoData
+			case integer:
+				row[x] = math.Round(sum / float64(n))
+			default:
+				row[x] = sum / float64(n)
+			}
+		}
+		out.SetFloat64s(y*width, row)
+	}
+	return out
+}
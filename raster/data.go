@@ -0,0 +1,280 @@
+package raster
+
+import "math"
+
+// The pixels of a FlexRaster are stored in the Go type matching its
+// RasterType, so that masks take a byte per pixel and integer bands keep
+// their exact values:
+//
+//	BOOL, UINT8  []uint8
+//	INT16        []int16
+//	UINT16       []uint16
+//	INT32        []int32
+//	UINT32       []uint32
+//	FLOAT32      []float32
+//	FLOAT64      []float64
+//
+// Kernels compute in floating point, converting the data a chunk of pixels
+// at a time rather than holding a float copy of whole rasters.
+
+type pixel interface {
+	~uint8 | ~int16 | ~uint16 | ~int32 | ~uint32 | ~float32 | ~float64
+}
+
+// NewData allocates zeroed storage for size pixels of rasterType.
+func NewData(rasterType RasterType, size int) interface{} {
+	switch rasterType {
+	case BOOL, UINT8:
+		return make([]uint8, size)
+	case INT16:
+		return make([]int16, size)
+	case UINT16:
+		return make([]uint16, size)
+	case INT32:
+		return make([]int32, size)
+	case UINT32:
+		return make([]uint32, size)
+	case FLOAT64:
+		return make([]float64, size)
+	default:
+		return make([]float32, size)
+	}
+}
+
+// widenings gives, for each pair of distinct types other than BOOL and
+// FLOAT64, the narrowest type holding the values of both. There is no
+// 64-bit integer type, so a UINT32 mixed with a signed type, or a 32-bit
+// integer with a FLOAT32, takes a FLOAT64.
+var widenings = map[[2]RasterType]RasterType{
+	{UINT8, INT16}:    INT16,
+	{UINT8, UINT16}:   UINT16,
+	{UINT8, INT32}:    INT32,
+	{UINT8, UINT32}:   UINT32,
+	{UINT8, FLOAT32}:  FLOAT32,
+	{INT16, UINT16}:   INT32,
+	{INT16, INT32}:    INT32,
+	{INT16, UINT32}:   FLOAT64,
+	{INT16, FLOAT32}:  FLOAT32,
+	{UINT16, INT32}:   INT32,
+	{UINT16, UINT32}:  UINT32,
+	{UINT16, FLOAT32}: FLOAT32,
+	{INT32, UINT32}:   FLOAT64,
+	{INT32, FLOAT32}:  FLOAT64,
+	{UINT32, FLOAT32}: FLOAT64,
+}
+
+// Widen returns the narrowest type holding every value of the given types,
+// such as the type of a stack of rasters of those types.
+func Widen(types ...RasterType) RasterType {
+	if len(types) == 0 {
+		return FLOAT32
+	}
+	wide := types[0]
+	for _, t := range types[1:] {
+		wide = widen(wide, t)
+	}
+	return wide
+}
+
+func widen(a, b RasterType) RasterType {
+	switch {
+	case a == b:
+		return a
+	case a == BOOL:
+		return b
+	case b == BOOL:
+		return a
+	case a == FLOAT64 || b == FLOAT64:
+		return FLOAT64
+	}
+	if t, ok := widenings[[2]RasterType{a, b}]; ok {
+		return t
+	}
+	if t, ok := widenings[[2]RasterType{b, a}]; ok {
+		return t
+	}
+	return FLOAT64
+}
+
+// Promote returns the type of the result of arithmetic between rasters of
+// the given types. Arithmetic is carried out in floating point, so that
+// differences of unsigned bands don't wrap and divisions keep their
+// fraction: results are FLOAT32 when float32 holds every value of the
+// operands exactly, FLOAT64 otherwise.
+func Promote(types ...RasterType) RasterType {
+	switch Widen(types...) {
+	case INT32, UINT32, FLOAT64:
+		return FLOAT64
+	default:
+		return FLOAT32
+	}
+}
+
+// Masked returns the type and nodata value of a raster of rasterType with
+// some of its pixels set to noData, as by the # filter. They are unchanged
+// when noData is a value of rasterType. Otherwise, as for integer bands
+// without a nodata value of their own, the result is promoted as for
+// arithmetic so that masked pixels don't wrap around to valid values.
+func Masked(rasterType RasterType, noData float64) (RasterType, float64) {
+	if fits(rasterType, noData) {
+		return rasterType, noData
+	}
+	rasterType = Promote(rasterType)
+	if rasterType == FLOAT32 {
+		noData = float64(float32(noData))
+	}
+	return rasterType, noData
+}
+
+// Len returns the number of pixels of r.
+func (r *FlexRaster) Len() int {
+	return r.Width * r.Height
+}
+
+// DataSize returns the bytes taken by the pixel data of r.
+func (r *FlexRaster) DataSize() int64 {
+	switch r.Data.(type) {
+	case []uint8:
+		return int64(r.Len())
+	case []int16, []uint16:
+		return int64(r.Len()) * 2
+	case []float64:
+		return int64(r.Len()) * 8
+	default:
+		return int64(r.Len()) * 4
+	}
+}
+
+// Stats summarises the valid pixels of a raster, those that are neither
+// nodata nor NaN.
+type Stats struct {
+	Valid          int
+	Min, Max, Mean float64
+}
+
+// Stats returns the statistics of the valid pixels of r.
+func (r *FlexRaster) Stats() Stats {
+	var stats Stats
+	var sum float64
+	buf := make([]float64, min(r.Len(), 4096))
+	for start := 0; start < r.Len(); start += len(buf) {
+		end := min(start+len(buf), r.Len())
+		for _, v := range r.Float64s(start, end, buf) {
+			if v == r.NoData || math.IsNaN(v) {
+				continue
+			}
+			if stats.Valid == 0 || v < stats.Min {
+				stats.Min = v
+			}
+			if stats.Valid == 0 || v > stats.Max {
+				stats.Max = v
+			}
+			sum += v
+			stats.Valid++
+		}
+	}
+	if stats.Valid > 0 {
+		stats.Mean = sum / float64(stats.Valid)
+	}
+	return stats
+}
+
+// Float32s returns pixels [start, end) of r as float32. FLOAT32 data is
+// returned without copying; other types are converted into buf, which is
+// allocated if shorter than end-start.
+func (r *FlexRaster) Float32s(start, end int, buf []float32) []float32 {
+	if data, ok := r.Data.([]float32); ok {
+		return data[start:end]
+	}
+	return floats(r.Data, start, end, buf)
+}
+
+// Float64s is Float32s for float64: FLOAT64 data is returned without
+// copying and other types are converted into buf.
+func (r *FlexRaster) Float64s(start, end int, buf []float64) []float64 {
+	if data, ok := r.Data.([]float64); ok {
+		return data[start:end]
+	}
+	return floats(r.Data, start, end, buf)
+}
+
+// SetFloat32s stores src at the pixels of r from start on, converting it to
+// the type of r. Values must be representable in that type.
+func (r *FlexRaster) SetFloat32s(start int, src []float32) {
+	setFloats(r.Data, start, src)
+}
+
+// SetFloat64s is SetFloat32s for float64 values.
+func (r *FlexRaster) SetFloat64s(start int, src []float64) {
+	setFloats(r.Data, start, src)
+}
+
+func floats[F float32 | float64](data interface{}, start, end int, buf []F) []F {
+	if len(buf) < end-start {
+		buf = make([]F, end-start)
+	}
+	buf = buf[:end-start]
+
+	switch data := data.(type) {
+	case []uint8:
+		convert(buf, data[start:end])
+	case []int16:
+		convert(buf, data[start:end])
+	case []uint16:
+		convert(buf, data[start:end])
+	case []int32:
+		convert(buf, data[start:end])
+	case []uint32:
+		convert(buf, data[start:end])
+	case []float32:
+		convert(buf, data[start:end])
+	case []float64:
+		convert(buf, data[start:end])
+	}
+	return buf
+}
+
+func setFloats[F float32 | float64](data interface{}, start int, src []F) {
+	switch data := data.(type) {
+	case []uint8:
+		convert(data[start:start+len(src)], src)
+	case []int16:
+		convert(data[start:start+len(src)], src)
+	case []uint16:
+		convert(data[start:start+len(src)], src)
+	case []int32:
+		convert(data[start:start+len(src)], src)
+	case []uint32:
+		convert(data[start:start+len(src)], src)
+	case []float32:
+		convert(data[start:start+len(src)], src)
+	case []float64:
+		convert(data[start:start+len(src)], src)
+	}
+}
+
+func convert[D, S pixel](dst []D, src []S) {
+	for i, v := range src {
+		dst[i] = D(v)
+	}
+}
+
+// fits reports whether v is a value of rasterType.
+func fits(rasterType RasterType, v float64) bool {
+	var lo, hi float64
+	switch rasterType {
+	case BOOL, UINT8:
+		lo, hi = 0, math.MaxUint8
+	case INT16:
+		lo, hi = math.MinInt16, math.MaxInt16
+	case UINT16:
+		lo, hi = 0, math.MaxUint16
+	case INT32:
+		lo, hi = math.MinInt32, math.MaxInt32
+	case UINT32:
+		lo, hi = 0, math.MaxUint32
+	default:
+		return true
+	}
+	return v >= lo && v <= hi && v == math.Trunc(v)
+}
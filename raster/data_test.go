@@ -0,0 +1,65 @@
+package raster
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	r := &FlexRaster{RasterType: FLOAT32, Width: 3, Height: 2, Data: []float32{1, -1, 4, float32(math.NaN()), 7, -1}, NoData: -1}
+	if got, want := r.Stats(), (Stats{Valid: 3, Min: 1, Max: 7, Mean: 4}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	empty := &FlexRaster{RasterType: UINT8, Width: 2, Height: 1, Data: []uint8{0, 0}, NoData: 0}
+	if got := empty.Stats(); got != (Stats{}) {
+		t.Errorf("Stats() of nodata = %+v, want none valid", got)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	for _, c := range []struct {
+		types         []RasterType
+		widen, result RasterType
+	}{
+		{[]RasterType{UINT16}, UINT16, FLOAT32},
+		{[]RasterType{BOOL, BOOL}, BOOL, FLOAT32},
+		{[]RasterType{BOOL, UINT8}, UINT8, FLOAT32},
+		{[]RasterType{UINT8, INT16}, INT16, FLOAT32},
+		{[]RasterType{UINT16, INT16}, INT32, FLOAT64},
+		{[]RasterType{UINT8, UINT16, UINT32}, UINT32, FLOAT64},
+		{[]RasterType{UINT32, INT16}, FLOAT64, FLOAT64},
+		{[]RasterType{INT32, FLOAT32}, FLOAT64, FLOAT64},
+		{[]RasterType{INT16, FLOAT32}, FLOAT32, FLOAT32},
+		{[]RasterType{FLOAT32, UINT8}, FLOAT32, FLOAT32},
+		{[]RasterType{FLOAT64, BOOL}, FLOAT64, FLOAT64},
+	} {
+		if got := Widen(c.types...); got != c.widen {
+			t.Errorf("Widen(%v) = %s, want %s", c.types, got, c.widen)
+		}
+		if got := Promote(c.types...); got != c.result {
+			t.Errorf("Promote(%v) = %s, want %s", c.types, got, c.result)
+		}
+	}
+}
+
+func TestMasked(t *testing.T) {
+	for _, c := range []struct {
+		rasterType RasterType
+		noData     float64
+		want       RasterType
+		wantNoData float64
+	}{
+		{UINT16, 0, UINT16, 0},
+		{UINT16, NoNoData, FLOAT32, float64(float32(NoNoData))},
+		{UINT8, 256, FLOAT32, 256},
+		{INT32, -1, INT32, -1},
+		{UINT32, -1, FLOAT64, -1},
+		{FLOAT32, NoNoData, FLOAT32, NoNoData},
+	} {
+		rasterType, noData := Masked(c.rasterType, c.noData)
+		if rasterType != c.want || noData != c.wantNoData {
+			t.Errorf("Masked(%s, %v) = %s, %v, want %s, %v", c.rasterType, c.noData, rasterType, noData, c.want, c.wantNoData)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build nogdal
+
+package raster
+
+// DefaultReader is the backend bands are read with unless configured
+// otherwise: without GDAL, the pure Go GeoTIFF reader.
+const DefaultReader = "geotiff"
+
+// DefaultSource is the source bands are read from when an evaluation
+// doesn't set one.
+var DefaultSource Source = &GeoTIFFSource{Pattern: DefaultPattern}
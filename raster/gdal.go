@@ -0,0 +1,318 @@
+//go:build !nogdal
+
+package raster
+
+// #include "gdal.h"
+// #include "cpl_string.h"
+// #cgo LDFLAGS: -lgdal
+// char**
+// get_open_options(int level)
+// {
+//	  char **papszOptions = NULL;
+//	  papszOptions = CSLSetNameValue(papszOptions, "OVERVIEW_LEVEL", CPLSPrintf("%d", level));
+//	  return papszOptions;
+// }
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var registerOnce sync.Once
+
+// DefaultReader is the backend bands are read with unless configured
+// otherwise: GDAL, unless built with the nogdal tag.
+const DefaultReader = "gdal"
+
+// DefaultSource is the source bands are read from when an evaluation
+// doesn't set one.
+var DefaultSource Source = &GDALSource{Pattern: DefaultPattern}
+
+func init() {
+	readers["gdal"] = func(pattern string) Source { return &GDALSource{Pattern: pattern} }
+}
+
+// GDALSource reads bands through GDAL, from the files named by formatting
+// Pattern with the file name of the band. Bands of multi-band files are
+// selected by number or by name, matching the description or colour
+// interpretation of the band; other identifiers read the first band. Files
+// stay open between reads until Close is called.
+type GDALSource struct {
+	Pattern string
+
+	datasets
+}
+
+func (s *GDALSource) Path(band string) string {
+	return bandPath(s.Pattern, band)
+}
+
+// datasets keeps the files read by a source open, so that a tiled
+// evaluation, which reads every band once per tile, opens each file once.
+// GDAL datasets can't be used from several goroutines at once, so reads
+// through them are serialised. The zero value is ready to use.
+type datasets struct {
+	mu   sync.Mutex
+	open map[datasetKey]C.GDALDatasetH
+}
+
+type datasetKey struct {
+	path     string
+	overview int
+}
+
+// dataset returns the file at path opened at an overview, opening it on
+// first use. d.mu must be held.
+func (d *datasets) dataset(path string, overview int) (C.GDALDatasetH, error) {
+	key := datasetKey{path, overview}
+	if hDS, ok := d.open[key]; ok {
+		return hDS, nil
+	}
+	hDS, err := openDataset(path, overview)
+	if err != nil {
+		return nil, err
+	}
+	if d.open == nil {
+		d.open = map[datasetKey]C.GDALDatasetH{}
+	}
+	d.open[key] = hDS
+	return hDS, nil
+}
+
+// Close closes the files opened by the source. It can be read from again
+// afterwards, reopening them.
+func (d *datasets) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, hDS := range d.open {
+		C.GDALClose(hDS)
+		delete(d.open, key)
+	}
+	return nil
+}
+
+// openDataset opens a file at an overview: 0 is the full resolution and n
+// the nth overview, which GDAL numbers from 0.
+func openDataset(path string, overview int) (C.GDALDatasetH, error) {
+	registerOnce.Do(func() { C.GDALAllRegister() })
+
+	filePathCStr := C.CString(path)
+	defer C.free(unsafe.Pointer(filePathCStr))
+
+	var opt **C.char
+	if overview > 0 {
+		opt = C.get_open_options(C.int(overview - 1))
+		defer C.CSLDestroy(opt)
+	}
+	hSrcDS := C.GDALOpenEx(filePathCStr, C.GA_ReadOnly, nil, opt, nil)
+	if hSrcDS == nil {
+		return nil, fmt.Errorf("GDAL Dataset is null %v", path)
+	}
+	return hSrcDS, nil
+}
+
+// Describe returns the size and georeferencing of a band at an overview
+// without reading its pixels.
+func (s *GDALSource) Describe(band string, overview int) (*Info, error) {
+	return s.describe(bandFile(s.Pattern, band), overview)
+}
+
+// Overviews returns the number of overviews of a band.
+func (s *GDALSource) Overviews(band string) (int, error) {
+	_, number, member := SplitBand(band)
+	return s.overviews(bandFile(s.Pattern, band), number, member)
+}
+
+// Read reads a band at an overview, 0 being the full resolution. A nil
+// window reads the whole band, otherwise only the part of the window that
+// overlaps the band is read.
+func (s *GDALSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	_, number, member := SplitBand(band)
+	r, _, err := s.read(bandFile(s.Pattern, band), number, member, overview, window)
+	return r, err
+}
+
+func (d *datasets) describe(path string, overview int) (*Info, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hSrcDS, err := d.dataset(path, overview)
+	if err != nil {
+		return nil, err
+	}
+
+	hBand := C.GDALGetRasterBand(hSrcDS, 1)
+	if hBand == nil {
+		return nil, fmt.Errorf("Null Band returned for granule %v", path)
+	}
+
+	info := &Info{
+		Width:      int(C.GDALGetRasterBandXSize(hBand)),
+		Height:     int(C.GDALGetRasterBandYSize(hBand)),
+		Projection: C.GoString(C.GDALGetProjectionRef(hSrcDS)),
+	}
+	C.GDALGetGeoTransform(hSrcDS, (*C.double)(unsafe.Pointer(&info.GeoTransform[0])))
+
+	return info, nil
+}
+
+func (d *datasets) overviews(path string, number int, member string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hSrcDS, err := d.dataset(path, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	hBand, err := datasetBand(hSrcDS, path, number, member)
+	if err != nil {
+		return 0, err
+	}
+	return int(C.GDALGetOverviewCount(hBand)), nil
+}
+
+// datasetBand returns the band of a dataset with the given number, from 1,
+// or else the band called member, or else the first band.
+func datasetBand(hSrcDS C.GDALDatasetH, path string, number int, member string) (C.GDALRasterBandH, error) {
+	count := int(C.GDALGetRasterCount(hSrcDS))
+	if member != "" {
+		names := make([][]string, count)
+		for i := range names {
+			hBand := C.GDALGetRasterBand(hSrcDS, C.int(i+1))
+			names[i] = []string{
+				C.GoString(C.GDALGetDescription(C.GDALMajorObjectH(hBand))),
+				C.GoString(C.GDALGetColorInterpretationName(C.GDALGetRasterColorInterpretation(hBand))),
+			}
+		}
+		n, err := findBand(path, member, names)
+		if err != nil {
+			return nil, err
+		}
+		number = n
+	}
+	if number == 0 {
+		number = 1
+	}
+
+	if number > count {
+		return nil, fmt.Errorf("No band %d in granule %v", number, path)
+	}
+	hBand := C.GDALGetRasterBand(hSrcDS, C.int(number))
+	if hBand == nil {
+		return nil, fmt.Errorf("Null Band returned for granule %v", path)
+	}
+	return hBand, nil
+}
+
+// read reads band number, from 1, or else the band called member, of the
+// dataset at path, along with the scaling of its values.
+func (d *datasets) read(path string, number int, member string, overview int, window *Window) (*FlexRaster, scaling, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Overview datasets don't carry the names of their bands.
+	if member != "" && overview > 0 {
+		hFullDS, err := d.dataset(path, 0)
+		if err != nil {
+			return nil, scaling{}, err
+		}
+		hBand, err := datasetBand(hFullDS, path, 0, member)
+		if err != nil {
+			return nil, scaling{}, err
+		}
+		number, member = int(C.GDALGetBandNumber(hBand)), ""
+	}
+
+	hSrcDS, err := d.dataset(path, overview)
+	if err != nil {
+		return nil, scaling{}, err
+	}
+
+	hBand, err := datasetBand(hSrcDS, path, number, member)
+	if err != nil {
+		return nil, scaling{}, err
+	}
+
+	win := Window{0, 0, int(C.GDALGetRasterBandXSize(hBand)), int(C.GDALGetRasterBandYSize(hBand))}
+	if window != nil {
+		win = window.Intersect(win)
+		if win.Empty() {
+			return nil, scaling{}, fmt.Errorf("Window %v outside of granule %v", *window, path)
+		}
+	}
+
+	rasterType, dataType := nativeType(C.GDALGetRasterDataType(hBand))
+	nodata := float64(C.GDALGetRasterNoDataValue(hBand, nil))
+	data := NewData(rasterType, win.Width*win.Height)
+	cErr := C.GDALRasterIO(hBand, C.GF_Read, C.int(win.XOff), C.int(win.YOff), C.int(win.Width), C.int(win.Height), dataPointer(data), C.int(win.Width), C.int(win.Height), dataType, 0, 0)
+	if cErr != C.CE_None {
+		return nil, scaling{}, fmt.Errorf("Error reading window %v of granule %v", win, path)
+	}
+
+	sc := scaling{float64(C.GDALGetRasterScale(hBand, nil)), float64(C.GDALGetRasterOffset(hBand, nil))}
+	return &FlexRaster{rasterType, win.Width, win.Height, data, nodata}, sc, nil
+}
+
+// nativeType maps a GDAL data type to the raster type bands of that type
+// are read as, and the GDAL type of the matching storage. Types without a
+// raster type of their own are read as FLOAT32.
+func nativeType(dataType C.GDALDataType) (RasterType, C.GDALDataType) {
+	switch dataType {
+	case C.GDT_Byte:
+		return UINT8, C.GDT_Byte
+	case C.GDT_Int16:
+		return INT16, C.GDT_Int16
+	case C.GDT_UInt16:
+		return UINT16, C.GDT_UInt16
+	case C.GDT_Int32:
+		return INT32, C.GDT_Int32
+	case C.GDT_UInt32:
+		return UINT32, C.GDT_UInt32
+	case C.GDT_Float64:
+		return FLOAT64, C.GDT_Float64
+	default:
+		return FLOAT32, C.GDT_Float32
+	}
+}
+
+// gdalType returns the GDAL type of the storage of rasterType.
+func gdalType(rasterType RasterType) C.GDALDataType {
+	switch rasterType {
+	case BOOL, UINT8:
+		return C.GDT_Byte
+	case INT16:
+		return C.GDT_Int16
+	case UINT16:
+		return C.GDT_UInt16
+	case INT32:
+		return C.GDT_Int32
+	case UINT32:
+		return C.GDT_UInt32
+	case FLOAT64:
+		return C.GDT_Float64
+	default:
+		return C.GDT_Float32
+	}
+}
+
+// dataPointer returns the address of the first pixel of data.
+func dataPointer(data interface{}) unsafe.Pointer {
+	switch data := data.(type) {
+	case []uint8:
+		return unsafe.Pointer(&data[0])
+	case []int16:
+		return unsafe.Pointer(&data[0])
+	case []uint16:
+		return unsafe.Pointer(&data[0])
+	case []int32:
+		return unsafe.Pointer(&data[0])
+	case []uint32:
+		return unsafe.Pointer(&data[0])
+	case []float32:
+		return unsafe.Pointer(&data[0])
+	case []float64:
+		return unsafe.Pointer(&data[0])
+	}
+	panic(fmt.Sprintf("unsupported raster data %T", data))
+}
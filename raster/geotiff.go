@@ -0,0 +1,197 @@
+package raster
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"go_raster_eval/geotiff"
+)
+
+// NoNoData is the nodata value of bands that don't define one, the value
+// GDAL reports for them. Writers leave it out of integer outputs, which
+// can't hold it.
+const NoNoData = -1e10
+
+// GeoTIFFSource reads bands from GeoTIFF files in pure Go, from the files
+// named by formatting Pattern with the file name of the band. Bands of
+// multi-band files are selected by number or by name, matching the
+// description GDAL gave the band or, in RGB files, its colour; other
+// identifiers read the first band. Files are opened and their directories
+// parsed once; they stay open between reads until Close is called.
+type GeoTIFFSource struct {
+	Pattern string
+
+	mu    sync.Mutex
+	files map[string]*openTIFF
+}
+
+// openTIFF is a file opened by a GeoTIFFSource and its parsed directories.
+type openTIFF struct {
+	file *os.File
+	tiff *geotiff.File
+}
+
+func (s *GeoTIFFSource) Path(band string) string {
+	return bandPath(s.Pattern, band)
+}
+
+// file returns the parsed file of a band, opening it on first use.
+func (s *GeoTIFFSource) file(band string) (*geotiff.File, error) {
+	path := bandFile(s.Pattern, band)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[path]; ok {
+		return f.tiff, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tiff, err := geotiff.Open(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if s.files == nil {
+		s.files = map[string]*openTIFF{}
+	}
+	s.files[path] = &openTIFF{file, tiff}
+	return tiff, nil
+}
+
+// Close closes the files opened by the source. It can be read from again
+// afterwards, reopening them.
+func (s *GeoTIFFSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for path, f := range s.files {
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+		delete(s.files, path)
+	}
+	return err
+}
+
+func (s *GeoTIFFSource) image(band string, overview int) (*geotiff.Image, int, error) {
+	f, err := s.file(band)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tiffImage(f, bandFile(s.Pattern, band), band, overview)
+}
+
+func (s *GeoTIFFSource) Describe(band string, overview int) (*Info, error) {
+	img, _, err := s.image(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{img.Width, img.Height, img.GeoTransform, img.Projection}, nil
+}
+
+func (s *GeoTIFFSource) Overviews(band string) (int, error) {
+	f, err := s.file(band)
+	if err != nil {
+		return 0, err
+	}
+	if _, _, err := tiffImage(f, bandFile(s.Pattern, band), band, 0); err != nil {
+		return 0, err
+	}
+	return len(f.Images) - 1, nil
+}
+
+func (s *GeoTIFFSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	img, sample, err := s.image(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	return readImage(img, sample, s.Path(band), window)
+}
+
+// rgbNames are the names of the samples of RGB images.
+var rgbNames = []string{"red", "green", "blue"}
+
+// tiffImage returns the image of the file at path at an overview, and the
+// sample of the image holding a band. Only the full resolution image
+// carries the descriptions of the samples.
+func tiffImage(tiff *geotiff.File, path, band string, overview int) (*geotiff.Image, int, error) {
+	if overview < 0 || overview >= len(tiff.Images) {
+		return nil, 0, fmt.Errorf("%v: no overview %d, file has %d", path, overview, len(tiff.Images)-1)
+	}
+
+	full := tiff.Images[0]
+	_, number, member := SplitBand(band)
+	if member != "" {
+		names := make([][]string, full.Samples)
+		for i := range names {
+			names[i] = []string{full.Descriptions[i]}
+			if full.Photometric == geotiff.PhotometricRGB && i < len(rgbNames) {
+				names[i] = append(names[i], rgbNames[i])
+			}
+		}
+		n, err := findBand(path, member, names)
+		if err != nil {
+			return nil, 0, err
+		}
+		number = n
+	}
+	if number == 0 {
+		number = 1
+	}
+	if number > full.Samples {
+		return nil, 0, fmt.Errorf("No band %d in granule %v", number, path)
+	}
+
+	return tiff.Images[overview], number - 1, nil
+}
+
+// readImage reads a sample of img through window, converting it to a
+// FlexRaster.
+func readImage(img *geotiff.Image, sample int, path string, window *Window) (*FlexRaster, error) {
+	win := Window{0, 0, img.Width, img.Height}
+	if window != nil {
+		win = window.Intersect(win)
+		if win.Empty() {
+			return nil, fmt.Errorf("Window %v outside of granule %v", *window, path)
+		}
+	}
+
+	rasterType, err := geotiffType(img.DataType)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	data, err := img.Read(sample, win.XOff, win.YOff, win.Width, win.Height)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	noData := float64(NoNoData)
+	if img.HasNoData {
+		noData = img.NoData
+	}
+	return &FlexRaster{rasterType, win.Width, win.Height, data, noData}, nil
+}
+
+func geotiffType(dataType geotiff.DataType) (RasterType, error) {
+	switch dataType {
+	case geotiff.Uint8:
+		return UINT8, nil
+	case geotiff.Int16:
+		return INT16, nil
+	case geotiff.Uint16:
+		return UINT16, nil
+	case geotiff.Int32:
+		return INT32, nil
+	case geotiff.Uint32:
+		return UINT32, nil
+	case geotiff.Float32:
+		return FLOAT32, nil
+	case geotiff.Float64:
+		return FLOAT64, nil
+	}
+	return "", fmt.Errorf("unsupported data type %v", dataType)
+}
@@ -0,0 +1,44 @@
+package raster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoTIFFSourceKeepsFilesOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestCOG(t, dir)
+	s := &GeoTIFFSource{Pattern: filepath.Join(dir, "%s.tif")}
+
+	want, err := s.Read("B1", 0, &Window{XOff: 10, YOff: 20, Width: 30, Height: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Later reads use the file opened by the first one and its parsed
+	// directories, so they still succeed once its name is gone.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Describe("B1", 0); err != nil {
+		t.Errorf("Describe of an open file: %v", err)
+	}
+	got, err := s.Read("B1", 0, &Window{XOff: 10, YOff: 20, Width: 30, Height: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, w := got.Float64s(0, got.Len(), nil), want.Float64s(0, want.Len(), nil)
+	for i := range w {
+		if g[i] != w[i] {
+			t.Fatalf("second read differs at %d: %v, want %v", i, g[i], w[i])
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Describe("B1", 0); err == nil {
+		t.Error("Describe after Close reopened a removed file")
+	}
+}
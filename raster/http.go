@@ -0,0 +1,293 @@
+package raster
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go_raster_eval/geotiff"
+)
+
+const (
+	// httpBlockSize is the granularity of HTTP range requests. The first
+	// block of a file usually holds all its image directories.
+	httpBlockSize = 16 << 10
+	// httpCacheSize bounds the bytes of each file kept between reads.
+	httpCacheSize = 64 << 20
+)
+
+// HTTPSource reads bands from Cloud Optimized GeoTIFFs served over HTTP, at
+// the URLs formed by formatting Pattern with the file name of the band,
+// selecting bands of multi-band files as GeoTIFFSource does. The header of
+// each file is fetched once; reads then fetch only the tiles of the
+// overview that intersect the window, using range requests.
+type HTTPSource struct {
+	Pattern string
+	// Client makes the requests. Nil uses http.DefaultClient.
+	Client *http.Client
+
+	mu    sync.Mutex
+	files map[string]*geotiff.File
+}
+
+func (s *HTTPSource) Path(band string) string {
+	return bandPath(s.Pattern, band)
+}
+
+// file returns the parsed header of the file of a band.
+func (s *HTTPSource) file(band string) (*geotiff.File, error) {
+	url := bandFile(s.Pattern, band)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[url]; ok {
+		return f, nil
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	f, err := geotiff.Open(newHTTPReader(client, url))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", url, err)
+	}
+	if s.files == nil {
+		s.files = map[string]*geotiff.File{}
+	}
+	s.files[url] = f
+	return f, nil
+}
+
+func (s *HTTPSource) image(band string, overview int) (*geotiff.Image, int, error) {
+	f, err := s.file(band)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tiffImage(f, bandFile(s.Pattern, band), band, overview)
+}
+
+func (s *HTTPSource) Describe(band string, overview int) (*Info, error) {
+	img, _, err := s.image(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{img.Width, img.Height, img.GeoTransform, img.Projection}, nil
+}
+
+func (s *HTTPSource) Overviews(band string) (int, error) {
+	f, err := s.file(band)
+	if err != nil {
+		return 0, err
+	}
+	if _, _, err := tiffImage(f, bandFile(s.Pattern, band), band, 0); err != nil {
+		return 0, err
+	}
+	return len(f.Images) - 1, nil
+}
+
+func (s *HTTPSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	img, sample, err := s.image(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	return readImage(img, sample, s.Path(band), window)
+}
+
+// httpReader reads a file over HTTP range requests, in aligned blocks of
+// httpBlockSize. Blocks are kept in a bounded LRU cache so the many small
+// reads of a TIFF header, and tiles shared by neighbouring windows, are
+// fetched once.
+type httpReader struct {
+	client *http.Client
+	url    string
+
+	mu     sync.Mutex
+	size   int64 // -1 until a response tells the size of the file
+	blocks map[int64]*list.Element
+	lru    *list.List
+	cached int64
+}
+
+type httpBlock struct {
+	index int64
+	data  []byte
+}
+
+func newHTTPReader(client *http.Client, url string) *httpReader {
+	return &httpReader{client: client, url: url, size: -1, blocks: map[int64]*list.Element{}, lru: list.New()}
+}
+
+func (r *httpReader) ReadAt(p []byte, off int64) (int, error) {
+	blocks, err := r.load([]geotiff.Range{{Offset: off, Length: int64(len(p))}})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(p) {
+		at := off + int64(n)
+		data := blocks[at/httpBlockSize]
+		start := int(at % httpBlockSize)
+		if start >= len(data) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], data[start:])
+	}
+	return n, nil
+}
+
+// Size returns the size of the file, -1 until a response has told it.
+func (r *httpReader) Size() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+// Prefetch fetches the blocks of ranges that aren't cached.
+func (r *httpReader) Prefetch(ranges []geotiff.Range) error {
+	_, err := r.load(ranges)
+	return err
+}
+
+// load returns the blocks covering ranges, up to the end of the file.
+// Blocks that aren't cached are fetched concurrently, each run of
+// consecutive ones in a single request.
+func (r *httpReader) load(ranges []geotiff.Range) (map[int64][]byte, error) {
+	blocks := map[int64][]byte{}
+	var missing []int64
+
+	r.mu.Lock()
+	for _, rg := range ranges {
+		end := rg.Offset + rg.Length
+		if r.size >= 0 {
+			end = min(end, r.size)
+		}
+		for b := rg.Offset / httpBlockSize; b*httpBlockSize < end; b++ {
+			if _, ok := blocks[b]; ok {
+				continue
+			}
+			if e, ok := r.blocks[b]; ok {
+				r.lru.MoveToFront(e)
+				blocks[b] = e.Value.(*httpBlock).data
+				continue
+			}
+			blocks[b] = nil
+			missing = append(missing, b)
+		}
+	}
+	r.mu.Unlock()
+	if len(missing) == 0 {
+		return blocks, nil
+	}
+
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	var runs [][2]int64
+	for _, b := range missing {
+		if n := len(runs); n > 0 && runs[n-1][1] == b {
+			runs[n-1][1] = b + 1
+		} else {
+			runs = append(runs, [2]int64{b, b + 1})
+		}
+	}
+
+	fetched := make([][]byte, len(runs))
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		wg.Add(1)
+		go func(i int, first, last int64) {
+			defer wg.Done()
+			fetched[i], errs[i] = r.fetch(first, last)
+		}(i, run[0], run[1])
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, run := range runs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		data := fetched[i]
+		for b := run[0]; b < run[1] && len(data) > 0; b++ {
+			n := min(len(data), httpBlockSize)
+			blocks[b] = data[:n]
+			r.put(b, data[:n])
+			data = data[n:]
+		}
+	}
+	return blocks, nil
+}
+
+// fetch requests blocks [first, last).
+func (r *httpReader) fetch(first, last int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first*httpBlockSize, last*httpBlockSize-1))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return nil, fmt.Errorf("read past the end of the file")
+	case http.StatusOK:
+		return nil, fmt.Errorf("server does not support range requests")
+	default:
+		return nil, fmt.Errorf("%v", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if size, ok := contentSize(resp.Header.Get("Content-Range")); ok {
+		r.mu.Lock()
+		r.size = size
+		r.mu.Unlock()
+	}
+	return data, nil
+}
+
+// put caches a block, evicting the least recently used ones beyond
+// httpCacheSize.
+func (r *httpReader) put(index int64, data []byte) {
+	if e, ok := r.blocks[index]; ok {
+		r.lru.MoveToFront(e)
+		return
+	}
+	r.blocks[index] = r.lru.PushFront(&httpBlock{index, data})
+	r.cached += int64(len(data))
+
+	for r.cached > httpCacheSize && r.lru.Len() > 1 {
+		e := r.lru.Back()
+		b := e.Value.(*httpBlock)
+		r.lru.Remove(e)
+		delete(r.blocks, b.index)
+		r.cached -= int64(len(b.data))
+	}
+}
+
+// contentSize returns the size of the file from a Content-Range header such
+// as "bytes 0-1023/146515".
+func contentSize(header string) (int64, bool) {
+	i := strings.LastIndex(header, "/")
+	if i < 0 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(header[i+1:], 10, 64)
+	return size, err == nil
+}
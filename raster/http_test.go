@@ -0,0 +1,173 @@
+package raster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"go_raster_eval/geotiff"
+)
+
+// writeTestCOG writes an uncompressed 512x512 UINT16 COG of 256 pixel
+// tiles, each tile spanning several HTTP blocks, and returns its path.
+func writeTestCOG(t *testing.T, dir string) string {
+	t.Helper()
+	width, height := 512, 512
+	data := make([]uint16, width*height)
+	for i := range data {
+		data[i] = uint16(i % 65521)
+	}
+	path := filepath.Join(dir, "B1.tif")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	level := geotiff.Raster{Width: width, Height: height, DataType: geotiff.Uint16, Data: data}
+	geo := geotiff.Georef{GeoTransform: [6]float64{0, 10, 0, 5120, 0, -10}, Projection: "EPSG:32633"}
+	if err := geotiff.WriteCOG(f, []geotiff.Raster{level}, geo, geotiff.COGOptions{BlockSize: 256, Compression: geotiff.CompressionNone}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rangeLog records the Range headers of the requests it serves.
+type rangeLog struct {
+	mu     sync.Mutex
+	ranges [][2]int64
+}
+
+func (l *rangeLog) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var first, last int64
+		if _, err := fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &first, &last); err == nil {
+			l.mu.Lock()
+			l.ranges = append(l.ranges, [2]int64{first, last + 1})
+			l.mu.Unlock()
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (l *rangeLog) take() [][2]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ranges := l.ranges
+	l.ranges = nil
+	return ranges
+}
+
+func TestHTTPSource(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestCOG(t, dir)
+	log := &rangeLog{}
+	server := httptest.NewServer(log.wrap(http.FileServer(http.Dir(dir))))
+	defer server.Close()
+
+	src := &HTTPSource{Pattern: server.URL + "/%s.tif"}
+	local := &GeoTIFFSource{Pattern: filepath.Join(dir, "%s.tif")}
+
+	info, err := src.Describe("B1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := local.Describe("B1", 0); *info != *want {
+		t.Errorf("Describe = %+v, want %+v", *info, *want)
+	}
+	for _, rg := range log.take() {
+		if rg[0] != 0 {
+			t.Errorf("opening the file requested bytes %d-%d, beyond its header", rg[0], rg[1])
+		}
+	}
+
+	// The window lies within the second tile of the first row.
+	window := &Window{XOff: 300, YOff: 20, Width: 50, Height: 40}
+	got, err := src.Read("B1", 0, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := local.Read("B1", 0, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RasterType != want.RasterType || got.Width != want.Width || got.Height != want.Height || got.NoData != want.NoData {
+		t.Fatalf("Read = %s %dx%d nodata %v, want %s %dx%d nodata %v", got.RasterType, got.Width, got.Height, got.NoData, want.RasterType, want.Width, want.Height, want.NoData)
+	}
+	g, w := got.Float64s(0, got.Len(), nil), want.Float64s(0, want.Len(), nil)
+	for i := range w {
+		if g[i] != w[i] {
+			t.Fatalf("pixel %d = %v, want %v", i, g[i], w[i])
+		}
+	}
+
+	// Only the blocks of that tile are fetched.
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tiff, err := geotiff.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := tiff.Images[0]
+	start := int64(img.Offsets[1]) / httpBlockSize * httpBlockSize
+	end := int64(img.Offsets[1]+img.ByteCounts[1]+httpBlockSize-1) / httpBlockSize * httpBlockSize
+	ranges := log.take()
+	if len(ranges) == 0 {
+		t.Error("reading the window made no requests")
+	}
+	for _, rg := range ranges {
+		if rg[0] < start || rg[1] > end {
+			t.Errorf("reading the window requested bytes %d-%d, outside its tile at %d-%d", rg[0], rg[1], start, end)
+		}
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestCOG(t, dir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A server ignoring Range headers answers with the whole file.
+	whole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(data)
+	}))
+	defer whole.Close()
+	_, err = (&HTTPSource{Pattern: whole.URL + "/%s.tif"}).Describe("B1", 0)
+	if err == nil || !strings.Contains(err.Error(), "does not support range requests") {
+		t.Errorf("Describe with a server ignoring ranges: %v", err)
+	}
+
+	files := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer files.Close()
+	_, err = (&HTTPSource{Pattern: files.URL + "/%s.tif"}).Describe("B2", 0)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Describe of a missing file: %v", err)
+	}
+
+	// A fresh reader doesn't know the size of the file, so it asks for
+	// blocks past its end.
+	r := newHTTPReader(http.DefaultClient, files.URL+"/B1.tif")
+	_, err = r.ReadAt(make([]byte, 8), int64(len(data))+4*httpBlockSize)
+	if err == nil || !strings.Contains(err.Error(), "past the end of the file") {
+		t.Errorf("ReadAt past the end: %v", err)
+	}
+	if r.Size() != -1 {
+		t.Errorf("Size after a failed read = %d, want -1", r.Size())
+	}
+	if _, err := r.ReadAt(make([]byte, 8), 0); err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", r.Size(), len(data))
+	}
+}
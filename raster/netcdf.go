@@ -0,0 +1,66 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// scaling is the linear transform from the stored values of a band to the
+// values they represent.
+type scaling struct {
+	scale, offset float64
+}
+
+// netCDFVariable splits the band identifier of a NetCDF source, a variable
+// name optionally followed by a colon and the index of the time or level to
+// read, counted from 0, or by its band number in brackets, counted from 1.
+func netCDFVariable(band string) (string, int, error) {
+	if name, number, member := SplitBand(band); member != "" {
+		return "", 0, fmt.Errorf("NetCDF band %q is selected by name, times and levels are selected by number", band)
+	} else if number > 0 {
+		return name, number - 1, nil
+	}
+
+	i := strings.LastIndex(band, ":")
+	if i < 0 {
+		return band, 0, nil
+	}
+	index, err := strconv.Atoi(band[i+1:])
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("invalid index in NetCDF band %q", band)
+	}
+	return band[:i], index, nil
+}
+
+// unpack applies the scale and offset of packed data, such as the
+// scale_factor and add_offset attributes of NetCDF variables. Pixels equal
+// to the nodata value of r, its _FillValue, stay nodata.
+func unpack(r *FlexRaster, sc scaling) *FlexRaster {
+	if sc.scale == 1 && sc.offset == 0 {
+		return r
+	}
+
+	rasterType := Promote(r.RasterType)
+	noData := r.NoData*sc.scale + sc.offset
+	if rasterType == FLOAT32 {
+		noData = float64(float32(noData))
+	}
+	out := &FlexRaster{rasterType, r.Width, r.Height, NewData(rasterType, r.Len()), noData}
+
+	buf := make([]float64, min(r.Len(), 4096))
+	for start := 0; start < r.Len(); start += len(buf) {
+		end := min(start+len(buf), r.Len())
+		values := r.Float64s(start, end, buf)
+		for i, v := range values {
+			if v == r.NoData || math.IsNaN(v) {
+				values[i] = noData
+			} else {
+				values[i] = v*sc.scale + sc.offset
+			}
+		}
+		out.SetFloat64s(start, values)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+//go:build !nogdal
+
+package raster
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	readers["netcdf"] = func(pattern string) Source { return &NetCDFSource{Pattern: pattern} }
+}
+
+// NetCDFSource reads bands from variables of NetCDF files through the GDAL
+// netCDF driver, which handles both the classic and the HDF5 based
+// formats. Bands are identified by variable name, optionally followed by a
+// colon and the index of the time or level to read, such as "sst:3", or by
+// its band number as in sst[4]; sensor profiles map expression identifiers
+// onto them. Values are unpacked with the scale_factor and add_offset of
+// the variable, and its _FillValue is the nodata value. Files stay open
+// between reads until Close is called.
+type NetCDFSource struct {
+	// Pattern names the file. If it contains a %s, it is formatted with
+	// the variable name, for collections storing a variable per file.
+	Pattern string
+
+	datasets
+}
+
+// subdataset returns the GDAL name of the variable of a band, and its
+// index.
+func (s *NetCDFSource) subdataset(band string) (string, int, error) {
+	variable, index, err := netCDFVariable(band)
+	if err != nil {
+		return "", 0, err
+	}
+	path := s.Pattern
+	if strings.Contains(path, "%s") {
+		path = fmt.Sprintf(path, variable)
+	}
+	return fmt.Sprintf("NETCDF:\"%s\":%s", path, variable), index, nil
+}
+
+// Path returns the GDAL name of the variable, followed by the band number
+// in brackets when reading a time or level other than the first.
+func (s *NetCDFSource) Path(band string) string {
+	name, index, err := s.subdataset(band)
+	if err != nil {
+		return band
+	}
+	if index > 0 {
+		return IndexedBand(name, index+1)
+	}
+	return name
+}
+
+func (s *NetCDFSource) Describe(band string, overview int) (*Info, error) {
+	name, _, err := s.subdataset(band)
+	if err != nil {
+		return nil, err
+	}
+	return s.describe(name, overview)
+}
+
+func (s *NetCDFSource) Overviews(band string) (int, error) {
+	name, index, err := s.subdataset(band)
+	if err != nil {
+		return 0, err
+	}
+	return s.overviews(name, index+1, "")
+}
+
+func (s *NetCDFSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	name, index, err := s.subdataset(band)
+	if err != nil {
+		return nil, err
+	}
+	r, sc, err := s.read(name, index+1, "", overview, window)
+	if err != nil {
+		return nil, err
+	}
+	return unpack(r, sc), nil
+}
@@ -0,0 +1,290 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+)
+
+// Resampling is a method of computing the pixels of one grid from those of
+// another.
+type Resampling string
+
+const (
+	// Nearest takes the pixel under the centre of the new pixel.
+	Nearest = Resampling("nearest")
+	// Bilinear interpolates between the 2*2 nearest pixels.
+	Bilinear = Resampling("bilinear")
+	// Cubic interpolates between the 4*4 nearest pixels with a Catmull-Rom
+	// spline.
+	Cubic = Resampling("cubic")
+	// Average takes the mean of the pixels whose centres fall within the
+	// new pixel, and the nearest pixel where none does.
+	Average = Resampling("average")
+)
+
+// ParseResampling returns the resampling method named.
+func ParseResampling(name string) (Resampling, error) {
+	switch r := Resampling(name); r {
+	case Nearest, Bilinear, Cubic, Average:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown resampling %q, available: nearest, bilinear, cubic, average", name)
+}
+
+// Alignment configures reading bands onto a common grid, so that bands of
+// different resolutions or extents can be combined.
+type Alignment struct {
+	// Grid selects the common grid: "finest" or "coarsest" for the grid of
+	// the band among Bands with the smallest or largest pixels, or the name
+	// of the band whose grid is used.
+	Grid string
+	// PixelSize, when set, replaces the pixel size of the grid selected,
+	// keeping its extent.
+	PixelSize float64
+	// Resampling computes the pixels of bands not on the grid, nearest
+	// neighbour if empty.
+	Resampling Resampling
+	// Bands lists the bands the finest or coarsest grid is chosen from. If
+	// it is empty, the first band read is used.
+	Bands []string
+}
+
+// resampleMargin is the number of pixels read around the part of a band
+// under a grid, so interpolation at the edges sees its neighbours.
+const resampleMargin = 2
+
+// ReadOnto reads the part of a band at an overview under grid, resampled
+// onto it. grid must be north up and in the CRS of the band. Pixels of the
+// grid outside the band are nodata. A band already on the grid is read as
+// it is.
+func ReadOnto(src Source, band string, overview int, grid *Info, method Resampling) (*FlexRaster, error) {
+	info, err := src.Describe(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	if info.Projection != "" && grid.Projection != "" && !SameCRS(info.Projection, grid.Projection) {
+		return nil, fmt.Errorf("band %v is not in the CRS of the grid it is aligned to", band)
+	}
+	gt, dgt := info.GeoTransform, grid.GeoTransform
+	if gt[1] == 0 || gt[5] == 0 || gt[2] != 0 || gt[4] != 0 || dgt[2] != 0 || dgt[4] != 0 {
+		return nil, fmt.Errorf("band %v: cannot align grids with geotransforms %v and %v", band, gt, dgt)
+	}
+	extent := Window{0, 0, info.Width, info.Height}
+
+	// Bands sharing the pixels of the grid need no resampling.
+	xOff, yOff := (dgt[0]-gt[0])/gt[1], (dgt[3]-gt[3])/gt[5]
+	if dgt[1] == gt[1] && dgt[5] == gt[5] && isWhole(xOff) && isWhole(yOff) {
+		w := Window{int(math.Round(xOff)), int(math.Round(yOff)), grid.Width, grid.Height}
+		if w.Intersect(extent) == w {
+			return src.Read(band, overview, &w)
+		}
+	}
+
+	x0, x1 := (dgt[0]-gt[0])/gt[1], (dgt[0]+float64(grid.Width)*dgt[1]-gt[0])/gt[1]
+	y0, y1 := (dgt[3]-gt[3])/gt[5], (dgt[3]+float64(grid.Height)*dgt[5]-gt[3])/gt[5]
+	xMin, yMin := int(math.Floor(math.Min(x0, x1)))-resampleMargin, int(math.Floor(math.Min(y0, y1)))-resampleMargin
+	xMax, yMax := int(math.Ceil(math.Max(x0, x1)))+resampleMargin, int(math.Ceil(math.Max(y0, y1)))+resampleMargin
+	w := Window{xMin, yMin, xMax - xMin, yMax - yMin}.Intersect(extent)
+	if w.Empty() {
+		// The grid misses the band, which is still read for its type and
+		// nodata value.
+		w = Window{0, 0, 1, 1}
+	}
+
+	r, err := src.Read(band, overview, &w)
+	if err != nil {
+		return nil, err
+	}
+	return Resample(r, info.Subset(w), grid, method)
+}
+
+// isWhole reports whether v is an integer, give or take rounding errors.
+func isWhole(v float64) bool {
+	return math.Abs(v-math.Round(v)) < 1e-6
+}
+
+// Resample computes the pixels of grid from r, whose pixels lie on from.
+// Both grids must be north up and in the same CRS. Pixels of the grid
+// outside r, or whose nearest pixel in r is nodata, are nodata, and the
+// interpolating methods only weigh the pixels of r that aren't nodata.
+// The result has the type of r, integer values being rounded and clamped
+// to the range of the type.
+func Resample(r *FlexRaster, from, grid *Info, method Resampling) (*FlexRaster, error) {
+	sgt, dgt := from.GeoTransform, grid.GeoTransform
+	if sgt[1] == 0 || sgt[5] == 0 || sgt[2] != 0 || sgt[4] != 0 || dgt[2] != 0 || dgt[4] != 0 {
+		return nil, fmt.Errorf("cannot resample between geotransforms %v and %v", sgt, dgt)
+	}
+	if r.Width != from.Width || r.Height != from.Height {
+		return nil, fmt.Errorf("raster of %d*%d pixels on a grid of %d*%d", r.Width, r.Height, from.Width, from.Height)
+	}
+	var kernel func(s *resampler, u, v float64) float64
+	switch method {
+	case Nearest:
+		kernel = (*resampler).nearest
+	case Bilinear:
+		kernel = (*resampler).bilinear
+	case Cubic:
+		kernel = (*resampler).cubic
+	case Average:
+	default:
+		return nil, fmt.Errorf("unknown resampling %q", method)
+	}
+
+	s := &resampler{values: r.Float64s(0, r.Len(), nil), width: r.Width, height: r.Height, noData: r.NoData}
+	out := &FlexRaster{r.RasterType, grid.Width, grid.Height, NewData(r.RasterType, grid.Width*grid.Height), r.NoData}
+
+	// Pixel centres and edges of the grid in pixel coordinates of r.
+	col := func(x float64) float64 { return (dgt[0] + x*dgt[1] - sgt[0]) / sgt[1] }
+	row := func(y float64) float64 { return (dgt[3] + y*dgt[5] - sgt[3]) / sgt[5] }
+
+	clamp := clamper(r.RasterType)
+	buf := make([]float64, grid.Width)
+	for y := 0; y < grid.Height; y++ {
+		v := row(float64(y) + 0.5)
+		for x := range buf {
+			u := col(float64(x) + 0.5)
+			switch {
+			case !s.valid(int(math.Floor(u)), int(math.Floor(v))):
+				buf[x] = r.NoData
+			case kernel != nil:
+				buf[x] = kernel(s, u, v)
+			default:
+				buf[x] = s.average(col(float64(x)), col(float64(x+1)), row(float64(y)), row(float64(y+1)), u, v)
+			}
+			if buf[x] != r.NoData {
+				buf[x] = clamp(buf[x])
+			}
+		}
+		out.SetFloat64s(y*grid.Width, buf)
+	}
+	return out, nil
+}
+
+// clamper returns a function rounding and clamping values to the range of
+// rasterType, or leaving them as they are for floating point types.
+func clamper(rasterType RasterType) func(float64) float64 {
+	var lo, hi float64
+	switch rasterType {
+	case BOOL:
+		lo, hi = 0, 1
+	case UINT8:
+		lo, hi = 0, math.MaxUint8
+	case INT16:
+		lo, hi = math.MinInt16, math.MaxInt16
+	case UINT16:
+		lo, hi = 0, math.MaxUint16
+	case INT32:
+		lo, hi = math.MinInt32, math.MaxInt32
+	case UINT32:
+		lo, hi = 0, math.MaxUint32
+	default:
+		return func(v float64) float64 { return v }
+	}
+	return func(v float64) float64 { return math.Max(lo, math.Min(hi, math.Round(v))) }
+}
+
+// resampler holds the pixels resampled from.
+type resampler struct {
+	values        []float64
+	width, height int
+	noData        float64
+}
+
+// valid reports whether pixel x, y is within the raster and not nodata.
+func (s *resampler) valid(x, y int) bool {
+	if x < 0 || y < 0 || x >= s.width || y >= s.height {
+		return false
+	}
+	v := s.values[y*s.width+x]
+	return v != s.noData && !math.IsNaN(v)
+}
+
+func (s *resampler) nearest(u, v float64) float64 {
+	return s.values[int(math.Floor(v))*s.width+int(math.Floor(u))]
+}
+
+func (s *resampler) bilinear(u, v float64) float64 {
+	sum, total := s.interpolate(u, v, 0, func(t float64) float64 { return 1 - math.Abs(t) })
+	if total <= 0 {
+		return s.nearest(u, v)
+	}
+	return sum / total
+}
+
+// cubicMinWeight is the least total weight of the valid pixels a cubic
+// interpolation is computed from, half that of the whole kernel. Nodata
+// can leave the negative lobes of the kernel outweighing the rest of it,
+// so that dividing by the total amplifies the values or flips their sign;
+// such pixels are interpolated bilinearly instead.
+const cubicMinWeight = 0.5
+
+func (s *resampler) cubic(u, v float64) float64 {
+	sum, total := s.interpolate(u, v, 1, catmullRom)
+	if total <= cubicMinWeight {
+		return s.bilinear(u, v)
+	}
+	return sum / total
+}
+
+// interpolate weighs the pixels around u, v, from reach pixels before the
+// one left of and above it to reach pixels after the one right of and below
+// it, by weight of their distance along either axis. Pixels beyond the
+// edges repeat the edge. Nodata pixels are left out: it returns the
+// weighted sum of the others and their total weight, which the caller
+// divides by to make up for them.
+func (s *resampler) interpolate(u, v float64, reach int, weight func(float64) float64) (sum, total float64) {
+	// Pixel centres are at half pixels.
+	u, v = u-0.5, v-0.5
+	x0, y0 := int(math.Floor(u)), int(math.Floor(v))
+	for y := y0 - reach; y <= y0+1+reach; y++ {
+		wy := weight(v - float64(y))
+		sy := max(0, min(s.height-1, y))
+		for x := x0 - reach; x <= x0+1+reach; x++ {
+			w := wy * weight(u-float64(x))
+			sx := max(0, min(s.width-1, x))
+			if w == 0 || !s.valid(sx, sy) {
+				continue
+			}
+			sum += w * s.values[sy*s.width+sx]
+			total += w
+		}
+	}
+	return sum, total
+}
+
+// catmullRom is the cubic convolution kernel with a = -0.5.
+func catmullRom(t float64) float64 {
+	t = math.Abs(t)
+	switch {
+	case t < 1:
+		return (1.5*t-2.5)*t*t + 1
+	case t < 2:
+		return ((-0.5*t+2.5)*t-4)*t + 2
+	}
+	return 0
+}
+
+// average returns the mean of the valid pixels whose centres lie between
+// columns u0 and u1 and rows v0 and v1, or the pixel at u, v if there are
+// none.
+func (s *resampler) average(u0, u1, v0, v1, u, v float64) float64 {
+	if u0 > u1 {
+		u0, u1 = u1, u0
+	}
+	if v0 > v1 {
+		v0, v1 = v1, v0
+	}
+	sum, n := 0.0, 0
+	for y := int(math.Ceil(v0 - 0.5)); float64(y)+0.5 < v1; y++ {
+		for x := int(math.Ceil(u0 - 0.5)); float64(x)+0.5 < u1; x++ {
+			if s.valid(x, y) {
+				sum += s.values[y*s.width+x]
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return s.nearest(u, v)
+	}
+	return sum / float64(n)
+}
@@ -0,0 +1,113 @@
+package raster
+
+import (
+	"math"
+	"testing"
+)
+
+// resampleTest resamples the width*height FLOAT32 pixels of values, on a
+// grid of 10 unit pixels at the origin, onto a grid of pixels of size at
+// the same origin covering the same extent.
+func resampleTest(t *testing.T, width, height int, values []float32, size float64, method Resampling) []float64 {
+	t.Helper()
+	r := &FlexRaster{RasterType: FLOAT32, Width: width, Height: height, Data: values, NoData: -1}
+	from := &Info{Width: width, Height: height, GeoTransform: [6]float64{0, 10, 0, 0, 0, -10}}
+	grid := &Info{
+		Width:        int(float64(width) * 10 / size),
+		Height:       int(float64(height) * 10 / size),
+		GeoTransform: [6]float64{0, size, 0, 0, 0, -size},
+	}
+	out, err := Resample(r, from, grid, method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Float64s(0, out.Len(), nil)
+}
+
+func equalPixels(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-4 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResampleNearest(t *testing.T) {
+	got := resampleTest(t, 2, 2, []float32{1, 2, 3, -1}, 5, Nearest)
+	want := []float64{
+		1, 1, 2, 2,
+		1, 1, 2, 2,
+		3, 3, -1, -1,
+		3, 3, -1, -1,
+	}
+	if !equalPixels(got, want) {
+		t.Errorf("nearest = %v, want %v", got, want)
+	}
+}
+
+func TestResampleBilinear(t *testing.T) {
+	// Pixels beyond the edges repeat the edge.
+	got := resampleTest(t, 2, 1, []float32{0, 10}, 5, Bilinear)
+	if want := []float64{0, 2.5, 7.5, 10}; !equalPixels(got[:4], want) {
+		t.Errorf("bilinear = %v, want %v", got[:4], want)
+	}
+
+	// Nodata pixels are left out.
+	got = resampleTest(t, 3, 1, []float32{0, 10, -1}, 5, Bilinear)
+	if want := []float64{0, 2.5, 7.5, 10, -1, -1}; !equalPixels(got[:6], want) {
+		t.Errorf("bilinear with nodata = %v, want %v", got[:6], want)
+	}
+}
+
+func TestResampleCubic(t *testing.T) {
+	// Catmull-Rom interpolation reproduces a ramp away from the edges.
+	ramp := make([]float32, 8)
+	for i := range ramp {
+		ramp[i] = float32(i * 10)
+	}
+	got := resampleTest(t, 8, 1, ramp, 5, Cubic)
+	for x := 4; x < 12; x++ {
+		if want := float64(x)*5 - 2.5; math.Abs(got[x]-want) > 1e-4 {
+			t.Errorf("cubic pixel %d = %v, want %v", x, got[x], want)
+		}
+	}
+}
+
+// TestResampleCubicNoData interpolates a pixel whose valid neighbours
+// all lie under the negative lobes of the cubic kernel, which would
+// overshoot the values they are interpolated from.
+func TestResampleCubicNoData(t *testing.T) {
+	values := make([]float64, 16)
+	for i := range values {
+		values[i] = -1
+	}
+	values[2*4+2] = 100
+	for _, i := range []int{2*4 + 0, 2*4 + 3, 0*4 + 2, 3*4 + 2} {
+		values[i] = -100
+	}
+	s := &resampler{values: values, width: 4, height: 4, noData: -1}
+	if sum, total := s.interpolate(2, 2, 1, catmullRom); sum/total <= 100 {
+		t.Fatalf("cubic weights of the test pixels don't overshoot: %v", sum/total)
+	}
+	if got := s.cubic(2, 2); got != 100 {
+		t.Errorf("cubic = %v, want the bilinear 100", got)
+	}
+}
+
+func TestResampleAverage(t *testing.T) {
+	values := []float32{
+		1, 3, 5, -1,
+		5, 7, -1, 5,
+		0, 0, 2, 2,
+		0, 4, 2, 2,
+	}
+	got := resampleTest(t, 4, 4, values, 20, Average)
+	// Nodata pixels are left out of the second block.
+	if want := []float64{4, 5, 1, 2}; !equalPixels(got, want) {
+		t.Errorf("average = %v, want %v", got, want)
+	}
+}
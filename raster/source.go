@@ -0,0 +1,141 @@
+package raster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultPattern names the files of the Landsat 8 test scene bands are read
+// from unless a source is configured.
+const DefaultPattern = "/g/data3/fr5/prl900/LS8_test/LC81390452014295LGN00_%s.TIF"
+
+// Source reads the bands of a scene. Overviews are numbered from 1, 0
+// being the full resolution.
+type Source interface {
+	// Path identifies a band: the file holding it, followed by the band
+	// within the file for multi-band files.
+	Path(band string) string
+	// Describe returns the size and georeferencing of a band at an
+	// overview without reading its pixels.
+	Describe(band string, overview int) (*Info, error)
+	// Overviews returns the number of overviews of a band.
+	Overviews(band string) (int, error)
+	// Read reads a band at an overview. A nil window reads the whole band,
+	// otherwise only the part of the window that overlaps the band.
+	Read(band string, overview int, window *Window) (*FlexRaster, error)
+}
+
+// readers maps the names of the available backends to constructors taking
+// the pattern of the band file names.
+var readers = map[string]func(pattern string) Source{
+	"geotiff": func(pattern string) Source { return &GeoTIFFSource{Pattern: pattern} },
+	"http":    func(pattern string) Source { return &HTTPSource{Pattern: pattern} },
+	"zarr":    func(pattern string) Source { return &ZarrSource{Pattern: pattern} },
+}
+
+// NewSource returns a source reading bands with the named backend from the
+// files named by formatting pattern with the band name.
+func NewSource(reader, pattern string) (Source, error) {
+	newSource, ok := readers[reader]
+	if !ok {
+		return nil, fmt.Errorf("unknown reader %q, available: %v", reader, Readers())
+	}
+	return newSource(pattern), nil
+}
+
+// Readers returns the names of the available backends.
+func Readers() []string {
+	names := []string{}
+	for name := range readers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCacheKey returns the key under which a read of band from src at an
+// overview through window is cached.
+func NewCacheKey(src Source, band string, overview int, window *Window) CacheKey {
+	key := CacheKey{Source: src.Path(band), Overview: overview}
+	if window != nil {
+		key.Window = *window
+	}
+	return key
+}
+
+// BestOverview returns the coarsest overview of a band that is still at
+// least size pixels on its longer side, or 0 for the full resolution if
+// none is.
+func BestOverview(src Source, band string, size int) (int, error) {
+	n, err := src.Overviews(band)
+	if err != nil {
+		return 0, err
+	}
+
+	best, bestSize := 0, 0
+	for i := 0; i <= n; i++ {
+		info, err := src.Describe(band, i)
+		if err != nil {
+			return 0, err
+		}
+		ovSize := max(info.Width, info.Height)
+		if i == 0 || ovSize >= size && ovSize < bestSize {
+			best, bestSize = i, ovSize
+		}
+	}
+
+	return best, nil
+}
+
+// BBoxWindow returns the pixels of a band at an overview covering bbox,
+// clipped to the band.
+func BBoxWindow(src Source, band string, overview int, bbox BBox) (Window, error) {
+	info, err := src.Describe(band, overview)
+	if err != nil {
+		return Window{}, err
+	}
+	w, err := info.BBoxWindow(bbox)
+	if err != nil {
+		return Window{}, fmt.Errorf("band %v: %v", band, err)
+	}
+	return w, nil
+}
+
+type bboxKey struct {
+	path     string
+	overview int
+	bbox     BBox
+}
+
+// Regions memoizes BBoxWindow for an evaluation, which asks for the same
+// window every time it reads a band. A Regions is safe for concurrent use.
+type Regions struct {
+	mu      sync.Mutex
+	windows map[bboxKey]Window
+}
+
+func NewRegions() *Regions {
+	return &Regions{windows: map[bboxKey]Window{}}
+}
+
+// BBoxWindow is the package BBoxWindow, answered from memory for the bands
+// it has already been asked about.
+func (r *Regions) BBoxWindow(src Source, band string, overview int, bbox BBox) (Window, error) {
+	key := bboxKey{src.Path(band), overview, bbox}
+	r.mu.Lock()
+	w, ok := r.windows[key]
+	r.mu.Unlock()
+	if ok {
+		return w, nil
+	}
+
+	w, err := BBoxWindow(src, band, overview, bbox)
+	if err != nil {
+		return Window{}, err
+	}
+	r.mu.Lock()
+	r.windows[key] = w
+	r.mu.Unlock()
+	return w, nil
+}
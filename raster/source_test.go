@@ -0,0 +1,54 @@
+package raster
+
+import "testing"
+
+// gridSource describes every band with the same grid, counting the calls.
+type gridSource struct {
+	info      Info
+	describes int
+}
+
+func (s *gridSource) Path(band string) string { return band }
+
+func (s *gridSource) Describe(band string, overview int) (*Info, error) {
+	s.describes++
+	info := s.info
+	return &info, nil
+}
+
+func (s *gridSource) Overviews(band string) (int, error) { return 0, nil }
+
+func (s *gridSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	return nil, nil
+}
+
+func TestRegions(t *testing.T) {
+	src := &gridSource{info: Info{Width: 100, Height: 100, GeoTransform: [6]float64{0, 10, 0, 1000, 0, -10}}}
+	bbox := BBox{MinX: 100, MinY: 500, MaxX: 300, MaxY: 800}
+	want := Window{XOff: 10, YOff: 20, Width: 20, Height: 30}
+
+	regions := NewRegions()
+	for i := 0; i < 3; i++ {
+		w, err := regions.BBoxWindow(src, "B1", 0, bbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != want {
+			t.Errorf("BBoxWindow = %v, want %v", w, want)
+		}
+	}
+	if src.describes != 1 {
+		t.Errorf("%d describes, want 1", src.describes)
+	}
+
+	// The band changes between evaluations: a new evaluation must not see
+	// the windows of the last one.
+	src.info.GeoTransform[0] = -100
+	w, err := NewRegions().BBoxWindow(src, "B1", 0, bbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Window{XOff: 20, YOff: 20, Width: 20, Height: 30}); w != want {
+		t.Errorf("BBoxWindow after change = %v, want %v", w, want)
+	}
+}
@@ -0,0 +1,104 @@
+package raster
+
+import "math"
+
+// StackWriter receives the bands of a multi-band result one window at a
+// time, each of the type and nodata value the writer was created with.
+// Write writes the first band.
+type StackWriter interface {
+	Writer
+	WriteBand(band int, window Window, r *FlexRaster) error
+}
+
+// StackType returns the type and nodata value of a multi-band result
+// holding results: the narrowest type holding the values of all of them and
+// the nodata value of the first, promoted as by Masked when it isn't a
+// value of that type.
+func StackType(results []*FlexRaster) (RasterType, float64) {
+	types := make([]RasterType, len(results))
+	for i, r := range results {
+		types[i] = r.RasterType
+	}
+	return Masked(Widen(types...), results[0].NoData)
+}
+
+// SplitStack returns a writer for each of the bands of w, converting the
+// rasters written to it to rasterType and their nodata pixels to noData.
+// Closing the last of the writers closes w.
+func SplitStack(w StackWriter, bands int, rasterType RasterType, noData float64) []Writer {
+	open := bands
+	writers := make([]Writer, bands)
+	for i := range writers {
+		writers[i] = &stackBand{w, i, rasterType, noData, &open}
+	}
+	return writers
+}
+
+type stackBand struct {
+	stack      StackWriter
+	band       int
+	rasterType RasterType
+	noData     float64
+	open       *int
+}
+
+func (b *stackBand) Write(window Window, r *FlexRaster) error {
+	if r.RasterType == b.rasterType && (r.NoData == b.noData || math.IsNaN(r.NoData) && math.IsNaN(b.noData)) {
+		return b.stack.WriteBand(b.band, window, r)
+	}
+
+	out := &FlexRaster{b.rasterType, r.Width, r.Height, NewData(b.rasterType, r.Len()), b.noData}
+	buf := make([]float64, r.Width)
+	nan := math.IsNaN(r.NoData)
+	for y := 0; y < r.Height; y++ {
+		row := r.Float64s(y*r.Width, (y+1)*r.Width, buf)
+		for x, v := range row {
+			if v == r.NoData || nan && math.IsNaN(v) {
+				row[x] = b.noData
+			}
+		}
+		out.SetFloat64s(y*r.Width, row)
+	}
+	return b.stack.WriteBand(b.band, window, out)
+}
+
+func (b *stackBand) Close() error {
+	*b.open--
+	if *b.open == 0 {
+		return b.stack.Close()
+	}
+	return nil
+}
+
+// concatData joins the pixels of rasters of rasterType, one raster after
+// the other.
+func concatData(rasterType RasterType, data []interface{}) interface{} {
+	switch rasterType {
+	case BOOL, UINT8:
+		return concat[uint8](data)
+	case INT16:
+		return concat[int16](data)
+	case UINT16:
+		return concat[uint16](data)
+	case INT32:
+		return concat[int32](data)
+	case UINT32:
+		return concat[uint32](data)
+	case FLOAT64:
+		return concat[float64](data)
+	default:
+		return concat[float32](data)
+	}
+}
+
+func concat[T pixel](data []interface{}) []T {
+	n := 0
+	for _, d := range data {
+		n += len(d.([]T))
+	}
+	out := make([]T, 0, n)
+	for _, d := range data {
+		out = append(out, d.([]T)...)
+	}
+	return out
+}
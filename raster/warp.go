@@ -0,0 +1,121 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+)
+
+// Warp describes the grid a result is reprojected to before it is written.
+type Warp struct {
+	// CRS is any definition GDAL understands, such as "EPSG:4326" or WKT.
+	// Empty keeps the CRS of the result.
+	CRS string
+	// Resolution is the pixel size in units of the CRS. Zero keeps the
+	// pixel size of the result, or, when reprojecting, lets GDAL pick one
+	// of about the same number of pixels.
+	Resolution float64
+	// Resampling computes the pixels of the new grid, nearest neighbour if
+	// empty.
+	Resampling Resampling
+}
+
+// Grid returns the grid covering the extent of grid in the CRS and at the
+// resolution of the warp.
+func (w Warp) Grid(grid *Info) (*Info, error) {
+	target := grid
+	if w.CRS != "" && !SameCRS(w.CRS, grid.Projection) {
+		var err error
+		if target, err = transformGrid(grid, w.CRS); err != nil {
+			return nil, err
+		}
+	}
+	if w.Resolution > 0 {
+		return target.Resized(w.Resolution)
+	}
+	return target, nil
+}
+
+// Resized returns the grid covering the extent of info with square pixels
+// of size, in the corner of info and their orientation.
+func (info Info) Resized(size float64) (*Info, error) {
+	gt := info.GeoTransform
+	if gt[2] != 0 || gt[4] != 0 {
+		return nil, fmt.Errorf("cannot change the pixel size of geotransform %v", gt)
+	}
+	resized := info
+	resized.Width = int(math.Ceil(float64(info.Width)*math.Abs(gt[1])/size - 1e-9))
+	resized.Height = int(math.Ceil(float64(info.Height)*math.Abs(gt[5])/size - 1e-9))
+	resized.GeoTransform[1] = math.Copysign(size, gt[1])
+	resized.GeoTransform[5] = math.Copysign(size, gt[5])
+	return &resized, nil
+}
+
+// WarpRaster reprojects r, whose pixels lie on from, onto grid. Pixels of
+// the grid beyond r are nodata. Grids in the same CRS are resampled in
+// pure Go; reprojecting between CRSs needs GDAL.
+func WarpRaster(r *FlexRaster, from, grid *Info, method Resampling) (*FlexRaster, error) {
+	if method == "" {
+		method = Nearest
+	}
+	if SameCRS(from.Projection, grid.Projection) {
+		return Resample(r, from, grid, method)
+	}
+	if from.Projection == "" {
+		return nil, fmt.Errorf("cannot reproject a result that has no CRS")
+	}
+	return reproject(r, from, grid, method)
+}
+
+// WarpWriter reprojects a result onto another grid before handing it to
+// the writer of that grid. Reprojecting needs the pixels around every
+// output pixel, so the whole result is held in memory and only warped and
+// written when the writer is closed.
+type WarpWriter struct {
+	dst      Writer
+	from, to *Info
+	method   Resampling
+	r        *FlexRaster
+}
+
+// NewWarpWriter returns a writer receiving results on the grid from and
+// writing them to dst on the grid to.
+func NewWarpWriter(dst Writer, from, to *Info, method Resampling) *WarpWriter {
+	return &WarpWriter{dst: dst, from: from, to: to, method: method}
+}
+
+func (w *WarpWriter) Write(window Window, r *FlexRaster) error {
+	if r.Width != window.Width || r.Height != window.Height {
+		return fmt.Errorf("Raster of %d*%d does not fit window %v", r.Width, r.Height, window)
+	}
+	if extent := (Window{0, 0, w.from.Width, w.from.Height}); window.Intersect(extent) != window {
+		return fmt.Errorf("Window %v outside of the %d*%d result", window, w.from.Width, w.from.Height)
+	}
+	if w.r == nil {
+		n := w.from.Width * w.from.Height
+		w.r = &FlexRaster{r.RasterType, w.from.Width, w.from.Height, NewData(r.RasterType, n), r.NoData}
+	}
+
+	buf := make([]float64, r.Width)
+	for y := 0; y < r.Height; y++ {
+		row := r.Float64s(y*r.Width, (y+1)*r.Width, buf)
+		w.r.SetFloat64s((window.YOff+y)*w.from.Width+window.XOff, row)
+	}
+	return nil
+}
+
+// Close warps the result and writes it, closing the destination.
+func (w *WarpWriter) Close() error {
+	if w.r == nil {
+		return w.dst.Close()
+	}
+	warped, err := WarpRaster(w.r, w.from, w.to, w.method)
+	if err != nil {
+		w.dst.Close()
+		return err
+	}
+	if err := w.dst.Write(Window{0, 0, w.to.Width, w.to.Height}, warped); err != nil {
+		w.dst.Close()
+		return err
+	}
+	return w.dst.Close()
+}
@@ -0,0 +1,172 @@
+//go:build !nogdal
+
+package raster
+
+// #include "gdal.h"
+// #include "gdal_alg.h"
+// #include "gdalwarper.h"
+// #include "ogr_srs_api.h"
+// #include "cpl_vsi.h"
+// #cgo LDFLAGS: -lgdal
+// CPLErr
+// suggest_warp_output(GDALDatasetH hSrcDS, void *pTransformArg, double *padfGeoTransform, int *pnPixels, int *pnLines)
+// {
+//	  return GDALSuggestedWarpOutput(hSrcDS, GDALGenImgProjTransform, pTransformArg, padfGeoTransform, pnPixels, pnLines);
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// warpMaxError is the error, in pixels, GDAL may make approximating the
+// transformation between CRSs, the default of gdalwarp.
+const warpMaxError = 0.125
+
+// crsWKT returns the WKT of any CRS definition GDAL understands.
+func crsWKT(crs string) (string, error) {
+	crsCStr := C.CString(crs)
+	defer C.free(unsafe.Pointer(crsCStr))
+	hSRS := C.OSRNewSpatialReference(nil)
+	defer C.OSRDestroySpatialReference(hSRS)
+	if C.OSRSetFromUserInput(hSRS, crsCStr) != C.OGRERR_NONE {
+		return "", fmt.Errorf("unknown CRS %v", crs)
+	}
+
+	var wkt *C.char
+	if C.OSRExportToWkt(hSRS, &wkt) != C.OGRERR_NONE {
+		return "", fmt.Errorf("cannot export CRS %v", crs)
+	}
+	defer C.VSIFree(unsafe.Pointer(wkt))
+	return C.GoString(wkt), nil
+}
+
+// memDataset creates an in-memory dataset on grid with bands bands of the
+// GDAL type matching rasterType, filled with noData.
+func memDataset(grid *Info, bands int, rasterType RasterType, noData float64) (C.GDALDatasetH, error) {
+	registerOnce.Do(func() { C.GDALAllRegister() })
+
+	driverCStr := C.CString("MEM")
+	defer C.free(unsafe.Pointer(driverCStr))
+	hDriver := C.GDALGetDriverByName(driverCStr)
+	if hDriver == nil {
+		return nil, fmt.Errorf("GDAL MEM driver not available")
+	}
+
+	nameCStr := C.CString("")
+	defer C.free(unsafe.Pointer(nameCStr))
+	hDS := C.GDALCreate(hDriver, nameCStr, C.int(grid.Width), C.int(grid.Height), C.int(bands), gdalType(rasterType), nil)
+	if hDS == nil {
+		return nil, fmt.Errorf("Could not create a dataset of %d*%d pixels", grid.Width, grid.Height)
+	}
+
+	gt := grid.GeoTransform
+	C.GDALSetGeoTransform(hDS, (*C.double)(unsafe.Pointer(&gt[0])))
+	projCStr := C.CString(grid.Projection)
+	defer C.free(unsafe.Pointer(projCStr))
+	C.GDALSetProjection(hDS, projCStr)
+
+	for i := 0; i < bands; i++ {
+		hBand := C.GDALGetRasterBand(hDS, C.int(i+1))
+		C.GDALSetRasterNoDataValue(hBand, C.double(noData))
+		C.GDALFillRaster(hBand, C.double(noData), 0)
+	}
+	return hDS, nil
+}
+
+// transformGrid returns the grid GDAL suggests for warping grid to crs,
+// covering its extent with about as many pixels.
+func transformGrid(grid *Info, crs string) (*Info, error) {
+	if grid.Projection == "" {
+		return nil, fmt.Errorf("cannot reproject a result that has no CRS")
+	}
+	wkt, err := crsWKT(crs)
+	if err != nil {
+		return nil, err
+	}
+
+	// A dataset without bands holds no pixels but has the size and
+	// georeferencing the transformer needs.
+	hDS, err := memDataset(grid, 0, BOOL, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer C.GDALClose(hDS)
+
+	srcCStr := C.CString(grid.Projection)
+	defer C.free(unsafe.Pointer(srcCStr))
+	dstCStr := C.CString(wkt)
+	defer C.free(unsafe.Pointer(dstCStr))
+	hTransformArg := C.GDALCreateGenImgProjTransformer(hDS, srcCStr, nil, dstCStr, C.FALSE, 0, 1)
+	if hTransformArg == nil {
+		return nil, fmt.Errorf("cannot transform from the CRS of the result to %v", crs)
+	}
+	defer C.GDALDestroyGenImgProjTransformer(hTransformArg)
+
+	var gt [6]C.double
+	var width, height C.int
+	if C.suggest_warp_output(hDS, hTransformArg, &gt[0], &width, &height) != C.CE_None {
+		return nil, fmt.Errorf("cannot compute the extent of the result in %v", crs)
+	}
+
+	info := &Info{Width: int(width), Height: int(height), Projection: wkt}
+	for i, v := range gt {
+		info.GeoTransform[i] = float64(v)
+	}
+	return info, nil
+}
+
+// reproject warps r, whose pixels lie on from, onto to with GDAL. The
+// destination starts out as nodata and GDAL leaves out nodata pixels of r,
+// so edges and holes stay nodata.
+func reproject(r *FlexRaster, from, to *Info, method Resampling) (*FlexRaster, error) {
+	out := &FlexRaster{r.RasterType, to.Width, to.Height, NewData(r.RasterType, to.Width*to.Height), r.NoData}
+	if r.Len() == 0 || out.Len() == 0 {
+		return out, nil
+	}
+
+	var alg C.GDALResampleAlg
+	switch method {
+	case Nearest:
+		alg = C.GRA_NearestNeighbour
+	case Bilinear:
+		alg = C.GRA_Bilinear
+	case Cubic:
+		alg = C.GRA_Cubic
+	case Average:
+		alg = C.GRA_Average
+	default:
+		return nil, fmt.Errorf("unknown resampling %q", method)
+	}
+
+	hSrcDS, err := memDataset(from, 1, r.RasterType, r.NoData)
+	if err != nil {
+		return nil, err
+	}
+	defer C.GDALClose(hSrcDS)
+	hSrcBand := C.GDALGetRasterBand(hSrcDS, 1)
+	if C.GDALRasterIO(hSrcBand, C.GF_Write, 0, 0, C.int(r.Width), C.int(r.Height), dataPointer(r.Data), C.int(r.Width), C.int(r.Height), gdalType(r.RasterType), 0, 0) != C.CE_None {
+		return nil, fmt.Errorf("Error copying the result for reprojection")
+	}
+
+	hDstDS, err := memDataset(to, 1, r.RasterType, r.NoData)
+	if err != nil {
+		return nil, err
+	}
+	defer C.GDALClose(hDstDS)
+
+	srcCStr := C.CString(from.Projection)
+	defer C.free(unsafe.Pointer(srcCStr))
+	dstCStr := C.CString(to.Projection)
+	defer C.free(unsafe.Pointer(dstCStr))
+	if C.GDALReprojectImage(hSrcDS, srcCStr, hDstDS, dstCStr, alg, 0, warpMaxError, nil, nil, nil) != C.CE_None {
+		return nil, fmt.Errorf("Error reprojecting the result")
+	}
+
+	hDstBand := C.GDALGetRasterBand(hDstDS, 1)
+	if C.GDALRasterIO(hDstBand, C.GF_Read, 0, 0, C.int(out.Width), C.int(out.Height), dataPointer(out.Data), C.int(out.Width), C.int(out.Height), gdalType(out.RasterType), 0, 0) != C.CE_None {
+		return nil, fmt.Errorf("Error reading the reprojected result")
+	}
+	return out, nil
+}
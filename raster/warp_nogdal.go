@@ -0,0 +1,15 @@
+//go:build nogdal
+
+package raster
+
+import "fmt"
+
+// transformGrid needs GDAL to transform between CRSs, so without it results
+// can only be resampled within their own CRS.
+func transformGrid(grid *Info, crs string) (*Info, error) {
+	return nil, fmt.Errorf("cannot reproject to %v without GDAL", crs)
+}
+
+func reproject(r *FlexRaster, from, to *Info, method Resampling) (*FlexRaster, error) {
+	return nil, fmt.Errorf("cannot reproject between CRSs without GDAL")
+}
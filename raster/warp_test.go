@@ -0,0 +1,151 @@
+package raster
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResized(t *testing.T) {
+	for _, tt := range []struct {
+		width, height int
+		gt            [6]float64
+		size          float64
+		wantWidth     int
+		wantHeight    int
+	}{
+		{10, 7, [6]float64{500000, 30, 0, 4000000, 0, -30}, 60, 5, 4},
+		{10, 7, [6]float64{500000, 30, 0, 4000000, 0, -30}, 20, 15, 11},
+		{10, 7, [6]float64{500000, 30, 0, 4000000, 0, -30}, 10, 30, 21},
+		// 3 * 0.1 / 0.3 is a hair above 1 in floating point.
+		{3, 3, [6]float64{0, 0.1, 0, 0, 0, -0.1}, 0.3, 1, 1},
+		// South-up grids keep their orientation.
+		{4, 4, [6]float64{0, 10, 0, 0, 0, 10}, 20, 2, 2},
+	} {
+		info := Info{Width: tt.width, Height: tt.height, GeoTransform: tt.gt, Projection: "EPSG:32633"}
+		got, err := info.Resized(tt.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Width != tt.wantWidth || got.Height != tt.wantHeight {
+			t.Errorf("%dx%d at %v resized to %v = %dx%d, want %dx%d", tt.width, tt.height, tt.gt[1], tt.size, got.Width, got.Height, tt.wantWidth, tt.wantHeight)
+		}
+		want := tt.gt
+		want[1] = tt.size
+		want[5] = tt.size
+		if tt.gt[5] < 0 {
+			want[5] = -tt.size
+		}
+		if got.GeoTransform != want || got.Projection != info.Projection {
+			t.Errorf("resized geotransform %v %s, want %v %s", got.GeoTransform, got.Projection, want, info.Projection)
+		}
+	}
+
+	rotated := Info{Width: 2, Height: 2, GeoTransform: [6]float64{0, 10, 1, 0, 1, -10}}
+	if _, err := rotated.Resized(20); err == nil {
+		t.Error("Resized of a rotated grid: no error")
+	}
+}
+
+// recordWriter records what is written to it.
+type recordWriter struct {
+	windows []Window
+	rasters []*FlexRaster
+	closed  bool
+}
+
+func (w *recordWriter) Write(window Window, r *FlexRaster) error {
+	w.windows, w.rasters = append(w.windows, window), append(w.rasters, r)
+	return nil
+}
+
+func (w *recordWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestWarpWriterSameCRS(t *testing.T) {
+	from := &Info{Width: 4, Height: 4, GeoTransform: [6]float64{0, 10, 0, 40, 0, -10}, Projection: "EPSG:32633"}
+	to, err := Warp{CRS: "EPSG:32633", Resolution: 20}.Grid(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to.Width != 2 || to.Height != 2 {
+		t.Fatalf("grid at 20 m is %dx%d, want 2x2", to.Width, to.Height)
+	}
+
+	// The result arrives in two tiles of two rows.
+	dst := &recordWriter{}
+	w := NewWarpWriter(dst, from, to, Average)
+	for i, values := range [][]float32{{1, 2, 3, 4, 5, 6, 7, 8}, {9, 10, 11, 12, 13, 14, 15, 16}} {
+		r := &FlexRaster{RasterType: FLOAT32, Width: 4, Height: 2, Data: values, NoData: -1}
+		if err := w.Write(Window{XOff: 0, YOff: 2 * i, Width: 4, Height: 2}, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dst.windows) != 0 {
+		t.Fatalf("%d writes before Close, want none", len(dst.windows))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dst.windows) != 1 || dst.windows[0] != (Window{Width: 2, Height: 2}) || !dst.closed {
+		t.Fatalf("writes %v, closed %v, want one of the whole grid and Close", dst.windows, dst.closed)
+	}
+	got := dst.rasters[0].Float64s(0, 4, nil)
+	if want := []float64{3.5, 5.5, 11.5, 13.5}; !equalPixels(got, want) {
+		t.Errorf("averaged pixels %v, want %v", got, want)
+	}
+}
+
+func TestWarpRasterNoDataEdges(t *testing.T) {
+	from := &Info{Width: 4, Height: 4, GeoTransform: [6]float64{0, 10, 0, 40, 0, -10}, Projection: "EPSG:32633"}
+	values := make([]float32, 16)
+	for i := range values {
+		values[i] = float32(i + 1)
+	}
+	r := &FlexRaster{RasterType: FLOAT32, Width: 4, Height: 4, Data: values, NoData: -1}
+
+	// Pixels of 30 m cover the 40 m of the result with a second column
+	// and row whose centres lie beyond it.
+	to, err := from.Resized(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := WarpRaster(r, from, to, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.Float64s(0, out.Len(), nil), []float64{6, -1, -1, -1}; !equalPixels(got, want) {
+		t.Errorf("nearest pixels %v, want %v", got, want)
+	}
+
+	// A grid shifted half a pixel past the left edge has its first column
+	// outside and takes the rest from the pixels it overlaps.
+	shifted := &Info{Width: 5, Height: 1, GeoTransform: [6]float64{-10, 10, 0, 40, 0, -10}, Projection: from.Projection}
+	out, err = WarpRaster(r, from, shifted, Nearest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.Float64s(0, out.Len(), nil), []float64{-1, 1, 2, 3, 4}; !equalPixels(got, want) {
+		t.Errorf("shifted pixels %v, want %v", got, want)
+	}
+}
+
+func TestWarpWriterWindow(t *testing.T) {
+	from := &Info{Width: 4, Height: 4, GeoTransform: [6]float64{0, 10, 0, 40, 0, -10}}
+	w := NewWarpWriter(&recordWriter{}, from, from, Nearest)
+	r := &FlexRaster{RasterType: FLOAT32, Width: 2, Height: 2, Data: make([]float32, 4), NoData: -1}
+	for _, window := range []Window{
+		{XOff: 3, YOff: 0, Width: 2, Height: 2},
+		{XOff: 0, YOff: -1, Width: 2, Height: 2},
+		{XOff: 2, YOff: 3, Width: 2, Height: 2},
+	} {
+		if err := w.Write(window, r); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("Write of window %v: %v, want an error", window, err)
+		}
+	}
+	if err := w.Write(Window{XOff: 2, YOff: 2, Width: 2, Height: 2}, r); err != nil {
+		t.Errorf("Write of the last tile: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go_raster_eval/geotiff"
+)
+
+// Window is a rectangle of pixels within a band.
+type Window struct {
+	XOff, YOff    int
+	Width, Height int
+}
+
+func (w Window) Empty() bool {
+	return w.Width <= 0 || w.Height <= 0
+}
+
+// Intersect returns the part of w that lies within o.
+func (w Window) Intersect(o Window) Window {
+	x0, y0 := max(w.XOff, o.XOff), max(w.YOff, o.YOff)
+	x1, y1 := min(w.XOff+w.Width, o.XOff+o.Width), min(w.YOff+w.Height, o.YOff+o.Height)
+	if x1 < x0 || y1 < y0 {
+		return Window{x0, y0, 0, 0}
+	}
+	return Window{x0, y0, x1 - x0, y1 - y0}
+}
+
+// Tiles splits w into tiles of at most size by size pixels, in row major
+// order.
+func (w Window) Tiles(size int) []Window {
+	tiles := []Window{}
+	for y := w.YOff; y < w.YOff+w.Height; y += size {
+		for x := w.XOff; x < w.XOff+w.Width; x += size {
+			tiles = append(tiles, Window{x, y, size, size}.Intersect(w))
+		}
+	}
+	return tiles
+}
+
+// Info describes the grid of a band: its size in pixels and the GDAL
+// affine geotransform and WKT projection that georeference it.
+type Info struct {
+	Width, Height int
+	GeoTransform  [6]float64
+	Projection    string
+}
+
+// BBox is a georeferenced box. CRS is any definition GDAL understands, such
+// as "EPSG:4326" or WKT; an empty CRS is the CRS of the band it is applied
+// to.
+type BBox struct {
+	MinX, MinY, MaxX, MaxY float64
+	CRS                    string
+}
+
+// CRSKey returns the identity of a CRS, such as the Projection of an Info or
+// the CRS of a BBox: "EPSG:<code>" for CRSs with an EPSG code, whether given
+// as a code or as WKT naming it, and the definition itself otherwise. The
+// pure-Go readers give the CRS of a band as its EPSG code where GDAL gives
+// WKT, so CRSs are compared by their keys rather than verbatim.
+func CRSKey(crs string) string {
+	if code, ok := geotiff.EPSGCode(crs); ok {
+		return fmt.Sprintf("EPSG:%d", code)
+	}
+	return strings.TrimSpace(crs)
+}
+
+// SameCRS reports whether a and b define the same CRS.
+func SameCRS(a, b string) bool {
+	return CRSKey(a) == CRSKey(b)
+}
+
+// Subset returns the grid of the pixels of info within w.
+func (info Info) Subset(w Window) *Info {
+	gt := info.GeoTransform
+	gt[0] += float64(w.XOff)*gt[1] + float64(w.YOff)*gt[2]
+	gt[3] += float64(w.XOff)*gt[4] + float64(w.YOff)*gt[5]
+	return &Info{Width: w.Width, Height: w.Height, GeoTransform: gt, Projection: info.Projection}
+}
+
+// BBoxWindow returns the pixels of the grid covering bbox, clipped to the
+// grid.
+func (info Info) BBoxWindow(bbox BBox) (Window, error) {
+	minX, minY, maxX, maxY := bbox.MinX, bbox.MinY, bbox.MaxX, bbox.MaxY
+	if bbox.CRS != "" && !SameCRS(bbox.CRS, info.Projection) {
+		var err error
+		minX, minY, maxX, maxY, err = transformBBox(bbox, info.Projection)
+		if err != nil {
+			return Window{}, err
+		}
+	}
+	return info.Window(minX, minY, maxX, maxY)
+}
+
+// Window returns the pixels of the grid covering a box given in the CRS of
+// the grid, clipped to the grid.
+func (info Info) Window(minX, minY, maxX, maxY float64) (Window, error) {
+	gt := info.GeoTransform
+	if gt[1] == 0 || gt[5] == 0 || gt[2] != 0 || gt[4] != 0 {
+		return Window{}, fmt.Errorf("cannot map coordinates to pixels with geotransform %v", gt)
+	}
+
+	x0, x1 := (minX-gt[0])/gt[1], (maxX-gt[0])/gt[1]
+	y0, y1 := (maxY-gt[3])/gt[5], (minY-gt[3])/gt[5]
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+
+	xOff, yOff := int(math.Floor(x0)), int(math.Floor(y0))
+	w := Window{xOff, yOff, int(math.Ceil(x1)) - xOff, int(math.Ceil(y1)) - yOff}
+	w = w.Intersect(Window{0, 0, info.Width, info.Height})
+	if w.Empty() {
+		return Window{}, fmt.Errorf("box %v %v %v %v does not overlap the grid", minX, minY, maxX, maxY)
+	}
+	return w, nil
+}
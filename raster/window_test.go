@@ -0,0 +1,42 @@
+package raster
+
+import "testing"
+
+const utm33WKT = `PROJCS["WGS 84 / UTM zone 33N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],AUTHORITY["EPSG","4326"]],PROJECTION["Transverse_Mercator"],UNIT["metre",1,AUTHORITY["EPSG","9001"]],AUTHORITY["EPSG","32633"]]`
+
+func TestSameCRS(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"EPSG:32633", utm33WKT, true},
+		{utm33WKT, "EPSG:32633", true},
+		{"EPSG:32633", "EPSG:32632", false},
+		{"EPSG:4326", utm33WKT, false},
+		{"", "", true},
+		{"", "EPSG:32633", false},
+		{`LOCAL_CS["x"]`, ` LOCAL_CS["x"]`, true},
+	} {
+		if got := SameCRS(c.a, c.b); got != c.want {
+			t.Errorf("SameCRS(%.30q, %.30q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+// TestBBoxWindowCRS applies a box given by EPSG code to a band whose CRS is
+// the WKT GDAL reports for it, which needs no transform.
+func TestBBoxWindowCRS(t *testing.T) {
+	info := Info{Width: 100, Height: 100, GeoTransform: [6]float64{0, 10, 0, 1000, 0, -10}, Projection: utm33WKT}
+	w, err := info.BBoxWindow(BBox{MinX: 100, MinY: 500, MaxX: 300, MaxY: 800, CRS: "EPSG:32633"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Window{XOff: 10, YOff: 20, Width: 20, Height: 30}); w != want {
+		t.Errorf("BBoxWindow = %v, want %v", w, want)
+	}
+
+	grid := Info{Width: 10, Height: 10, GeoTransform: [6]float64{100, 10, 0, 800, 0, -10}, Projection: "EPSG:32633"}
+	if _, err := ReadOnto(&gridSource{info: info}, "B1", 0, &grid, Nearest); err != nil {
+		t.Errorf("ReadOnto: %v", err)
+	}
+}
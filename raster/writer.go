@@ -0,0 +1,100 @@
+//go:build !nogdal
+
+package raster
+
+// #include "gdal.h"
+// #include "cpl_string.h"
+// #cgo LDFLAGS: -lgdal
+// char**
+// get_create_options()
+// {
+//	  char **papszOptions = NULL;
+//	  papszOptions = CSLSetNameValue(papszOptions, "TILED", "YES");
+//	  papszOptions = CSLSetNameValue(papszOptions, "BIGTIFF", "IF_SAFER");
+//	  papszOptions = CSLSetNameValue(papszOptions, "INTERLEAVE", "BAND");
+//	  return papszOptions;
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+type GTiffWriter struct {
+	path string
+	hDS  C.GDALDatasetH
+}
+
+// NewGTiffWriter creates a single band tiled GeoTIFF on the grid described
+// by info, storing pixels in the GDAL type matching rasterType.
+func NewGTiffWriter(path string, info *Info, rasterType RasterType, noData float64) (*GTiffWriter, error) {
+	return NewGTiffStackWriter(path, info, []string{""}, rasterType, noData)
+}
+
+// NewGTiffStackWriter creates a tiled GeoTIFF with a band for each of
+// names, described by them, on the grid described by info, storing pixels
+// in the GDAL type matching rasterType.
+func NewGTiffStackWriter(path string, info *Info, names []string, rasterType RasterType, noData float64) (*GTiffWriter, error) {
+	registerOnce.Do(func() { C.GDALAllRegister() })
+
+	driverCStr := C.CString("GTiff")
+	defer C.free(unsafe.Pointer(driverCStr))
+	hDriver := C.GDALGetDriverByName(driverCStr)
+	if hDriver == nil {
+		return nil, fmt.Errorf("GDAL GTiff driver not available")
+	}
+
+	pathCStr := C.CString(path)
+	defer C.free(unsafe.Pointer(pathCStr))
+
+	opt := C.get_create_options()
+	defer C.CSLDestroy(opt)
+	hDS := C.GDALCreate(hDriver, pathCStr, C.int(info.Width), C.int(info.Height), C.int(len(names)), gdalType(rasterType), opt)
+	if hDS == nil {
+		return nil, fmt.Errorf("Could not create %v", path)
+	}
+
+	C.GDALSetGeoTransform(hDS, (*C.double)(unsafe.Pointer(&info.GeoTransform[0])))
+	projCStr := C.CString(info.Projection)
+	defer C.free(unsafe.Pointer(projCStr))
+	C.GDALSetProjection(hDS, projCStr)
+
+	for i, name := range names {
+		hBand := C.GDALGetRasterBand(hDS, C.int(i+1))
+		// As in COG output, a nodata value the bands can't hold, such as the
+		// -1e10 of integer results, is left out.
+		if fits(rasterType, noData) {
+			C.GDALSetRasterNoDataValue(hBand, C.double(noData))
+		}
+		if name != "" {
+			nameCStr := C.CString(name)
+			C.GDALSetDescription(C.GDALMajorObjectH(hBand), nameCStr)
+			C.free(unsafe.Pointer(nameCStr))
+		}
+	}
+
+	return &GTiffWriter{path: path, hDS: hDS}, nil
+}
+
+func (w *GTiffWriter) Write(window Window, r *FlexRaster) error {
+	return w.WriteBand(0, window, r)
+}
+
+func (w *GTiffWriter) WriteBand(band int, window Window, r *FlexRaster) error {
+	if r.Width != window.Width || r.Height != window.Height {
+		return fmt.Errorf("Raster of %d*%d does not fit window %v", r.Width, r.Height, window)
+	}
+
+	hBand := C.GDALGetRasterBand(w.hDS, C.int(band+1))
+	cErr := C.GDALRasterIO(hBand, C.GF_Write, C.int(window.XOff), C.int(window.YOff), C.int(window.Width), C.int(window.Height), dataPointer(r.Data), C.int(r.Width), C.int(r.Height), gdalType(r.RasterType), 0, 0)
+	if cErr != C.CE_None {
+		return fmt.Errorf("Error writing window %v of %v", window, w.path)
+	}
+	return nil
+}
+
+func (w *GTiffWriter) Close() error {
+	C.GDALClose(w.hDS)
+	return nil
+}
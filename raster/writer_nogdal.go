@@ -0,0 +1,26 @@
+//go:build nogdal
+
+package raster
+
+import "fmt"
+
+// GTiffWriter is only available with GDAL.
+type GTiffWriter struct{}
+
+func NewGTiffWriter(path string, info *Info, rasterType RasterType, noData float64) (*GTiffWriter, error) {
+	return nil, fmt.Errorf("GeoTIFF output through GDAL is not available in builds with the nogdal tag, write a COG instead")
+}
+
+func NewGTiffStackWriter(path string, info *Info, names []string, rasterType RasterType, noData float64) (*GTiffWriter, error) {
+	return nil, fmt.Errorf("GeoTIFF output through GDAL is not available in builds with the nogdal tag, write a COG instead")
+}
+
+func (w *GTiffWriter) Write(window Window, r *FlexRaster) error {
+	return fmt.Errorf("GeoTIFF output through GDAL is not available in builds with the nogdal tag, write a COG instead")
+}
+
+func (w *GTiffWriter) WriteBand(band int, window Window, r *FlexRaster) error {
+	return fmt.Errorf("GeoTIFF output through GDAL is not available in builds with the nogdal tag, write a COG instead")
+}
+
+func (w *GTiffWriter) Close() error { return nil }
@@ -0,0 +1,376 @@
+package raster
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go_raster_eval/zarr"
+)
+
+// ZarrSource reads bands from Zarr arrays in local directory stores. Bands
+// are identified by array name, optionally followed by the positions along
+// the dimensions before the last two, each after a colon, such as "sst:3"
+// for the fourth time of an array of time, y and x. The bands of 3D arrays
+// are also selected by number, from 1, as in sst[4], or by name when the
+// array lists the names of its bands, as the stacks ZarrWriter writes do.
+// The last two dimensions are the rows and columns of the band. Zarr arrays
+// have no overviews.
+type ZarrSource struct {
+	// Pattern names the array directories. If it contains a %s, it is
+	// formatted with the array name, otherwise it names a group holding
+	// the arrays.
+	Pattern string
+}
+
+// array returns the directory of the array of a band, the position of the
+// band along its leading dimensions and the name of the band, if it is
+// selected by name rather than position.
+func (s *ZarrSource) array(band string) (string, []int, string, error) {
+	name, number, member := SplitBand(band)
+
+	parts := strings.Split(name, ":")
+	index := make([]int, len(parts)-1)
+	for i, part := range parts[1:] {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return "", nil, "", fmt.Errorf("invalid index in Zarr band %q", band)
+		}
+		index[i] = v
+	}
+	if number > 0 {
+		index = append(index, number-1)
+	}
+
+	dir := filepath.Join(s.Pattern, parts[0])
+	if strings.Contains(s.Pattern, "%s") {
+		dir = fmt.Sprintf(s.Pattern, parts[0])
+	}
+	return dir, index, member, nil
+}
+
+// Path returns the directory of the array of a band, followed by the
+// position or name of the band for arrays of more than two dimensions.
+func (s *ZarrSource) Path(band string) string {
+	dir, index, member, err := s.array(band)
+	if err != nil {
+		return band
+	}
+	if member != "" {
+		return NamedBand(dir, member)
+	}
+	if len(index) > 0 {
+		return fmt.Sprintf("%s%v", dir, index)
+	}
+	return dir
+}
+
+func (s *ZarrSource) open(band string, overview int) (*zarr.Array, []int, error) {
+	dir, index, member, err := s.array(band)
+	if err != nil {
+		return nil, nil, err
+	}
+	if overview != 0 {
+		return nil, nil, fmt.Errorf("%v: no overview %d, Zarr arrays have none", dir, overview)
+	}
+	a, err := zarr.Open(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member != "" {
+		names, _ := a.Attributes[zarrBandNamesAttr].([]interface{})
+		bandNames := make([][]string, len(names))
+		for i, name := range names {
+			if name, ok := name.(string); ok {
+				bandNames[i] = []string{name}
+			}
+		}
+		n, err := findBand(dir, member, bandNames)
+		if err != nil {
+			return nil, nil, err
+		}
+		index = append(index, n-1)
+	}
+	if len(index) != len(a.Shape)-2 {
+		return nil, nil, fmt.Errorf("%v: band %q gives %d indices, array of shape %v needs %d", dir, band, len(index), a.Shape, len(a.Shape)-2)
+	}
+	return a, index, nil
+}
+
+func (s *ZarrSource) Describe(band string, overview int) (*Info, error) {
+	a, _, err := s.open(band, overview)
+	if err != nil {
+		return nil, err
+	}
+	return zarrInfo(a), nil
+}
+
+func (s *ZarrSource) Overviews(band string) (int, error) {
+	if _, _, err := s.open(band, 0); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (s *ZarrSource) Read(band string, overview int, window *Window) (*FlexRaster, error) {
+	a, index, err := s.open(band, overview)
+	if err != nil {
+		return nil, err
+	}
+
+	info := zarrInfo(a)
+	win := Window{0, 0, info.Width, info.Height}
+	if window != nil {
+		win = window.Intersect(win)
+		if win.Empty() {
+			return nil, fmt.Errorf("Window %v outside of granule %v", *window, s.Path(band))
+		}
+	}
+
+	rasterType, err := zarrType(a.DataType)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", s.Path(band), err)
+	}
+	data, err := a.Read(index, win.XOff, win.YOff, win.Width, win.Height)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", s.Path(band), err)
+	}
+	return &FlexRaster{rasterType, win.Width, win.Height, data, zarrNoData(a)}, nil
+}
+
+// Attributes of Zarr arrays holding their nodata value and georeferencing.
+// _CRS follows the GDAL Zarr driver, holding a "wkt" or "url" member.
+const (
+	zarrNoDataAttr       = "nodata"
+	zarrGeoTransformAttr = "geotransform"
+	zarrCRSAttr          = "crs"
+	zarrBandNamesAttr    = "band_names"
+	zarrGDALCRSAttr      = "_CRS"
+	epsgURL              = "http://www.opengis.net/def/crs/EPSG/0/"
+)
+
+// zarrInfo returns the grid of the last two dimensions of a.
+func zarrInfo(a *zarr.Array) *Info {
+	n := len(a.Shape)
+	info := &Info{Width: a.Shape[n-1], Height: a.Shape[n-2], GeoTransform: [6]float64{0, 1, 0, 0, 0, 1}}
+
+	if gt, ok := a.Attributes[zarrGeoTransformAttr].([]interface{}); ok && len(gt) == 6 {
+		for i, v := range gt {
+			if f, ok := v.(float64); ok {
+				info.GeoTransform[i] = f
+			}
+		}
+	}
+
+	if crs, ok := a.Attributes[zarrCRSAttr].(string); ok {
+		info.Projection = crs
+	} else if crs, ok := a.Attributes[zarrGDALCRSAttr].(map[string]interface{}); ok {
+		if wkt, ok := crs["wkt"].(string); ok {
+			info.Projection = wkt
+		} else if url, ok := crs["url"].(string); ok && strings.HasPrefix(url, epsgURL) {
+			info.Projection = "EPSG:" + strings.TrimPrefix(url, epsgURL)
+		}
+	}
+	return info
+}
+
+// zarrNoData returns the nodata value of a: its nodata or _FillValue
+// attribute, or else its fill value.
+func zarrNoData(a *zarr.Array) float64 {
+	for _, name := range []string{zarrNoDataAttr, "_FillValue"} {
+		switch v := a.Attributes[name].(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	}
+	if a.HasFillValue {
+		return a.FillValue
+	}
+	return NoNoData
+}
+
+func zarrType(dataType zarr.DataType) (RasterType, error) {
+	switch dataType {
+	case zarr.Bool, zarr.Uint8:
+		return UINT8, nil
+	case zarr.Int16:
+		return INT16, nil
+	case zarr.Uint16:
+		return UINT16, nil
+	case zarr.Int32:
+		return INT32, nil
+	case zarr.Uint32:
+		return UINT32, nil
+	case zarr.Float32:
+		return FLOAT32, nil
+	case zarr.Float64:
+		return FLOAT64, nil
+	}
+	return "", fmt.Errorf("unsupported data type %v", dataType)
+}
+
+// ZarrOptions configure the arrays written by ZarrWriter.
+type ZarrOptions struct {
+	// ChunkSize is the side of the square chunks. Zero uses 512.
+	ChunkSize int
+	// Compression is "none", "deflate", "zstd" or "blosc", deflate being
+	// written as gzip. Empty uses deflate.
+	Compression string
+}
+
+var zarrCompressions = map[string]string{
+	"":        "gzip",
+	"none":    "none",
+	"deflate": "gzip",
+	"gzip":    "gzip",
+	"zstd":    "zstd",
+	"blosc":   "blosc",
+}
+
+// ZarrWriter writes a version 3 Zarr array in pure Go, with attributes
+// holding its nodata value and georeferencing. The result is held in
+// memory until Close writes the array, so chunks needn't match the windows
+// it is written in.
+type ZarrWriter struct {
+	dir     string
+	info    *Info
+	bands   []*FlexRaster
+	names   []string
+	options zarr.Options
+}
+
+// NewZarrWriter creates a 2D array in the directory store at path, on the
+// grid described by info, storing pixels in the type matching rasterType.
+func NewZarrWriter(path string, info *Info, rasterType RasterType, noData float64, options ZarrOptions) (*ZarrWriter, error) {
+	return newZarrWriter(path, info, nil, rasterType, noData, options)
+}
+
+// NewZarrStackWriter creates a 3D array of band, y and x in the directory
+// store at path, with a band for each of names, listed in its band_names
+// attribute.
+func NewZarrStackWriter(path string, info *Info, names []string, rasterType RasterType, noData float64, options ZarrOptions) (*ZarrWriter, error) {
+	return newZarrWriter(path, info, names, rasterType, noData, options)
+}
+
+// newZarrWriter creates a writer of a 3D array with a band for each of
+// names, or of a 2D array if there are none.
+func newZarrWriter(path string, info *Info, names []string, rasterType RasterType, noData float64, options ZarrOptions) (*ZarrWriter, error) {
+	compression, ok := zarrCompressions[options.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q, available: none, deflate, zstd, blosc", options.Compression)
+	}
+	chunkSize := options.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = 512
+	}
+	if chunkSize < 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	bands := make([]*FlexRaster, max(len(names), 1))
+	for i := range bands {
+		bands[i] = &FlexRaster{rasterType, info.Width, info.Height, NewData(rasterType, info.Width*info.Height), noData}
+	}
+	return &ZarrWriter{
+		dir:     path,
+		info:    info,
+		bands:   bands,
+		names:   names,
+		options: zarr.Options{ChunkSize: chunkSize, Compression: compression},
+	}, nil
+}
+
+func (w *ZarrWriter) Write(window Window, r *FlexRaster) error {
+	return w.WriteBand(0, window, r)
+}
+
+func (w *ZarrWriter) WriteBand(band int, window Window, r *FlexRaster) error {
+	if r.Width != window.Width || r.Height != window.Height {
+		return fmt.Errorf("Raster of %d*%d does not fit window %v", r.Width, r.Height, window)
+	}
+
+	dst := w.bands[band]
+	buf := make([]float64, r.Width)
+	for y := 0; y < r.Height; y++ {
+		row := r.Float64s(y*r.Width, (y+1)*r.Width, buf)
+		dst.SetFloat64s((window.YOff+y)*dst.Width+window.XOff, row)
+	}
+	return nil
+}
+
+// Close writes the array.
+func (w *ZarrWriter) Close() error {
+	first := w.bands[0]
+	dataType, err := zarrDataType(first.RasterType)
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]interface{}{
+		zarrNoDataAttr:       zarrNumber(first.NoData),
+		zarrGeoTransformAttr: w.info.GeoTransform,
+	}
+	if w.names != nil {
+		attributes[zarrBandNamesAttr] = w.names
+	}
+	if w.info.Projection != "" {
+		attributes[zarrCRSAttr] = w.info.Projection
+		if strings.HasPrefix(w.info.Projection, "EPSG:") {
+			attributes[zarrGDALCRSAttr] = map[string]string{"url": epsgURL + strings.TrimPrefix(w.info.Projection, "EPSG:")}
+		} else {
+			attributes[zarrGDALCRSAttr] = map[string]string{"wkt": w.info.Projection}
+		}
+	}
+
+	// The fill value must be of the data type of the array; nodata values
+	// out of its range leave unwritten chunks zero, as they are in memory.
+	fillValue := first.NoData
+	if !fits(first.RasterType, fillValue) {
+		fillValue = 0
+	}
+
+	r := zarr.Raster{Width: first.Width, Height: first.Height, Bands: len(w.names), DataType: dataType, Data: first.Data}
+	if len(w.bands) > 1 {
+		data := make([]interface{}, len(w.bands))
+		for i, b := range w.bands {
+			data[i] = b.Data
+		}
+		r.Data = concatData(first.RasterType, data)
+	}
+	return zarr.WriteArray(w.dir, r, fillValue, attributes, w.options)
+}
+
+func zarrDataType(rasterType RasterType) (zarr.DataType, error) {
+	switch rasterType {
+	case BOOL, UINT8:
+		return zarr.Uint8, nil
+	case INT16:
+		return zarr.Int16, nil
+	case UINT16:
+		return zarr.Uint16, nil
+	case INT32:
+		return zarr.Int32, nil
+	case UINT32:
+		return zarr.Uint32, nil
+	case FLOAT32:
+		return zarr.Float32, nil
+	case FLOAT64:
+		return zarr.Float64, nil
+	}
+	return 0, fmt.Errorf("unsupported raster type %v", rasterType)
+}
+
+// zarrNumber returns v as an attribute value, non finite values as the
+// strings JSON needs for them.
+func zarrNumber(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return v
+}
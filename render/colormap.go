@@ -0,0 +1,79 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ColorMap maps values in [0, 1] to colours, interpolating linearly between
+// evenly spaced stops.
+type ColorMap []color.NRGBA
+
+// colorMaps are the named colour maps, viridis and RdYlGn as sampled from
+// matplotlib and ColorBrewer.
+var colorMaps = map[string]ColorMap{
+	"viridis": hexMap("440154", "482475", "414487", "355f8d", "2a788e", "21918c", "22a884", "44bf70", "7ad151", "bddf26", "fde725"),
+	"rdylgn":  hexMap("a50026", "d73027", "f46d43", "fdae61", "fee08b", "ffffbf", "d9ef8b", "a6d96a", "66bd63", "1a9850", "006837"),
+	"greys":   hexMap("000000", "ffffff"),
+}
+
+func hexMap(stops ...string) ColorMap {
+	m := make(ColorMap, len(stops))
+	for i, s := range stops {
+		var r, g, b uint8
+		fmt.Sscanf(s, "%02x%02x%02x", &r, &g, &b)
+		m[i] = color.NRGBA{r, g, b, 255}
+	}
+	return m
+}
+
+// GetColorMap returns a colour map by name, ignoring case. Names ending in
+// _r, such as viridis_r, return the map reversed.
+func GetColorMap(name string) (ColorMap, error) {
+	key := strings.ToLower(name)
+	reversed := strings.HasSuffix(key, "_r")
+	m, ok := colorMaps[strings.TrimSuffix(key, "_r")]
+	if !ok {
+		return nil, fmt.Errorf("unknown colour map %q, available: %s", name, strings.Join(ColorMaps(), ", "))
+	}
+	if reversed {
+		r := make(ColorMap, len(m))
+		for i, c := range m {
+			r[len(m)-1-i] = c
+		}
+		m = r
+	}
+	return m, nil
+}
+
+// ColorMaps lists the names of the colour maps.
+func ColorMaps() []string {
+	names := []string{}
+	for name := range colorMaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// At returns the colour of v, clamped to [0, 1]. NaN has the colour of 0.
+func (m ColorMap) At(v float64) color.NRGBA {
+	if v <= 0 || math.IsNaN(v) || len(m) == 1 {
+		return m[0]
+	}
+	if v >= 1 {
+		return m[len(m)-1]
+	}
+
+	pos := v * float64(len(m)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := m[i], m[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y)-float64(x))*f + 0.5)
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
@@ -0,0 +1,164 @@
+// Package render draws results as quicklook images, so they can be checked
+// without loading them into a GIS: single bands through a colour map, or
+// three bands as the red, green and blue of a composite.
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go_raster_eval/raster"
+)
+
+// Stretch selects the values mapped to the ends of a colour map or of a
+// composite channel. Values beyond them are clamped.
+type Stretch struct {
+	// Percent is the percentage of valid pixels clipped at either end, zero
+	// for a linear stretch between the minimum and the maximum.
+	Percent float64
+	// Min and Max, when they differ, fix the range instead.
+	Min, Max float64
+}
+
+// JPEGQuality is the quality JPEG quicklooks are encoded with.
+const JPEGQuality = 90
+
+// Render draws r through cmap. Nodata, NaN and infinite pixels are
+// transparent.
+func Render(r *raster.FlexRaster, cmap ColorMap, stretch Stretch) (*image.NRGBA, error) {
+	if len(cmap) == 0 {
+		return nil, fmt.Errorf("empty colour map")
+	}
+	values, valid := pixels(r)
+	lo, hi, err := bounds(values, valid, stretch)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, r.Width, r.Height))
+	for i, v := range values {
+		if valid[i] {
+			img.SetNRGBA(i%r.Width, i/r.Width, cmap.At(scale(v, lo, hi)))
+		}
+	}
+	return img, nil
+}
+
+// RenderRGB draws a composite of three rasters of the same size, each
+// stretched on its own. Pixels that are nodata, NaN or infinite in any of
+// them are transparent.
+func RenderRGB(red, green, blue *raster.FlexRaster, stretch Stretch) (*image.NRGBA, error) {
+	channels := []*raster.FlexRaster{red, green, blue}
+	for _, c := range channels[1:] {
+		if c.Width != red.Width || c.Height != red.Height {
+			return nil, fmt.Errorf("composite bands differ in size: %dx%d and %dx%d", red.Width, red.Height, c.Width, c.Height)
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, red.Width, red.Height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i+3] = 255
+	}
+	for c, r := range channels {
+		values, valid := pixels(r)
+		lo, hi, err := bounds(values, valid, stretch)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if !valid[i] {
+				img.Pix[i*4+3] = 0
+				continue
+			}
+			img.Pix[i*4+c] = uint8(scale(v, lo, hi)*255 + 0.5)
+		}
+	}
+	// Transparent pixels are left black so encoders that drop alpha agree.
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] == 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = 0, 0, 0
+		}
+	}
+	return img, nil
+}
+
+// Encode writes img as a PNG, or as a JPEG if format is "jpeg" or "jpg".
+// JPEGs have no transparency, so transparent pixels come out black.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch strings.ToLower(format) {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg", "jpg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	}
+	return fmt.Errorf("unsupported image format %q, available: png, jpeg", format)
+}
+
+// WriteFile writes img to path in the format its extension names.
+func WriteFile(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Encode(f, img, strings.TrimPrefix(filepath.Ext(path), ".")); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// pixels returns the values of r and whether each of them is valid, that is
+// neither nodata nor NaN nor infinite, which would leave no finite range
+// to stretch.
+func pixels(r *raster.FlexRaster) ([]float64, []bool) {
+	values := r.Float64s(0, r.Len(), make([]float64, r.Len()))
+	valid := make([]bool, len(values))
+	for i, v := range values {
+		valid[i] = v != r.NoData && !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	return values, valid
+}
+
+// bounds returns the values stretch maps to 0 and 1.
+func bounds(values []float64, valid []bool, stretch Stretch) (float64, float64, error) {
+	if stretch.Min != stretch.Max {
+		return stretch.Min, stretch.Max, nil
+	}
+	if stretch.Percent < 0 || stretch.Percent >= 50 {
+		return 0, 0, fmt.Errorf("invalid stretch percentage %g, must be at least 0 and below 50", stretch.Percent)
+	}
+
+	sorted := []float64{}
+	for i, v := range values {
+		if valid[i] {
+			sorted = append(sorted, v)
+		}
+	}
+	if len(sorted) == 0 {
+		return 0, 0, nil
+	}
+	sort.Float64s(sorted)
+	last := float64(len(sorted) - 1)
+	lo := sorted[int(math.Round(last*stretch.Percent/100))]
+	hi := sorted[int(math.Round(last*(1-stretch.Percent/100)))]
+	return lo, hi, nil
+}
+
+// scale maps v from lo..hi to 0..1, everything to 0 if the range is empty
+// or, for an infinite stretch, undefined.
+func scale(v, lo, hi float64) float64 {
+	t := (v - lo) / (hi - lo)
+	if hi == lo || math.IsNaN(t) {
+		return 0
+	}
+	return math.Max(0, math.Min(1, t))
+}
@@ -0,0 +1,157 @@
+package render
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"go_raster_eval/raster"
+)
+
+func float32Raster(width, height int, noData float64, values ...float32) *raster.FlexRaster {
+	return &raster.FlexRaster{RasterType: raster.FLOAT32, Width: width, Height: height, Data: values, NoData: noData}
+}
+
+func TestColorMapAt(t *testing.T) {
+	greys, err := GetColorMap("greys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed, err := GetColorMap("Greys_r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viridis, err := GetColorMap("viridis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+
+	for _, tt := range []struct {
+		name string
+		cmap ColorMap
+		v    float64
+		want color.NRGBA
+	}{
+		{"greys", greys, 0, black},
+		{"greys", greys, 0.5, color.NRGBA{128, 128, 128, 255}},
+		{"greys", greys, 1, white},
+		{"greys", greys, -3, black},
+		{"greys", greys, 7, white},
+		{"greys", greys, math.NaN(), black},
+		{"greys_r", reversed, 0, white},
+		{"greys_r", reversed, 1, black},
+		{"viridis", viridis, 0, color.NRGBA{0x44, 0x01, 0x54, 255}},
+		{"viridis", viridis, 0.1, color.NRGBA{0x48, 0x24, 0x75, 255}},
+		{"viridis", viridis, 0.05, color.NRGBA{0x46, 0x13, 0x65, 255}},
+		{"viridis", viridis, 1, color.NRGBA{0xfd, 0xe7, 0x25, 255}},
+	} {
+		if got := tt.cmap.At(tt.v); got != tt.want {
+			t.Errorf("%s.At(%v) = %v, want %v", tt.name, tt.v, got, tt.want)
+		}
+	}
+
+	if _, err := GetColorMap("jet"); err == nil || !strings.Contains(err.Error(), "available: greys, rdylgn, viridis") {
+		t.Errorf("GetColorMap of an unknown map: %v", err)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	values := make([]float64, 101)
+	valid := make([]bool, 101)
+	for i := range values {
+		values[i], valid[i] = float64(100-i), true
+	}
+
+	for _, tt := range []struct {
+		stretch Stretch
+		lo, hi  float64
+	}{
+		{Stretch{}, 0, 100},
+		{Stretch{Percent: 2}, 2, 98},
+		{Stretch{Percent: 10}, 10, 90},
+		{Stretch{Percent: 2, Min: -5, Max: 5}, -5, 5},
+	} {
+		lo, hi, err := bounds(values, valid, tt.stretch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lo != tt.lo || hi != tt.hi {
+			t.Errorf("bounds with %+v = %v, %v, want %v, %v", tt.stretch, lo, hi, tt.lo, tt.hi)
+		}
+	}
+
+	// Invalid pixels are left out of the percentiles.
+	valid[0], valid[1] = false, false
+	if lo, hi, _ := bounds(values, valid, Stretch{}); lo != 0 || hi != 98 {
+		t.Errorf("bounds without the largest values = %v, %v, want 0, 98", lo, hi)
+	}
+
+	if _, _, err := bounds(values, valid, Stretch{Percent: 50}); err == nil {
+		t.Error("bounds clipping half the pixels at either end: no error")
+	}
+}
+
+func TestRender(t *testing.T) {
+	greys, _ := GetColorMap("greys")
+	inf := float32(math.Inf(1))
+	r := float32Raster(3, 2, -9999, 10, 20, 30, -9999, float32(math.NaN()), inf)
+
+	img, err := Render(r, greys, Stretch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []color.NRGBA{
+		{0, 0, 0, 255}, {128, 128, 128, 255}, {255, 255, 255, 255},
+		{}, {}, {},
+	}
+	for i, w := range want {
+		if got := img.NRGBAAt(i%3, i/3); got != w {
+			t.Errorf("pixel %d = %v, want %v", i, got, w)
+		}
+	}
+
+	// A raster of nothing but invalid pixels is drawn transparent.
+	img, err = Render(float32Raster(2, 1, 0, 0, -inf), greys, Stretch{Percent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 2; x++ {
+		if got := img.NRGBAAt(x, 0); got.A != 0 {
+			t.Errorf("invalid pixel %d = %v, want transparent", x, got)
+		}
+	}
+}
+
+func TestRenderRGB(t *testing.T) {
+	inf := float32(math.Inf(1))
+	red := float32Raster(3, 1, -1, 0, 50, 100)
+	green := float32Raster(3, 1, -1, 100, -1, 0)
+	blue := float32Raster(3, 1, -1, 1, 2, inf)
+
+	img, err := RenderRGB(red, green, blue, Stretch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each channel is stretched on its own; the second pixel is nodata in
+	// green and the third infinite in blue.
+	want := []color.NRGBA{{0, 255, 0, 255}, {}, {}}
+	for x, w := range want {
+		if got := img.NRGBAAt(x, 0); got != w {
+			t.Errorf("pixel %d = %v, want %v", x, got, w)
+		}
+	}
+
+	img, err = RenderRGB(red, red, red, Stretch{Min: 0, Max: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.NRGBAAt(2, 0), (color.NRGBA{128, 128, 128, 255}); got != want {
+		t.Errorf("fixed stretch pixel = %v, want %v", got, want)
+	}
+
+	if _, err := RenderRGB(red, float32Raster(1, 3, -1, 0, 0, 0), blue, Stretch{}); err == nil {
+		t.Error("RenderRGB of bands of different sizes: no error")
+	}
+}
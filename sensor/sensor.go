@@ -0,0 +1,163 @@
+// Package sensor maps semantic band names such as red or nir onto the band
+// identifiers used by a particular sensor, so that the same expression can
+// be evaluated against Landsat 7, Landsat 8 or Sentinel-2 scenes.
+package sensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+type Profile struct {
+	Name string `json:"-"`
+	// Bands maps semantic names to the sensor's band identifiers.
+	Bands map[string]string `json:"bands"`
+	// QA names the qa package profile that decodes the sensor's quality band.
+	QA string `json:"qa"`
+	// Scale and Offset convert the sensor's reflective bands from digital
+	// numbers to surface reflectance, DN * Scale + Offset. A zero Scale means
+	// the bands aren't calibrated reflectance, so indices that need
+	// reflectance can't be computed from them.
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// Resolve returns the band identifier for a semantic name. Names that are
+// not aliases are returned unchanged so that B4 still means B4.
+func (p *Profile) Resolve(name string) string {
+	if band, ok := p.Bands[name]; ok {
+		return band
+	}
+	return name
+}
+
+var profiles = map[string]*Profile{
+	"landsat7": {
+		Name: "landsat7",
+		Bands: map[string]string{
+			"blue":  "B1",
+			"green": "B2",
+			"red":   "B3",
+			"nir":   "B4",
+			"swir1": "B5",
+			// Collection 1 ships the thermal band twice: the low gain
+			// acquisition covers the full temperature range, the high gain
+			// one resolves it more finely but saturates sooner.
+			"thermal":           "B6_VCID_1",
+			"thermal_high_gain": "B6_VCID_2",
+			"swir2":             "B7",
+			"pan":               "B8",
+			"qa":                "BQA",
+		},
+		QA: "landsat7_c1",
+	},
+	"landsat8": {
+		Name: "landsat8",
+		Bands: map[string]string{
+			"coastal": "B1",
+			"blue":    "B2",
+			"green":   "B3",
+			"red":     "B4",
+			"nir":     "B5",
+			"swir1":   "B6",
+			"swir2":   "B7",
+			"pan":     "B8",
+			"cirrus":  "B9",
+			"tirs1":   "B10",
+			"tirs2":   "B11",
+			"qa":      "BQA",
+		},
+		QA: "landsat8_c1",
+	},
+	"landsat8_c2": {
+		Name: "landsat8_c2",
+		Bands: map[string]string{
+			"coastal": "SR_B1",
+			"blue":    "SR_B2",
+			"green":   "SR_B3",
+			"red":     "SR_B4",
+			"nir":     "SR_B5",
+			"swir1":   "SR_B6",
+			"swir2":   "SR_B7",
+			"thermal": "ST_B10",
+			"qa":      "QA_PIXEL",
+		},
+		QA: "landsat8_c2",
+		// Collection 2 Level-2 surface reflectance
+		Scale:  0.0000275,
+		Offset: -0.2,
+	},
+	"sentinel2": {
+		Name: "sentinel2",
+		Bands: map[string]string{
+			"coastal":  "B01",
+			"blue":     "B02",
+			"green":    "B03",
+			"red":      "B04",
+			"rededge1": "B05",
+			"rededge2": "B06",
+			"rededge3": "B07",
+			"nir":      "B08",
+			"nir08":    "B8A",
+			"vapour":   "B09",
+			"swir1":    "B11",
+			"swir2":    "B12",
+			"qa":       "SCL",
+		},
+		QA: "sentinel2_scl",
+		// L2A surface reflectance. Products from processing baseline 04.00
+		// on add an offset of -1000 DN, so they need a profile with an
+		// Offset of -0.1.
+		Scale: 0.0001,
+	},
+}
+
+func GetProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sensor profile %q", name)
+	}
+	return p, nil
+}
+
+// Profiles returns the names of the registered profiles in alphabetical
+// order.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load registers the profiles defined in a JSON file, replacing built-in
+// profiles with the same name. The file holds an object keyed by profile
+// name:
+//
+//	{"landsat9": {"bands": {"red": "B4", "nir": "B5"}, "qa": "landsat8_c2", "scale": 0.0000275, "offset": -0.2}}
+func Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]*Profile{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parsing sensor profiles %v: %v", path, err)
+	}
+
+	for name, p := range loaded {
+		if p == nil {
+			return fmt.Errorf("sensor profile %q in %v is null", name, path)
+		}
+		if len(p.Bands) == 0 {
+			return fmt.Errorf("sensor profile %q in %v defines no bands", name, path)
+		}
+		p.Name = name
+		profiles[name] = p
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package sensor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLandsat7Thermal(t *testing.T) {
+	p, err := GetProfile("landsat7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"thermal":           "B6_VCID_1",
+		"thermal_high_gain": "B6_VCID_2",
+		"B6_VCID_2":         "B6_VCID_2",
+	} {
+		if got := p.Resolve(name); got != want {
+			t.Errorf("Resolve(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if err := Load(write("ok.json", `{"test_sensor": {"bands": {"red": "R"}, "qa": "landsat8_c2"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := GetProfile("test_sensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "test_sensor" || p.Resolve("red") != "R" || p.QA != "landsat8_c2" {
+		t.Errorf("loaded profile = %+v", p)
+	}
+
+	for name, contents := range map[string]string{
+		"null.json":  `{"empty": null}`,
+		"bands.json": `{"empty": {"qa": "landsat8_c2"}}`,
+		"bad.json":   `{"empty": [}`,
+	} {
+		err := Load(write(name, contents))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Load(%s) = %v, want an error naming the file", name, err)
+		}
+	}
+}
@@ -9,32 +9,48 @@ const (
 	// Identifiers + literals
 	IDENT  = "IDENT"  // B1, red, ...
 	NUMBER = "NUMBER" // 1343.456
+	STRING = "STRING" // "cloud"
 
 	// Special Raster Operators
 	FILTER = "#"
 
 	// Operators
+	ASSIGN   = "="
 	PLUS     = "+"
 	MINUS    = "-"
 	ASTERISK = "*"
 	SLASH    = "/"
 
-	LT = "<"
-	GT = ">"
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
 	// Delimiters
+	COMMA     = ","
 	SEMICOLON = ";"
+	DOT       = "."
 
 	LPAREN = "("
 	RPAREN = ")"
+	LBRACE = "{"
+	RBRACE = "}"
+
+	LBRACKET = "["
+	RBRACKET = "]"
 
 	// Keywords
-	LET   = "LET"
-	TRUE  = "TRUE"
-	FALSE = "FALSE"
+	FUNCTION = "FUNCTION"
+	DEF      = "DEF"
+	LET      = "LET"
+	OUT      = "OUT"
+	RETURN   = "RETURN"
+	IMPORT   = "IMPORT"
+	TRUE     = "TRUE"
+	FALSE    = "FALSE"
 )
 
 type Token struct {
@@ -43,8 +59,14 @@ type Token struct {
 }
 
 var keywords = map[string]TokenType{
-	"true":  TRUE,
-	"false": FALSE,
+	"fn":     FUNCTION,
+	"def":    DEF,
+	"let":    LET,
+	"out":    OUT,
+	"return": RETURN,
+	"import": IMPORT,
+	"true":   TRUE,
+	"false":  FALSE,
 }
 
 func LookupIdent(ident string) TokenType {
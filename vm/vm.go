@@ -0,0 +1,491 @@
+// Package vm executes compiled programs over blocks of pixels. Every
+// instruction processes a whole block at once, so dispatch costs are paid
+// per block rather than per pixel.
+package vm
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"go_raster_eval/code"
+	"go_raster_eval/compiler"
+	"go_raster_eval/evaluator"
+	"go_raster_eval/object"
+	"go_raster_eval/raster"
+)
+
+const BlockSize = 4096
+const StackSize = 64
+
+type VM struct {
+	bytecode *compiler.Bytecode
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return &VM{bytecode: bytecode}
+}
+
+// Run reads the program's bands through the evaluation options and
+// executes it block by block. Results are identical to evaluator.Eval.
+func (vm *VM) Run(env *object.Environment) (*raster.FlexRaster, error) {
+	options := env.Options()
+	defer evaluator.BeginRun(options)()
+
+	bands := make([]*raster.FlexRaster, len(vm.bytecode.Bands))
+	for i, band := range vm.bytecode.Bands {
+		r, err := evaluator.ReadBand(band, options)
+		if err != nil {
+			return nil, fmt.Errorf("Raster reading operation failed: %v", err)
+		}
+		if i > 0 && (r.Width != bands[0].Width || r.Height != bands[0].Height) {
+			return nil, fmt.Errorf("non compatible rasters: Different width/height dimensions found. %d*%d %d*%d", bands[0].Width, bands[0].Height, r.Width, r.Height)
+		}
+		bands[i] = r
+	}
+	if len(bands) == 0 {
+		return nil, fmt.Errorf("program does not read any raster")
+	}
+
+	width, height := bands[0].Width, bands[0].Height
+	size := width * height
+
+	// The first block runs on its own: it settles the type and nodata of
+	// the result and reports type errors before any work is spread out.
+	first := newFrame(vm.bytecode, bands)
+	result, err := first.execute(0, min(BlockSize, size))
+	if err != nil {
+		return nil, err
+	}
+	out := &raster.FlexRaster{RasterType: result.rasterType, Width: width, Height: height, Data: raster.NewData(result.rasterType, size), NoData: result.noData}
+	out.SetFloat64s(0, result.vector)
+
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			f := newFrame(vm.bytecode, bands)
+			for start := (w + 1) * BlockSize; start < size; start += workers * BlockSize {
+				end := min(start+BlockSize, size)
+				v, err := f.execute(start, end)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				out.SetFloat64s(start, v.vector)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// value is a stack entry: either a scalar or the block of a raster along
+// with the raster's type and nodata value.
+type value struct {
+	vector     []float64
+	scalar     float64
+	rasterType raster.RasterType
+	noData     float64
+}
+
+func (v value) isRaster() bool { return v.vector != nil }
+
+func (v value) typeName() string {
+	if v.isRaster() {
+		return object.RASTER_OBJ
+	}
+	return object.NUMBER_OBJ
+}
+
+// frame holds the state of one goroutine executing blocks. Buffers are
+// reused from one block to the next.
+type frame struct {
+	bytecode *compiler.Bytecode
+	bands    []*raster.FlexRaster
+
+	stack  []value
+	sp     int
+	locals []value
+
+	buffers [][]float64
+	used    int
+}
+
+func newFrame(bytecode *compiler.Bytecode, bands []*raster.FlexRaster) *frame {
+	return &frame{
+		bytecode: bytecode,
+		bands:    bands,
+		stack:    make([]value, StackSize),
+		locals:   make([]value, bytecode.NumLocals),
+	}
+}
+
+func (f *frame) alloc(n int) []float64 {
+	if f.used == len(f.buffers) {
+		f.buffers = append(f.buffers, make([]float64, BlockSize))
+	}
+	buf := f.buffers[f.used][:n]
+	f.used++
+	return buf
+}
+
+func (f *frame) push(v value) error {
+	if f.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	f.stack[f.sp] = v
+	f.sp++
+	return nil
+}
+
+func (f *frame) pop() (value, error) {
+	if f.sp == 0 {
+		return value{}, fmt.Errorf("stack underflow")
+	}
+	f.sp--
+	return f.stack[f.sp], nil
+}
+
+// binary pops the operands of a binary operator and applies it.
+func (f *frame) binary(op code.Opcode) (value, error) {
+	right, err := f.pop()
+	if err != nil {
+		return value{}, err
+	}
+	left, err := f.pop()
+	if err != nil {
+		return value{}, err
+	}
+
+	switch op {
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		return f.arithmetic(op, left, right)
+	case code.OpLessThan, code.OpGreaterThan, code.OpLessEqual, code.OpGreaterEqual:
+		return f.comparison(op, left, right)
+	case code.OpMask:
+		return f.mask(left, right)
+	default:
+		return f.filter(left, right)
+	}
+}
+
+// execute runs the program over pixels [start, end).
+func (f *frame) execute(start, end int) (value, error) {
+	f.sp = 0
+	f.used = 0
+	ins := f.bytecode.Instructions
+
+	for ip := 0; ip < len(ins); ip++ {
+		op := code.Opcode(ins[ip])
+
+		var err error
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			err = f.push(value{scalar: float64(f.bytecode.Constants[idx])})
+
+		case code.OpBand:
+			idx := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			band := f.bands[idx]
+			err = f.push(value{vector: band.Float64s(start, end, f.alloc(end-start)), rasterType: band.RasterType, noData: band.NoData})
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpLessThan, code.OpGreaterThan, code.OpLessEqual, code.OpGreaterEqual,
+			code.OpMask, code.OpFilter:
+			var v value
+			if v, err = f.binary(op); err == nil {
+				err = f.push(v)
+			}
+
+		case code.OpCallBuiltin:
+			builtin := code.ReadUint8(ins[ip+1:])
+			arg := code.ReadUint16(ins[ip+2:])
+			ip += 3
+			var v value
+			if v, err = f.pop(); err == nil {
+				if v, err = f.callBuiltin(int(builtin), int(arg), v); err == nil {
+					err = f.push(v)
+				}
+			}
+
+		case code.OpSetLocal:
+			idx := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			f.locals[idx], err = f.pop()
+
+		case code.OpGetLocal:
+			idx := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			err = f.push(f.locals[idx])
+
+		case code.OpPop:
+			_, err = f.pop()
+
+		default:
+			err = fmt.Errorf("opcode %d undefined", op)
+		}
+
+		if err != nil {
+			return value{}, err
+		}
+	}
+
+	if f.sp != 1 || !f.stack[0].isRaster() {
+		return value{}, fmt.Errorf("program did not produce a raster")
+	}
+	return f.stack[0], nil
+}
+
+var operators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpMask:         "==",
+	code.OpFilter:       "#",
+}
+
+func unknownOperator(op code.Opcode, left, right value) error {
+	return fmt.Errorf("unknown operator: %s %s %s", left.typeName(), operators[op], right.typeName())
+}
+
+func (f *frame) arithmetic(op code.Opcode, left, right value) (value, error) {
+	switch {
+	case left.isRaster() && right.isRaster():
+		if left.noData != right.noData {
+			return value{}, fmt.Errorf("non compatible rasters: Different NoData values found.")
+		}
+		l, r := left.vector, right.vector
+		out := f.alloc(len(l))
+		switch op {
+		case code.OpAdd:
+			for i := range out {
+				out[i] = l[i] + r[i]
+			}
+		case code.OpSub:
+			for i := range out {
+				out[i] = l[i] - r[i]
+			}
+		case code.OpMul:
+			for i := range out {
+				out[i] = l[i] * r[i]
+			}
+		case code.OpDiv:
+			for i := range out {
+				out[i] = l[i] / r[i]
+			}
+		}
+		return rounded(value{vector: out, rasterType: raster.Promote(left.rasterType, right.rasterType), noData: left.noData}), nil
+
+	case left.isRaster():
+		l, n := left.vector, right.scalar
+		out := f.alloc(len(l))
+		switch op {
+		case code.OpAdd:
+			for i := range out {
+				out[i] = l[i] + n
+			}
+		case code.OpSub:
+			for i := range out {
+				out[i] = l[i] - n
+			}
+		case code.OpMul:
+			for i := range out {
+				out[i] = l[i] * n
+			}
+		case code.OpDiv:
+			for i := range out {
+				out[i] = l[i] / n
+			}
+		}
+		return rounded(value{vector: out, rasterType: raster.Promote(left.rasterType), noData: left.noData}), nil
+
+	case right.isRaster():
+		n, r := left.scalar, right.vector
+		out := f.alloc(len(r))
+		switch op {
+		case code.OpAdd:
+			for i := range out {
+				out[i] = r[i] + n
+			}
+		case code.OpSub:
+			for i := range out {
+				out[i] = n - r[i]
+			}
+		case code.OpMul:
+			for i := range out {
+				out[i] = r[i] * n
+			}
+		case code.OpDiv:
+			for i := range out {
+				out[i] = n / r[i]
+			}
+		}
+		return rounded(value{vector: out, rasterType: raster.Promote(right.rasterType), noData: right.noData}), nil
+	}
+
+	return value{}, unknownOperator(op, left, right)
+}
+
+// rounded rounds an arithmetic result to float32 when it is stored as
+// FLOAT32, as Eval does between operations.
+func rounded(v value) value {
+	if v.rasterType == raster.FLOAT32 {
+		for i, x := range v.vector {
+			v.vector[i] = float64(float32(x))
+		}
+	}
+	return v
+}
+
+// mirrored gives the comparison that holds with its operands swapped.
+var mirrored = map[code.Opcode]code.Opcode{
+	code.OpLessThan:     code.OpGreaterThan,
+	code.OpGreaterThan:  code.OpLessThan,
+	code.OpLessEqual:    code.OpGreaterEqual,
+	code.OpGreaterEqual: code.OpLessEqual,
+}
+
+func (f *frame) comparison(op code.Opcode, left, right value) (value, error) {
+	if !left.isRaster() && right.isRaster() {
+		return f.comparison(mirrored[op], right, left)
+	}
+	if !left.isRaster() || right.isRaster() {
+		return value{}, unknownOperator(op, left, right)
+	}
+
+	l, n := left.vector, right.scalar
+	out := f.alloc(len(l))
+	switch op {
+	case code.OpLessThan:
+		for i := range out {
+			out[i] = boolPixel(l[i] < n)
+		}
+	case code.OpGreaterThan:
+		for i := range out {
+			out[i] = boolPixel(l[i] > n)
+		}
+	case code.OpLessEqual:
+		for i := range out {
+			out[i] = boolPixel(l[i] <= n)
+		}
+	case code.OpGreaterEqual:
+		for i := range out {
+			out[i] = boolPixel(l[i] >= n)
+		}
+	}
+	return value{vector: out, rasterType: raster.BOOL, noData: left.noData}, nil
+}
+
+func (f *frame) mask(left, right value) (value, error) {
+	if !left.isRaster() && right.isRaster() {
+		left, right = right, left
+	}
+	if !left.isRaster() || right.isRaster() {
+		return value{}, unknownOperator(code.OpMask, left, right)
+	}
+
+	l := left.vector
+	out := f.alloc(len(l))
+	switch left.rasterType {
+	case raster.UINT8:
+		for i := range out {
+			out[i] = boolPixel(l[i] == right.scalar)
+		}
+	case raster.UINT16:
+		mask := uint16(right.scalar)
+		for i := range out {
+			out[i] = boolPixel(uint16(l[i])&mask > 0)
+		}
+	case raster.INT16:
+		mask := int16(right.scalar)
+		for i := range out {
+			out[i] = boolPixel(int16(l[i])&mask > 0)
+		}
+	case raster.UINT32:
+		mask := uint32(right.scalar)
+		for i := range out {
+			out[i] = boolPixel(uint32(l[i])&mask > 0)
+		}
+	case raster.INT32:
+		mask := int32(right.scalar)
+		for i := range out {
+			out[i] = boolPixel(int32(l[i])&mask > 0)
+		}
+	default:
+		return value{}, fmt.Errorf("Masking not implemented for type %s", left.rasterType)
+	}
+	return value{vector: out, rasterType: raster.BOOL, noData: left.noData}, nil
+}
+
+func (f *frame) filter(left, right value) (value, error) {
+	if !left.isRaster() || !right.isRaster() {
+		return value{}, unknownOperator(code.OpFilter, left, right)
+	}
+	if right.rasterType != raster.BOOL {
+		return value{}, fmt.Errorf("Raster on the right must be a Boolean raster type.")
+	}
+
+	rasterType, noData := raster.Masked(left.rasterType, left.noData)
+	l, r := left.vector, right.vector
+	out := f.alloc(len(l))
+	for i := range out {
+		if r[i] == 1.0 {
+			out[i] = noData
+		} else {
+			out[i] = l[i]
+		}
+	}
+	return value{vector: out, rasterType: rasterType, noData: noData}, nil
+}
+
+func (f *frame) callBuiltin(builtin, arg int, band value) (value, error) {
+	switch builtin {
+	case code.BuiltinQA:
+		if band.rasterType != raster.UINT16 && band.rasterType != raster.UINT8 {
+			return value{}, fmt.Errorf("QA decoding not implemented for type %s", band.rasterType)
+		}
+		field := f.bytecode.Fields[arg]
+
+		rasterType := raster.UINT8
+		if field.IsFlag() {
+			rasterType = raster.BOOL
+		}
+
+		out := f.alloc(len(band.vector))
+		for i, v := range band.vector {
+			out[i] = float64(field.Decode(uint16(v)))
+		}
+		return value{vector: out, rasterType: rasterType, noData: raster.NoNoData}, nil
+	}
+
+	return value{}, fmt.Errorf("builtin %d undefined", builtin)
+}
+
+func boolPixel(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
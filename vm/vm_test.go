@@ -0,0 +1,26 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"go_raster_eval/code"
+	"go_raster_eval/compiler"
+	"go_raster_eval/raster"
+)
+
+func TestStackUnderflow(t *testing.T) {
+	band := &raster.FlexRaster{RasterType: raster.UINT16, Width: 2, Height: 1, Data: []uint16{1, 2}}
+	for name, ins := range map[string][]byte{
+		"add":       append(code.Make(code.OpBand, 0), code.Make(code.OpAdd)...),
+		"pop":       code.Make(code.OpPop),
+		"set local": code.Make(code.OpSetLocal, 0),
+		"qa":        code.Make(code.OpCallBuiltin, code.BuiltinQA, 0),
+	} {
+		bytecode := &compiler.Bytecode{Instructions: ins, NumLocals: 1}
+		_, err := newFrame(bytecode, []*raster.FlexRaster{band}).execute(0, 2)
+		if err == nil || !strings.Contains(err.Error(), "stack underflow") {
+			t.Errorf("%s: error %v, want stack underflow", name, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"go_raster_eval/zstdpool"
+)
+
+// Blosc header flags and the compressors in its top three bits.
+const (
+	bloscShuffle    = 0x01
+	bloscMemcpyed   = 0x02
+	bloscBitShuffle = 0x04
+	bloscDontSplit  = 0x10
+
+	bloscLZ     = 0
+	bloscLZ4    = 1
+	bloscSnappy = 2
+	bloscZlib   = 3
+	bloscZstd   = 4
+
+	bloscHeaderSize = 16
+	// Blocks are split into a stream per byte of their elements when the
+	// elements are at most bloscMaxSplits bytes and the streams at least
+	// bloscMinSplit long.
+	bloscMaxSplits = 16
+	bloscMinSplit  = 128
+)
+
+var bloscNames = map[int]string{
+	bloscLZ:     "blosclz",
+	bloscLZ4:    "lz4",
+	bloscSnappy: "snappy",
+	bloscZlib:   "zlib",
+	bloscZstd:   "zstd",
+}
+
+// decodeBlosc decompresses a buffer in the blosc 1 format: a header, the
+// offsets of the blocks the data was cut into, and the blocks, each
+// compressed in one or more streams and optionally byte or bit shuffled.
+// Blocks compressed with BloscLZ aren't supported.
+func decodeBlosc(src []byte) ([]byte, error) {
+	if len(src) < bloscHeaderSize {
+		return nil, fmt.Errorf("buffer too short for its header")
+	}
+	version, flags, typeSize := src[0], src[2], int(src[3])
+	size := int(binary.LittleEndian.Uint32(src[4:]))
+	blockSize := int(binary.LittleEndian.Uint32(src[8:]))
+	if typeSize == 0 {
+		typeSize = 1
+	}
+
+	if flags&bloscMemcpyed != 0 {
+		if len(src) < bloscHeaderSize+size {
+			return nil, fmt.Errorf("buffer holds %d bytes, want %d", len(src)-bloscHeaderSize, size)
+		}
+		return src[bloscHeaderSize : bloscHeaderSize+size], nil
+	}
+	compressor := int(flags >> 5)
+	if compressor != bloscLZ4 && compressor != bloscSnappy && compressor != bloscZlib && compressor != bloscZstd {
+		name, ok := bloscNames[compressor]
+		if !ok {
+			name = fmt.Sprintf("compressor %d", compressor)
+		}
+		return nil, fmt.Errorf("%v compressed buffers are not supported", name)
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+
+	blocks := (size + blockSize - 1) / blockSize
+	if len(src) < bloscHeaderSize+4*blocks {
+		return nil, fmt.Errorf("buffer too short for its %d block offsets", blocks)
+	}
+	out := make([]byte, size)
+	tmp := make([]byte, blockSize)
+	for b := 0; b < blocks; b++ {
+		bsize := min(blockSize, size-b*blockSize)
+		leftover := bsize < blockSize
+
+		splits := 1
+		if flags&bloscDontSplit == 0 && typeSize <= bloscMaxSplits && blockSize/typeSize >= bloscMinSplit && !leftover {
+			splits = typeSize
+		}
+		split := bsize / splits
+
+		at := int(binary.LittleEndian.Uint32(src[bloscHeaderSize+4*b:]))
+		dst := tmp[:bsize]
+		for s := 0; s < splits; s++ {
+			if at+4 > len(src) {
+				return nil, fmt.Errorf("block %d past the end of the buffer", b)
+			}
+			n := int(binary.LittleEndian.Uint32(src[at:]))
+			at += 4
+			if n < 0 || at+n > len(src) {
+				return nil, fmt.Errorf("block %d past the end of the buffer", b)
+			}
+			stream, part := src[at:at+n], dst[s*split:(s+1)*split]
+			at += n
+
+			if n == split {
+				copy(part, stream)
+				continue
+			}
+			if err := bloscStream(compressor, stream, part); err != nil {
+				return nil, fmt.Errorf("block %d: %v", b, err)
+			}
+		}
+
+		switch {
+		case flags&bloscShuffle != 0 && typeSize > 1:
+			unshuffle(out[b*blockSize:b*blockSize+bsize], dst, typeSize)
+		case flags&bloscBitShuffle != 0 && bsize >= typeSize:
+			bitunshuffle(out[b*blockSize:b*blockSize+bsize], dst, typeSize, version)
+		default:
+			copy(out[b*blockSize:], dst)
+		}
+	}
+	return out, nil
+}
+
+// bloscStream decompresses a stream of a block into dst, which it must
+// fill exactly.
+func bloscStream(compressor int, stream, dst []byte) error {
+	var out []byte
+	var err error
+	switch compressor {
+	case bloscLZ4:
+		out, err = decodeLZ4(stream, len(dst))
+	case bloscSnappy:
+		out, err = s2.Decode(nil, stream)
+	case bloscZlib:
+		var r io.ReadCloser
+		r, err = zlib.NewReader(bytes.NewReader(stream))
+		if err == nil {
+			out, err = readAll(r, len(dst))
+			r.Close()
+		}
+	case bloscZstd:
+		out, err = zstdpool.Decoder().DecodeAll(stream, make([]byte, 0, len(dst)))
+	}
+	if err != nil {
+		return err
+	}
+	if len(out) != len(dst) {
+		return fmt.Errorf("stream holds %d bytes, want %d", len(out), len(dst))
+	}
+	copy(dst, out)
+	return nil
+}
+
+// unshuffle undoes the byte shuffle of src into dst: src holds the first
+// byte of every element, then the second of every element, and so on.
+// Trailing bytes short of an element aren't shuffled.
+func unshuffle(dst, src []byte, typeSize int) {
+	n := len(src) / typeSize
+	for i := 0; i < n; i++ {
+		for j := 0; j < typeSize; j++ {
+			dst[i*typeSize+j] = src[j*n+i]
+		}
+	}
+	copy(dst[n*typeSize:], src[n*typeSize:])
+}
+
+// shuffle is the inverse of unshuffle.
+func shuffle(dst, src []byte, typeSize int) {
+	n := len(src) / typeSize
+	for i := 0; i < n; i++ {
+		for j := 0; j < typeSize; j++ {
+			dst[j*n+i] = src[i*typeSize+j]
+		}
+	}
+	copy(dst[n*typeSize:], src[n*typeSize:])
+}
+
+// bitunshuffle undoes the bit shuffle of src into dst: src holds a row of
+// bits for each bit of an element, the lowest bit of the first byte first,
+// and each row holds that bit of every element, packed into bytes from
+// their lowest bit. Rows are whole bytes, so only a multiple of 8 elements
+// are shuffled: the trailing elements of blocks in format version 3 and
+// up, and whole blocks that aren't such a multiple in format version 2,
+// are stored as they are.
+func bitunshuffle(dst, src []byte, typeSize int, version byte) {
+	n := len(src) / typeSize
+	if version <= 2 && n%8 != 0 {
+		n = 0
+	}
+	n -= n % 8
+
+	row := n / 8
+	clear(dst[:n*typeSize])
+	for j := 0; j < typeSize; j++ {
+		for k := 0; k < 8; k++ {
+			bits := src[(j*8+k)*row : (j*8+k+1)*row]
+			for i := 0; i < n; i++ {
+				dst[i*typeSize+j] |= (bits[i/8] >> (i % 8) & 1) << k
+			}
+		}
+	}
+	copy(dst[n*typeSize:], src[n*typeSize:])
+}
+
+// decodeLZ4 decompresses an LZ4 block of size bytes. Blocks are sequences
+// of literals followed by matches copying bytes already decompressed.
+func decodeLZ4(src []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	length := func(i int, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, fmt.Errorf("truncated lz4 block")
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		var literals int
+		var err error
+		if i, literals, err = length(i, int(token>>4)); err != nil {
+			return nil, err
+		}
+		if i+literals > len(src) || len(out)+literals > size {
+			return nil, fmt.Errorf("lz4 literals past the end of the block")
+		}
+		out = append(out, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("truncated lz4 block")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		var match int
+		if i, match, err = length(i, int(token&15)); err != nil {
+			return nil, err
+		}
+		match += 4
+		if offset == 0 || offset > len(out) || len(out)+match > size {
+			return nil, fmt.Errorf("invalid lz4 match")
+		}
+		// Matches may overlap the bytes they produce.
+		from := len(out) - offset
+		for k := 0; k < match; k++ {
+			out = append(out, out[from+k])
+		}
+	}
+	return out, nil
+}
+
+// encodeBlosc compresses src with blosc, as a single block compressed with
+// zstd in one stream, byte shuffled when its elements are wider than a
+// byte. Blocks that don't compress are stored as they are.
+func encodeBlosc(src []byte, typeSize int) []byte {
+	flags := byte(bloscZstd<<5 | bloscDontSplit)
+	block := src
+	if typeSize > 1 {
+		flags |= bloscShuffle
+		block = make([]byte, len(src))
+		shuffle(block, src, typeSize)
+	}
+
+	stream := zstdpool.Encoder().EncodeAll(block, nil)
+	if len(stream) >= len(block) {
+		stream = block
+	}
+
+	const start = bloscHeaderSize + 4
+	out := make([]byte, start+4, start+4+len(stream))
+	out[0], out[1], out[2], out[3] = 2, 1, flags, byte(typeSize)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(src)))
+	binary.LittleEndian.PutUint32(out[8:], uint32(len(src)))
+	binary.LittleEndian.PutUint32(out[12:], uint32(start+4+len(stream)))
+	binary.LittleEndian.PutUint32(out[16:], start)
+	binary.LittleEndian.PutUint32(out[20:], uint32(len(stream)))
+	return append(out, stream...)
+}
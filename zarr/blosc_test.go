@@ -0,0 +1,144 @@
+package zarr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"go_raster_eval/zstdpool"
+)
+
+// bloscBuffer builds a blosc buffer of a single block of size bytes held
+// in streams.
+func bloscBuffer(version, flags byte, typeSize, size int, streams ...[]byte) []byte {
+	out := make([]byte, bloscHeaderSize+4)
+	out[0], out[1], out[2], out[3] = version, 1, flags, byte(typeSize)
+	binary.LittleEndian.PutUint32(out[4:], uint32(size))
+	binary.LittleEndian.PutUint32(out[8:], uint32(size))
+	binary.LittleEndian.PutUint32(out[16:], bloscHeaderSize+4)
+	for _, stream := range streams {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(stream)))
+		out = append(out, stream...)
+	}
+	binary.LittleEndian.PutUint32(out[12:], uint32(len(out)))
+	return out
+}
+
+// lz4Literals encodes src as an LZ4 block of literals only.
+func lz4Literals(src []byte) []byte {
+	if len(src) < 15 {
+		return append([]byte{byte(len(src) << 4)}, src...)
+	}
+	out := []byte{0xf0}
+	n := len(src) - 15
+	for ; n >= 255; n -= 255 {
+		out = append(out, 255)
+	}
+	return append(append(out, byte(n)), src...)
+}
+
+// bitshuffle is the inverse of bitunshuffle, for format version 3.
+func bitshuffle(src []byte, typeSize int) []byte {
+	dst := make([]byte, len(src))
+	n := len(src) / typeSize
+	n -= n % 8
+	row := n / 8
+	for i := 0; i < n; i++ {
+		for j := 0; j < typeSize; j++ {
+			for k := 0; k < 8; k++ {
+				if src[i*typeSize+j]>>k&1 != 0 {
+					dst[(j*8+k)*row+i/8] |= 1 << (i % 8)
+				}
+			}
+		}
+	}
+	copy(dst[n*typeSize:], src[n*typeSize:])
+	return dst
+}
+
+func uint16Bytes(values ...uint16) []byte {
+	out := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(out[2*i:], v)
+	}
+	return out
+}
+
+func TestBitunshuffle(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		typeSize int
+		version  byte
+		shuffled []byte
+		elements []byte
+	}{
+		{"first element", 1, 2, []byte{1, 0, 0, 0, 0, 0, 0, 0}, []byte{1, 0, 0, 0, 0, 0, 0, 0}},
+		{"high and low bits", 1, 2, []byte{2, 0, 0, 0, 0, 0, 0, 1}, []byte{0x80, 1, 0, 0, 0, 0, 0, 0}},
+		{"uint16", 2, 2,
+			[]byte{8, 8, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0},
+			uint16Bytes(0x100, 0, 0, 3, 0, 0, 0, 0)},
+		// Trailing elements short of a multiple of 8 are stored as they
+		// are, or the whole block is in format version 2.
+		{"trailing element", 1, 3, []byte{1, 0, 0, 0, 0, 0, 0, 0, 0xab}, []byte{1, 0, 0, 0, 0, 0, 0, 0, 0xab}},
+		{"version 2 leftover", 1, 2, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	} {
+		got := make([]byte, len(c.shuffled))
+		bitunshuffle(got, c.shuffled, c.typeSize, c.version)
+		if !bytes.Equal(got, c.elements) {
+			t.Errorf("%s: bitunshuffle = %v, want %v", c.name, got, c.elements)
+		}
+	}
+}
+
+func TestDecodeLZ4(t *testing.T) {
+	// "abcd", then a match of 8 bytes 4 back, overlapping its own output,
+	// then "e".
+	src := []byte{0x44, 'a', 'b', 'c', 'd', 4, 0, 0x10, 'e'}
+	got, err := decodeLZ4(src, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdabcdabcde" {
+		t.Errorf("decodeLZ4 = %q, want %q", got, "abcdabcdabcde")
+	}
+	if _, err := decodeLZ4([]byte{0x44, 'a', 'b', 'c', 'd', 9, 0}, 13); err == nil {
+		t.Error("decodeLZ4 of a match before the start of the block succeeded")
+	}
+}
+
+func TestDecodeBlosc(t *testing.T) {
+	values := make([]uint16, 300)
+	for i := range values {
+		values[i] = uint16(i * 7919)
+	}
+	data := uint16Bytes(values...)
+	shuffled := make([]byte, len(data))
+	shuffle(shuffled, data, 2)
+	half := len(shuffled) / 2
+
+	for name, buffer := range map[string][]byte{
+		"memcpyed": append(bloscBuffer(2, bloscMemcpyed, 2, len(data))[:bloscHeaderSize], data...),
+		"lz4 shuffled": bloscBuffer(2, bloscLZ4<<5|bloscDontSplit|bloscShuffle, 2, len(data),
+			lz4Literals(shuffled)),
+		"zstd bit shuffled": bloscBuffer(3, bloscZstd<<5|bloscDontSplit|bloscBitShuffle, 2, len(data),
+			zstdpool.Encoder().EncodeAll(bitshuffle(data, 2), nil)),
+		"zstd split": bloscBuffer(2, bloscZstd<<5|bloscShuffle, 2, len(data),
+			zstdpool.Encoder().EncodeAll(shuffled[:half], nil), zstdpool.Encoder().EncodeAll(shuffled[half:], nil)),
+		"stored split": bloscBuffer(2, bloscLZ4<<5|bloscShuffle, 2, len(data), shuffled[:half], shuffled[half:]),
+		"encodeBlosc":  encodeBlosc(data, 2),
+	} {
+		got, err := decodeBlosc(buffer)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: decoded data differs", name)
+		}
+	}
+
+	blosclz := bloscBuffer(2, bloscLZ<<5|bloscDontSplit, 2, len(data), data)
+	if _, err := decodeBlosc(blosclz); err == nil {
+		t.Error("decodeBlosc of a BloscLZ buffer succeeded")
+	}
+}
@@ -0,0 +1,252 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go_raster_eval/zstdpool"
+)
+
+type sample interface {
+	~uint8 | ~int16 | ~uint16 | ~int32 | ~uint32 | ~float32 | ~float64
+}
+
+// codec is a bytes to bytes codec, compressing or checksumming chunks.
+type codec struct {
+	name  string
+	level int
+	// cname and shuffle configure blosc: the compressor blosc uses and
+	// 0 for no shuffle, 1 for byte shuffle and 2 for bit shuffle.
+	cname   string
+	shuffle int
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// decode undoes the codec. size is the number of bytes of the decoded
+// chunk.
+func (c codec) decode(data []byte, size int) ([]byte, error) {
+	switch c.name {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAll(r, size)
+	case "zlib":
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAll(r, size)
+	case "zstd":
+		return zstdpool.Decoder().DecodeAll(data, make([]byte, 0, size))
+	case "blosc":
+		return decodeBlosc(data)
+	case "crc32c":
+		if len(data) < 4 {
+			return nil, fmt.Errorf("chunk too short for its checksum")
+		}
+		n := len(data) - 4
+		if crc32.Checksum(data[:n], castagnoli) != binary.LittleEndian.Uint32(data[n:]) {
+			return nil, fmt.Errorf("checksum mismatch")
+		}
+		return data[:n], nil
+	}
+	return nil, fmt.Errorf("unsupported codec %q", c.name)
+}
+
+func readAll(r io.Reader, size int) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, size))
+	_, err := out.ReadFrom(r)
+	return out.Bytes(), err
+}
+
+// newSamples allocates n elements of type t, booleans being stored as
+// bytes.
+func newSamples(t DataType, n int) interface{} {
+	switch t {
+	case Int16:
+		return make([]int16, n)
+	case Uint16:
+		return make([]uint16, n)
+	case Int32:
+		return make([]int32, n)
+	case Uint32:
+		return make([]uint32, n)
+	case Float32:
+		return make([]float32, n)
+	case Float64:
+		return make([]float64, n)
+	}
+	return make([]uint8, n)
+}
+
+// Read reads the 2D slice of the array at index, which holds the positions
+// along all the dimensions but the last two, and returns the w*h elements
+// of its window at x, y, which must lie within the slice. The last two
+// dimensions of the array are the rows and columns of the slice. The
+// elements are returned in row major order, in the slice type matching the
+// DataType of the array, booleans as uint8. Only the chunks overlapping the
+// window are read.
+func (a *Array) Read(index []int, x, y, w, h int) (interface{}, error) {
+	dims := len(a.Shape)
+	if len(index) != dims-2 {
+		return nil, fmt.Errorf("index %v of %d dimensions for an array of %d", index, len(index), dims)
+	}
+	for i, v := range index {
+		if v < 0 || v >= a.Shape[i] {
+			return nil, fmt.Errorf("index %v outside array of shape %v", index, a.Shape)
+		}
+	}
+	width, height := a.Shape[dims-1], a.Shape[dims-2]
+	if x < 0 || y < 0 || w <= 0 || h <= 0 || x+w > width || y+h > height {
+		return nil, fmt.Errorf("window %d %d %d %d outside %dx%d slice", x, y, w, h, width, height)
+	}
+
+	// The chunk coordinates along the leading dimensions, and the offset
+	// of the slice within those chunks.
+	chunk := make([]int, dims)
+	offset, stride := 0, 1
+	for i := dims - 1; i >= 0; i-- {
+		if i < dims-2 {
+			chunk[i] = index[i] / a.Chunks[i]
+			offset += index[i] % a.Chunks[i] * stride
+		}
+		stride *= a.Chunks[i]
+	}
+	chunkWidth, chunkHeight := a.Chunks[dims-1], a.Chunks[dims-2]
+
+	out := newSamples(a.DataType, w*h)
+	for cy := y / chunkHeight; cy*chunkHeight < y+h; cy++ {
+		for cx := x / chunkWidth; cx*chunkWidth < x+w; cx++ {
+			chunk[dims-2], chunk[dims-1] = cy, cx
+			block, err := a.readChunk(chunk)
+			if err != nil {
+				return nil, err
+			}
+
+			x0, y0 := max(x, cx*chunkWidth), max(y, cy*chunkHeight)
+			x1, y1 := min(x+w, (cx+1)*chunkWidth), min(y+h, (cy+1)*chunkHeight)
+			p := placement{
+				srcOff: offset + (y0-cy*chunkHeight)*chunkWidth + x0 - cx*chunkWidth,
+				srcRow: chunkWidth,
+				dstOff: (y0-y)*w + x0 - x,
+				dstRow: w,
+				width:  x1 - x0,
+				height: y1 - y0,
+			}
+
+			switch out := out.(type) {
+			case []uint8:
+				place(out, block.([]uint8), p)
+			case []int16:
+				place(out, block.([]int16), p)
+			case []uint16:
+				place(out, block.([]uint16), p)
+			case []int32:
+				place(out, block.([]int32), p)
+			case []uint32:
+				place(out, block.([]uint32), p)
+			case []float32:
+				place(out, block.([]float32), p)
+			case []float64:
+				place(out, block.([]float64), p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// placement locates a rectangle of elements within a chunk and the window
+// it is copied to.
+type placement struct {
+	srcOff, srcRow int
+	dstOff, dstRow int
+	width, height  int
+}
+
+func place[T sample](dst, src []T, p placement) {
+	for row := 0; row < p.height; row++ {
+		s, d := p.srcOff+row*p.srcRow, p.dstOff+row*p.dstRow
+		copy(dst[d:d+p.width], src[s:s+p.width])
+	}
+}
+
+// chunkLen returns the number of elements of a chunk.
+func (a *Array) chunkLen() int {
+	n := 1
+	for _, size := range a.Chunks {
+		n *= size
+	}
+	return n
+}
+
+// readChunk reads and decodes the chunk at the given chunk coordinates.
+// Chunks that aren't stored hold the fill value.
+func (a *Array) readChunk(chunk []int) (interface{}, error) {
+	n := a.chunkLen()
+	block := newSamples(a.DataType, n)
+
+	path := filepath.Join(a.dir, filepath.FromSlash(a.key(chunk)))
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if a.HasFillValue {
+			fill(block, a.FillValue)
+		}
+		return block, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size := n * a.DataType.Size()
+	for i := len(a.codecs) - 1; i >= 0; i-- {
+		if data, err = a.codecs[i].decode(data, size); err != nil {
+			return nil, fmt.Errorf("%v: %v: %v", path, a.codecs[i].name, err)
+		}
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("%v: chunk holds %d bytes, want %d", path, len(data), size)
+	}
+	if err := binary.Read(bytes.NewReader(data), a.order, block); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return block, nil
+}
+
+// fill sets every element of block to v.
+func fill(block interface{}, v float64) {
+	switch block := block.(type) {
+	case []uint8:
+		fillSamples(block, uint8(v))
+	case []int16:
+		fillSamples(block, int16(v))
+	case []uint16:
+		fillSamples(block, uint16(v))
+	case []int32:
+		fillSamples(block, int32(v))
+	case []uint32:
+		fillSamples(block, uint32(v))
+	case []float32:
+		fillSamples(block, float32(v))
+	case []float64:
+		fillSamples(block, v)
+	}
+}
+
+func fillSamples[T sample](block []T, v T) {
+	for i := range block {
+		block[i] = v
+	}
+}
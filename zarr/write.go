@@ -0,0 +1,232 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"go_raster_eval/zstdpool"
+)
+
+// Raster is a 2D array to write, its elements in row major order in the
+// slice type matching DataType, booleans as uint8. Stacks of several bands
+// hold them one after the other, and are written as 3D arrays of band, y
+// and x.
+type Raster struct {
+	Width, Height int
+	// Bands is the number of bands of a stack, zero for a 2D array.
+	Bands    int
+	DataType DataType
+	Data     interface{}
+}
+
+// Options configure WriteArray.
+type Options struct {
+	// ChunkSize is the side of the square chunks.
+	ChunkSize int
+	// Compression is "none", "gzip", "zstd" or "blosc", the last
+	// compressing byte shuffled chunks with zstd.
+	Compression string
+}
+
+// gzipLevel is the level of gzip compression, the default of zlib.
+const gzipLevel = 6
+
+// WriteArray writes r as a version 3 array in the directory store dir,
+// creating it if needed. Elements of edge chunks beyond r hold fillValue,
+// and chunks holding nothing else are not stored. The attributes are
+// stored with the array.
+func WriteArray(dir string, r Raster, fillValue float64, attributes map[string]interface{}, options Options) error {
+	if options.ChunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", options.ChunkSize)
+	}
+
+	codecs := []codecV3{{Name: "bytes", Configuration: mustJSON(map[string]string{"endian": "little"})}}
+	switch options.Compression {
+	case "none":
+	case "gzip":
+		codecs = append(codecs, codecV3{Name: "gzip", Configuration: mustJSON(map[string]int{"level": gzipLevel})})
+	case "zstd":
+		codecs = append(codecs, codecV3{Name: "zstd", Configuration: mustJSON(map[string]interface{}{"level": 0, "checksum": false})})
+	case "blosc":
+		shuffle := "shuffle"
+		if r.DataType.Size() == 1 {
+			shuffle = "noshuffle"
+		}
+		config := bloscV3{Cname: "zstd", Clevel: 5, Shuffle: shuffle, Typesize: r.DataType.Size()}
+		codecs = append(codecs, codecV3{Name: "blosc", Configuration: mustJSON(config)})
+	default:
+		return fmt.Errorf("unsupported compression %q", options.Compression)
+	}
+
+	m := metadataV3{
+		ZarrFormat:     3,
+		NodeType:       "array",
+		Shape:          []int{r.Height, r.Width},
+		DataType:       r.DataType.String(),
+		FillValue:      fillValueJSON(fillValue, r.DataType),
+		Codecs:         codecs,
+		Attributes:     attributes,
+		DimensionNames: []string{"y", "x"},
+	}
+	m.ChunkGrid.Name = "regular"
+	m.ChunkGrid.Configuration.ChunkShape = []int{options.ChunkSize, options.ChunkSize}
+	if r.Bands > 0 {
+		m.Shape = append([]int{r.Bands}, m.Shape...)
+		m.DimensionNames = append([]string{"band"}, m.DimensionNames...)
+		m.ChunkGrid.Configuration.ChunkShape = append([]int{1}, m.ChunkGrid.Configuration.ChunkShape...)
+	}
+	m.ChunkKeyEncoding.Name = "default"
+	m.ChunkKeyEncoding.Configuration.Separator = "/"
+
+	doc, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "zarr.json"), doc, 0644); err != nil {
+		return err
+	}
+
+	size := options.ChunkSize
+	for band := 0; band < max(r.Bands, 1); band++ {
+		for cy := 0; cy*size < r.Height; cy++ {
+			for cx := 0; cx*size < r.Width; cx++ {
+				chunk := []int{cy, cx}
+				if r.Bands > 0 {
+					chunk = []int{band, cy, cx}
+				}
+				if err := writeChunk(dir, r, band, chunk, size, fillValue, options.Compression); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeChunk writes the chunk of a band of r at the given chunk position,
+// unless it holds only fillValue.
+func writeChunk(dir string, r Raster, band int, chunk []int, size int, fillValue float64, compression string) error {
+	cy, cx := chunk[len(chunk)-2], chunk[len(chunk)-1]
+	block, empty, err := cutChunk(r, band, cx*size, cy*size, size, fillValue)
+	if err != nil || empty {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, block); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	switch compression {
+	case "gzip":
+		var out bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&out, gzipLevel)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data = out.Bytes()
+	case "zstd":
+		data = zstdpool.Encoder().EncodeAll(data, nil)
+	case "blosc":
+		data = encodeBlosc(data, r.DataType.Size())
+	}
+
+	path := filepath.Join(dir, "c", joinKey(chunk, string(filepath.Separator)))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// cutChunk copies the part of a band of r in the size*size chunk at x0, y0
+// into a new chunk padded with fillValue, and reports whether the chunk
+// holds only fillValue.
+func cutChunk(r Raster, band, x0, y0, size int, fillValue float64) (interface{}, bool, error) {
+	w, h := min(size, r.Width-x0), min(size, r.Height-y0)
+	block := newSamples(r.DataType, size*size)
+	fill(block, fillValue)
+	p := placement{
+		srcOff: band*r.Width*r.Height + y0*r.Width + x0,
+		srcRow: r.Width,
+		dstOff: 0,
+		dstRow: size,
+		width:  w,
+		height: h,
+	}
+
+	switch data := r.Data.(type) {
+	case []uint8:
+		place(block.([]uint8), data, p)
+		return block, isFill(block.([]uint8), fillValue), nil
+	case []int16:
+		place(block.([]int16), data, p)
+		return block, isFill(block.([]int16), fillValue), nil
+	case []uint16:
+		place(block.([]uint16), data, p)
+		return block, isFill(block.([]uint16), fillValue), nil
+	case []int32:
+		place(block.([]int32), data, p)
+		return block, isFill(block.([]int32), fillValue), nil
+	case []uint32:
+		place(block.([]uint32), data, p)
+		return block, isFill(block.([]uint32), fillValue), nil
+	case []float32:
+		place(block.([]float32), data, p)
+		return block, isFill(block.([]float32), fillValue), nil
+	case []float64:
+		place(block.([]float64), data, p)
+		return block, isFill(block.([]float64), fillValue), nil
+	}
+	return nil, false, fmt.Errorf("unsupported data %T", r.Data)
+}
+
+// isFill reports whether every element of block equals v, NaN matching
+// NaN.
+func isFill[T sample](block []T, v float64) bool {
+	nan := math.IsNaN(v)
+	for _, s := range block {
+		f := float64(s)
+		if f != v && !(nan && math.IsNaN(f)) {
+			return false
+		}
+	}
+	return true
+}
+
+// fillValueJSON encodes a fill value, non finite values as the strings
+// standing for them.
+func fillValueJSON(v float64, dataType DataType) json.RawMessage {
+	switch {
+	case dataType == Bool:
+		return mustJSON(v != 0)
+	case math.IsNaN(v):
+		return json.RawMessage(`"NaN"`)
+	case math.IsInf(v, 1):
+		return json.RawMessage(`"Infinity"`)
+	case math.IsInf(v, -1):
+		return json.RawMessage(`"-Infinity"`)
+	}
+	return mustJSON(v)
+}
+
+// mustJSON encodes values that always encode.
+func mustJSON(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,438 @@
+// Package zarr reads and writes Zarr arrays held in local directory stores.
+// It reads both the version 2 and version 3 formats, with uncompressed,
+// gzip, zlib, zstd or blosc compressed chunks, and writes version 3 arrays.
+package zarr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DataType is the type of the elements of an array.
+type DataType int
+
+const (
+	Bool DataType = iota + 1
+	Uint8
+	Int16
+	Uint16
+	Int32
+	Uint32
+	Float32
+	Float64
+)
+
+var dataTypeNames = map[DataType]string{
+	Bool:    "bool",
+	Uint8:   "uint8",
+	Int16:   "int16",
+	Uint16:  "uint16",
+	Int32:   "int32",
+	Uint32:  "uint32",
+	Float32: "float32",
+	Float64: "float64",
+}
+
+func (t DataType) String() string {
+	if name, ok := dataTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// Size returns the number of bytes of an element.
+func (t DataType) Size() int {
+	switch t {
+	case Int16, Uint16:
+		return 2
+	case Int32, Uint32, Float32:
+		return 4
+	case Float64:
+		return 8
+	}
+	return 1
+}
+
+// Array is a Zarr array opened from a directory store. Its chunks are read
+// from disk as they are needed.
+type Array struct {
+	// Shape and Chunks hold the sizes of the array and of its chunks, from
+	// the slowest varying dimension to the fastest.
+	Shape, Chunks []int
+	DataType      DataType
+	// FillValue is the value of the elements of chunks that aren't
+	// stored. HasFillValue is false when the array leaves it undefined, in
+	// which case such elements read as zero.
+	FillValue    float64
+	HasFillValue bool
+	// Attributes are the user attributes of the array.
+	Attributes map[string]interface{}
+
+	dir    string
+	order  binary.ByteOrder
+	codecs []codec
+	key    func(chunk []int) string
+}
+
+// Open opens the array stored in dir, as either a version 3 array with a
+// zarr.json document or a version 2 array with .zarray and .zattrs ones.
+func Open(dir string) (*Array, error) {
+	doc, err := ioutil.ReadFile(filepath.Join(dir, "zarr.json"))
+	if err == nil {
+		a, err := parseV3(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filepath.Join(dir, "zarr.json"), err)
+		}
+		a.dir = dir
+		return a, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	doc, err = ioutil.ReadFile(filepath.Join(dir, ".zarray"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%v is not a Zarr array, it holds neither zarr.json nor .zarray", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a, err := parseV2(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filepath.Join(dir, ".zarray"), err)
+	}
+	a.dir = dir
+
+	attrs, err := ioutil.ReadFile(filepath.Join(dir, ".zattrs"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(attrs, &a.Attributes); err != nil {
+			return nil, fmt.Errorf("%v: %v", filepath.Join(dir, ".zattrs"), err)
+		}
+	}
+	return a, nil
+}
+
+type metadataV2 struct {
+	ZarrFormat         int               `json:"zarr_format"`
+	Shape              []int             `json:"shape"`
+	Chunks             []int             `json:"chunks"`
+	Dtype              string            `json:"dtype"`
+	Compressor         *compressorV2     `json:"compressor"`
+	FillValue          json.RawMessage   `json:"fill_value"`
+	Order              string            `json:"order"`
+	Filters            []json.RawMessage `json:"filters"`
+	DimensionSeparator string            `json:"dimension_separator"`
+}
+
+// compressorV2 holds the numcodecs configuration of a compressor.
+type compressorV2 struct {
+	ID      string `json:"id"`
+	Level   int    `json:"level"`
+	Cname   string `json:"cname"`
+	Clevel  int    `json:"clevel"`
+	Shuffle int    `json:"shuffle"`
+}
+
+// dtypesV2 maps the NumPy type strings of version 2 arrays, without their
+// byte order, to data types.
+var dtypesV2 = map[string]DataType{
+	"b1": Bool,
+	"u1": Uint8,
+	"i2": Int16,
+	"u2": Uint16,
+	"i4": Int32,
+	"u4": Uint32,
+	"f4": Float32,
+	"f8": Float64,
+}
+
+func parseV2(doc []byte) (*Array, error) {
+	var m metadataV2
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return nil, err
+	}
+	if m.ZarrFormat != 2 {
+		return nil, fmt.Errorf("unsupported zarr_format %d", m.ZarrFormat)
+	}
+	if m.Order != "" && m.Order != "C" {
+		return nil, fmt.Errorf("unsupported order %q, only C is", m.Order)
+	}
+	if len(m.Filters) > 0 {
+		return nil, fmt.Errorf("filters are not supported")
+	}
+
+	a := &Array{Shape: m.Shape, Chunks: m.Chunks, order: binary.LittleEndian}
+	if len(m.Dtype) != 3 {
+		return nil, fmt.Errorf("unsupported dtype %q", m.Dtype)
+	}
+	dataType, ok := dtypesV2[m.Dtype[1:]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported dtype %q", m.Dtype)
+	}
+	a.DataType = dataType
+	if m.Dtype[0] == '>' {
+		a.order = binary.BigEndian
+	}
+
+	if c := m.Compressor; c != nil {
+		switch c.ID {
+		case "gzip", "zlib", "zstd":
+			a.codecs = append(a.codecs, codec{name: c.ID, level: c.Level})
+		case "blosc":
+			a.codecs = append(a.codecs, codec{name: c.ID, level: c.Clevel, cname: c.Cname, shuffle: c.Shuffle})
+		default:
+			return nil, fmt.Errorf("unsupported compressor %q", c.ID)
+		}
+	}
+
+	separator := m.DimensionSeparator
+	if separator == "" {
+		separator = "."
+	}
+	a.key = func(chunk []int) string { return joinKey(chunk, separator) }
+
+	var err error
+	a.FillValue, a.HasFillValue, err = parseFillValue(m.FillValue)
+	if err != nil {
+		return nil, err
+	}
+	return a, a.check()
+}
+
+type metadataV3 struct {
+	ZarrFormat int    `json:"zarr_format"`
+	NodeType   string `json:"node_type"`
+	Shape      []int  `json:"shape"`
+	DataType   string `json:"data_type"`
+	ChunkGrid  struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			ChunkShape []int `json:"chunk_shape"`
+		} `json:"configuration"`
+	} `json:"chunk_grid"`
+	ChunkKeyEncoding struct {
+		Name          string `json:"name"`
+		Configuration struct {
+			Separator string `json:"separator"`
+		} `json:"configuration"`
+	} `json:"chunk_key_encoding"`
+	FillValue  json.RawMessage        `json:"fill_value"`
+	Codecs     []codecV3              `json:"codecs"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// DimensionNames are only written.
+	DimensionNames []string `json:"dimension_names,omitempty"`
+}
+
+type codecV3 struct {
+	Name          string          `json:"name"`
+	Configuration json.RawMessage `json:"configuration,omitempty"`
+}
+
+// bloscV3 is the configuration of the blosc codec of version 3 arrays.
+type bloscV3 struct {
+	Cname     string `json:"cname"`
+	Clevel    int    `json:"clevel"`
+	Shuffle   string `json:"shuffle"`
+	Typesize  int    `json:"typesize,omitempty"`
+	Blocksize int    `json:"blocksize"`
+}
+
+// bloscShuffles maps the shuffle names of version 3 to the numbers of
+// numcodecs and version 2.
+var bloscShuffles = map[string]int{
+	"noshuffle":  0,
+	"shuffle":    1,
+	"bitshuffle": 2,
+}
+
+func parseV3(doc []byte) (*Array, error) {
+	var m metadataV3
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return nil, err
+	}
+	if m.ZarrFormat != 3 {
+		return nil, fmt.Errorf("unsupported zarr_format %d", m.ZarrFormat)
+	}
+	if m.NodeType != "array" {
+		return nil, fmt.Errorf("node is a %v, not an array", m.NodeType)
+	}
+	if m.ChunkGrid.Name != "regular" {
+		return nil, fmt.Errorf("unsupported chunk grid %q", m.ChunkGrid.Name)
+	}
+
+	a := &Array{Shape: m.Shape, Chunks: m.ChunkGrid.Configuration.ChunkShape, Attributes: m.Attributes}
+	for t, name := range dataTypeNames {
+		if name == m.DataType {
+			a.DataType = t
+		}
+	}
+	if a.DataType == 0 {
+		return nil, fmt.Errorf("unsupported data type %q", m.DataType)
+	}
+
+	separator := m.ChunkKeyEncoding.Configuration.Separator
+	switch m.ChunkKeyEncoding.Name {
+	case "default":
+		if separator == "" {
+			separator = "/"
+		}
+		a.key = func(chunk []int) string { return "c" + separator + joinKey(chunk, separator) }
+	case "v2":
+		if separator == "" {
+			separator = "."
+		}
+		a.key = func(chunk []int) string { return joinKey(chunk, separator) }
+	default:
+		return nil, fmt.Errorf("unsupported chunk key encoding %q", m.ChunkKeyEncoding.Name)
+	}
+
+	// The codecs are the bytes codec, turning the array into bytes,
+	// followed by those compressing or checksumming the bytes.
+	bytesCodec := false
+	for _, c := range m.Codecs {
+		switch c.Name {
+		case "bytes":
+			var config struct {
+				Endian string `json:"endian"`
+			}
+			if len(c.Configuration) > 0 {
+				if err := json.Unmarshal(c.Configuration, &config); err != nil {
+					return nil, fmt.Errorf("bytes codec: %v", err)
+				}
+			}
+			a.order = binary.LittleEndian
+			if config.Endian == "big" {
+				a.order = binary.BigEndian
+			}
+			bytesCodec = true
+		case "gzip", "zstd":
+			var config struct {
+				Level int `json:"level"`
+			}
+			if len(c.Configuration) > 0 {
+				if err := json.Unmarshal(c.Configuration, &config); err != nil {
+					return nil, fmt.Errorf("%v codec: %v", c.Name, err)
+				}
+			}
+			a.codecs = append(a.codecs, codec{name: c.Name, level: config.Level})
+		case "blosc":
+			var config bloscV3
+			if err := json.Unmarshal(c.Configuration, &config); err != nil {
+				return nil, fmt.Errorf("blosc codec: %v", err)
+			}
+			a.codecs = append(a.codecs, codec{name: c.Name, level: config.Clevel, cname: config.Cname, shuffle: bloscShuffles[config.Shuffle]})
+		case "crc32c":
+			a.codecs = append(a.codecs, codec{name: c.Name})
+		default:
+			return nil, fmt.Errorf("unsupported codec %q", c.Name)
+		}
+		if c.Name != "bytes" && !bytesCodec {
+			return nil, fmt.Errorf("unsupported codec %q before the bytes codec", c.Name)
+		}
+	}
+	if !bytesCodec {
+		return nil, fmt.Errorf("no bytes codec")
+	}
+
+	var err error
+	a.FillValue, a.HasFillValue, err = parseFillValue(m.FillValue)
+	if err != nil {
+		return nil, err
+	}
+	if a.HasFillValue && strings.HasPrefix(string(m.FillValue), `"0x`) {
+		a.FillValue, err = fillValueBits(m.FillValue, a.DataType)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, a.check()
+}
+
+func (a *Array) check() error {
+	if len(a.Shape) < 2 {
+		return fmt.Errorf("array has %d dimensions, at least 2 are needed", len(a.Shape))
+	}
+	if len(a.Chunks) != len(a.Shape) {
+		return fmt.Errorf("chunks have %d dimensions, array has %d", len(a.Chunks), len(a.Shape))
+	}
+	for _, size := range a.Chunks {
+		if size <= 0 {
+			return fmt.Errorf("invalid chunk shape %v", a.Chunks)
+		}
+	}
+	return nil
+}
+
+func joinKey(chunk []int, separator string) string {
+	parts := make([]string, len(chunk))
+	for i, c := range chunk {
+		parts[i] = strconv.Itoa(c)
+	}
+	return strings.Join(parts, separator)
+}
+
+// parseFillValue parses a fill value, a number, a boolean, one of the
+// strings standing for non finite values or null.
+func parseFillValue(raw json.RawMessage) (float64, bool, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, false, nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, false, fmt.Errorf("fill_value: %v", err)
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, true, nil
+	case bool:
+		if v {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	case string:
+		switch v {
+		case "NaN":
+			return math.NaN(), true, nil
+		case "Infinity":
+			return math.Inf(1), true, nil
+		case "-Infinity":
+			return math.Inf(-1), true, nil
+		}
+		if strings.HasPrefix(v, "0x") {
+			// Decoded by fillValueBits once the data type is known.
+			return 0, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("invalid fill_value %s", raw)
+}
+
+// fillValueBits decodes a floating point fill value given as the hex
+// string of its bits.
+func fillValueBits(raw json.RawMessage, dataType DataType) (float64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	bits, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fill_value %q", s)
+	}
+	switch dataType {
+	case Float32:
+		return float64(math.Float32frombits(uint32(bits))), nil
+	case Float64:
+		return math.Float64frombits(bits), nil
+	}
+	return 0, fmt.Errorf("fill_value %q given as bits for %v array", s, dataType)
+}
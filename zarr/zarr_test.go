@@ -0,0 +1,183 @@
+package zarr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go_raster_eval/zstdpool"
+)
+
+// testRaster returns a width*height UINT16 raster whose top left 16x16
+// block holds only fillValue.
+func testRaster(width, height int, fillValue uint16) []uint16 {
+	data := make([]uint16, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			data[y*width+x] = uint16(y*1000 + x)
+			if x < 16 && y < 16 {
+				data[y*width+x] = fillValue
+			}
+		}
+	}
+	return data
+}
+
+func TestWriteRead(t *testing.T) {
+	width, height := 37, 23
+	data := testRaster(width, height, 9)
+
+	for _, compression := range []string{"none", "gzip", "zstd", "blosc"} {
+		dir := filepath.Join(t.TempDir(), "a.zarr")
+		attributes := map[string]interface{}{"name": "B1"}
+		r := Raster{Width: width, Height: height, DataType: Uint16, Data: data}
+		if err := WriteArray(dir, r, 9, attributes, Options{ChunkSize: 16, Compression: compression}); err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "c", "0", "0")); !os.IsNotExist(err) {
+			t.Errorf("%s: chunk holding only the fill value was stored", compression)
+		}
+
+		a, err := Open(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if !reflect.DeepEqual(a.Shape, []int{height, width}) || a.DataType != Uint16 || a.FillValue != 9 || !a.HasFillValue {
+			t.Errorf("%s: array of shape %v, %v, fill value %v", compression, a.Shape, a.DataType, a.FillValue)
+		}
+		if a.Attributes["name"] != "B1" {
+			t.Errorf("%s: attributes %v", compression, a.Attributes)
+		}
+		got, err := a.Read(nil, 0, 0, width, height)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: read data differs from written", compression)
+		}
+
+		window, err := a.Read(nil, 10, 5, 20, 12)
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if w := window.([]uint16); w[0] != data[5*width+10] || w[len(w)-1] != data[16*width+29] {
+			t.Errorf("%s: window corners %v %v", compression, w[0], w[len(w)-1])
+		}
+	}
+}
+
+func TestWriteReadStack(t *testing.T) {
+	width, height := 20, 10
+	data := make([]float32, 3*width*height)
+	for i := range data {
+		data[i] = float32(i) / 4
+	}
+	dir := filepath.Join(t.TempDir(), "s.zarr")
+	r := Raster{Width: width, Height: height, Bands: 3, DataType: Float32, Data: data}
+	if err := WriteArray(dir, r, -1, nil, Options{ChunkSize: 16, Compression: "blosc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.Read([]int{1}, 0, 0, width, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data[width*height:2*width*height]) {
+		t.Error("second band differs from written")
+	}
+}
+
+// writeV2 writes a version 2 array of data, of shape height*width in
+// 16x16 chunks, compressing each chunk with compress.
+func writeV2(t *testing.T, dir string, width, height int, data []uint16, compressor string, compress func([]byte) []byte) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	zarray := fmt.Sprintf(`{"zarr_format": 2, "shape": [%d, %d], "chunks": [16, 16], "dtype": "<u2", "compressor": %s, "fill_value": 0, "order": "C", "filters": null}`, height, width, compressor)
+	if err := os.WriteFile(filepath.Join(dir, ".zarray"), []byte(zarray), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".zattrs"), []byte(`{"units": "dn"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for cy := 0; cy*16 < height; cy++ {
+		for cx := 0; cx*16 < width; cx++ {
+			// Edge chunks are stored whole, padded with the fill value.
+			chunk := make([]uint16, 16*16)
+			for y := 0; y < 16 && cy*16+y < height; y++ {
+				for x := 0; x < 16 && cx*16+x < width; x++ {
+					chunk[y*16+x] = data[(cy*16+y)*width+cx*16+x]
+				}
+			}
+			raw := make([]byte, 2*len(chunk))
+			for i, v := range chunk {
+				binary.LittleEndian.PutUint16(raw[2*i:], v)
+			}
+			name := filepath.Join(dir, fmt.Sprintf("%d.%d", cy, cx))
+			if err := os.WriteFile(name, compress(raw), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	width, height := 24, 20
+	data := testRaster(width, height, 0)
+
+	zstdCompress := func(raw []byte) []byte { return zstdpool.Encoder().EncodeAll(raw, nil) }
+	for name, c := range map[string]struct {
+		compressor string
+		compress   func([]byte) []byte
+	}{
+		"none": {"null", func(raw []byte) []byte { return raw }},
+		"gzip": {`{"id": "gzip", "level": 1}`, func(raw []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(raw)
+			w.Close()
+			return buf.Bytes()
+		}},
+		"zstd": {`{"id": "zstd", "level": 1}`, zstdCompress},
+		"blosc lz4 shuffle": {`{"id": "blosc", "cname": "lz4", "clevel": 5, "shuffle": 1}`, func(raw []byte) []byte {
+			shuffled := make([]byte, len(raw))
+			shuffle(shuffled, raw, 2)
+			return bloscBuffer(2, bloscLZ4<<5|bloscDontSplit|bloscShuffle, 2, len(raw), lz4Literals(shuffled))
+		}},
+		"blosc zstd shuffle": {`{"id": "blosc", "cname": "zstd", "clevel": 5, "shuffle": 1}`, func(raw []byte) []byte {
+			return encodeBlosc(raw, 2)
+		}},
+		"blosc zstd bitshuffle": {`{"id": "blosc", "cname": "zstd", "clevel": 5, "shuffle": 2}`, func(raw []byte) []byte {
+			return bloscBuffer(2, bloscZstd<<5|bloscDontSplit|bloscBitShuffle, 2, len(raw), zstdCompress(bitshuffle(raw, 2)))
+		}},
+	} {
+		dir := filepath.Join(t.TempDir(), "a.zarr")
+		writeV2(t, dir, width, height, data, c.compressor, c.compress)
+
+		a, err := Open(dir)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if a.Attributes["units"] != "dn" {
+			t.Errorf("%s: attributes %v", name, a.Attributes)
+		}
+		got, err := a.Read(nil, 0, 0, width, height)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: read data differs from written", name)
+		}
+	}
+}
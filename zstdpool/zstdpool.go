@@ -0,0 +1,35 @@
+// Package zstdpool holds the Zstandard encoder and decoder shared by the
+// readers and writers of the file formats compressing data with zstd.
+package zstdpool
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+)
+
+// Encoder returns the shared Zstandard encoder, which is safe for
+// concurrent EncodeAll calls.
+func Encoder() *zstd.Encoder {
+	once.Do(initZstd)
+	return encoder
+}
+
+// Decoder returns the shared Zstandard decoder, which is safe for
+// concurrent DecodeAll calls.
+func Decoder() *zstd.Decoder {
+	once.Do(initZstd)
+	return decoder
+}
+
+func initZstd() {
+	// Neither constructor fails without options.
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+}